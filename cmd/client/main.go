@@ -14,77 +14,55 @@ limitations under the License.
 package main
 
 import (
-	"bufio"
 	"context"
-	"encoding/base64"
 	"flag"
 	"fmt"
-	"log"
 	"os"
-	"strings"
+	"time"
 
-	pb "k8s.io/kms/apis/v1beta1"
+	"sigs.k8s.io/aws-encryption-provider/pkg/client"
 	"sigs.k8s.io/aws-encryption-provider/pkg/connection"
 )
 
 var (
-	addr = flag.String("listen", "/tmp/awsencryptionprovider.sock", "GRPC listen address")
+	addr       = flag.String("listen", "/tmp/awsencryptionprovider.sock", "GRPC listen address")
+	apiVersion = flag.String("api-version", client.APIVersionV2, "KMS API version to start with: v1 or v2")
+	timeout    = flag.Duration("timeout", 10*time.Second, "deadline of each call to the plugin")
 )
 
 func main() {
 	flag.Parse()
+	if *apiVersion != client.APIVersionV1 && *apiVersion != client.APIVersionV2 {
+		fmt.Fprintf(os.Stderr, "invalid --api-version %q, must be %s or %s\n", *apiVersion, client.APIVersionV1, client.APIVersionV2)
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
 
 	conn, err := connection.New(*addr)
 	if err != nil {
-		log.Fatalf("Failed to initialize client: %v", err)
+		fmt.Fprintf(os.Stderr, "Failed to initialize client: %v\n", err)
+		os.Exit(1)
 	}
 	defer conn.Close() //nolint:errcheck
 
-	client := pb.NewKeyManagementServiceClient(conn)
+	clients := map[string]client.Client{}
+	for _, v := range []string{client.APIVersionV1, client.APIVersionV2} {
+		// the version is set, creating the client does not call the plugin
+		if clients[v], err = client.NewFromConn(ctx, conn, client.Options{APIVersion: v, Timeout: *timeout}); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to initialize client: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
 	fmt.Println("Welcome to GRPC Client")
 	fmt.Println("----------------------")
 
-	ctx := context.Background()
-
-	vReq := &pb.VersionRequest{}
-	vRes, err := client.Version(ctx, vReq)
-	if err != nil {
-		log.Fatalf("Failed to get version: %v", err)
-	}
-
-	fmt.Println("Connected to GRPC Server", vRes.Version, vRes.RuntimeName, vRes.RuntimeVersion)
-
-	reader := bufio.NewReader(os.Stdin)
-	fmt.Print("encrypt <string>\ndecrypt <string>\n")
-	for {
-		fmt.Print("->")
-		text, _ := reader.ReadString('\n')
-		text = strings.ReplaceAll(text, "\n", "")
-
-		splits := strings.SplitN(text, " ", 2)
-
-		switch splits[0] {
-		case "encrypt":
-
-			eReq := &pb.EncryptRequest{Plain: []byte(splits[1])}
-			res, err := client.Encrypt(ctx, eReq)
-			if err != nil {
-				log.Fatalf("Failed to encrypt: %v", err)
-			}
-			fmt.Println(base64.StdEncoding.EncodeToString(res.Cipher))
-		case "decrypt":
-			b, err := base64.StdEncoding.DecodeString(splits[1])
-			if err != nil {
-				log.Fatalf("Failed to decode: %v", err)
-			}
-
-			dReq := &pb.DecryptRequest{Cipher: b}
-			res, err := client.Decrypt(ctx, dReq)
-			if err != nil {
-				log.Fatalf("Failed to encrypt: %v", err)
-			}
-			fmt.Println(string(res.Plain))
-		}
+	r := newREPL(clients, *apiVersion, os.Stdout, os.Stderr)
+	// the plugin may not be up yet, commands keep retrying until their deadline
+	if err := r.status(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 	}
+	fmt.Println("Run help for the list of commands")
+	r.run(ctx, os.Stdin)
 }
@@ -0,0 +1,344 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"sigs.k8s.io/aws-encryption-provider/pkg/client"
+)
+
+const help = `Commands:
+  encrypt <data>              encrypt data, text unless prefixed with hex:, base64: or file:
+  decrypt <data> [key-id]     decrypt data, base64 unless prefixed with hex:, text: or file:
+  status                      report the plugin version and health
+  api <v1|v2>                 switch the KMS API version
+  output <auto|text|hex|base64>
+                              format to print data in, auto prints plaintexts as text
+                              when printable and base64 otherwise
+  save <file>                 write the data of the last call to file
+  history                     list the previous commands
+  !<n>, !!                    run command n of history, or the last one
+  help                        print this help
+  quit, exit                  leave
+`
+
+// Formats data is printed in
+const (
+	outputAuto   = "auto"
+	outputText   = "text"
+	outputHex    = "hex"
+	outputBase64 = "base64"
+)
+
+// maxLineSize bounds a command line, ciphertexts are pasted in base64
+const maxLineSize = 1 << 20
+
+// repl reads commands and calls the plugin with either KMS API version,
+// printing failures instead of exiting
+type repl struct {
+	clients    map[string]client.Client
+	apiVersion string
+	output     string
+	out        io.Writer
+	err        io.Writer
+
+	history []string
+	// last is the data returned by the last call, for save
+	last []byte
+	// keyID is the key the v2 plugin last reported, to decrypt ciphertexts
+	// that were not encrypted in this session
+	keyID string
+	// encrypted are the responses of the v2 encryptions of this session by
+	// ciphertext, to decrypt them with their key ID and annotations
+	encrypted map[string]*client.EncryptResponse
+}
+
+func newREPL(clients map[string]client.Client, apiVersion string, out, errOut io.Writer) *repl {
+	return &repl{
+		clients:    clients,
+		apiVersion: apiVersion,
+		output:     outputAuto,
+		out:        out,
+		err:        errOut,
+		encrypted:  map[string]*client.EncryptResponse{},
+	}
+}
+
+// run executes the commands read from in until quit or the end of in
+func (r *repl) run(ctx context.Context, in io.Reader) {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	for {
+		fmt.Fprintf(r.out, "%s> ", r.apiVersion)
+		if !scanner.Scan() {
+			fmt.Fprintln(r.out)
+			if err := scanner.Err(); err != nil {
+				fmt.Fprintf(r.err, "error: %v\n", err)
+			}
+			return
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "!") {
+			expanded, err := r.expand(line)
+			if err != nil {
+				fmt.Fprintf(r.err, "error: %v\n", err)
+				continue
+			}
+			fmt.Fprintln(r.out, expanded)
+			line = expanded
+		}
+		r.history = append(r.history, line)
+		if line == "quit" || line == "exit" {
+			return
+		}
+		if err := r.exec(ctx, line); err != nil {
+			fmt.Fprintf(r.err, "error: %v\n", err)
+		}
+	}
+}
+
+// expand returns the command of history a !<n> or !! line refers to
+func (r *repl) expand(line string) (string, error) {
+	if len(r.history) == 0 {
+		return "", errors.New("history is empty")
+	}
+	if line == "!!" {
+		return r.history[len(r.history)-1], nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 1 || n > len(r.history) {
+		return "", fmt.Errorf("no command %s in history", line[1:])
+	}
+	return r.history[n-1], nil
+}
+
+func (r *repl) exec(ctx context.Context, line string) error {
+	cmd, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+	switch cmd {
+	case "encrypt":
+		return r.encrypt(ctx, arg)
+	case "decrypt":
+		return r.decrypt(ctx, arg)
+	case "status":
+		return r.status(ctx)
+	case "api":
+		if _, ok := r.clients[arg]; !ok {
+			return fmt.Errorf("usage: api <%s|%s>", client.APIVersionV1, client.APIVersionV2)
+		}
+		r.apiVersion = arg
+		return nil
+	case "output":
+		switch arg {
+		case outputAuto, outputText, outputHex, outputBase64:
+			r.output = arg
+			return nil
+		}
+		return errors.New("usage: output <auto|text|hex|base64>")
+	case "save":
+		if arg == "" {
+			return errors.New("usage: save <file>")
+		}
+		if r.last == nil {
+			return errors.New("no data to save yet")
+		}
+		if err := os.WriteFile(arg, r.last, 0600); err != nil {
+			return err
+		}
+		fmt.Fprintf(r.out, "%d bytes written to %s\n", len(r.last), arg)
+		return nil
+	case "history":
+		for i, h := range r.history {
+			fmt.Fprintf(r.out, "%4d  %s\n", i+1, h)
+		}
+		return nil
+	case "help":
+		fmt.Fprint(r.out, help)
+		return nil
+	}
+	return fmt.Errorf("unknown command %q, run help for the list of commands", cmd)
+}
+
+func (r *repl) encrypt(ctx context.Context, arg string) error {
+	if arg == "" {
+		return errors.New("usage: encrypt <data>")
+	}
+	plaintext, err := decodeInput(arg, outputText)
+	if err != nil {
+		return err
+	}
+	c := r.clients[r.apiVersion]
+	start := time.Now()
+	res, err := c.Encrypt(ctx, client.NewUID(), plaintext)
+	took := time.Since(start)
+	if err != nil {
+		return fmt.Errorf("encrypt failed after %s: %v", took, err)
+	}
+	if c.APIVersion() == client.APIVersionV2 {
+		r.encrypted[string(res.Ciphertext)] = res
+	}
+	r.last = res.Ciphertext
+
+	fmt.Fprintf(r.out, "ciphertext:  %s\n", r.format(res.Ciphertext, false))
+	if res.KeyID != "" {
+		fmt.Fprintf(r.out, "key ID:      %s\n", res.KeyID)
+	}
+	printAnnotations(r.out, res.Annotations)
+	fmt.Fprintf(r.out, "took:        %s\n", took)
+	return nil
+}
+
+func (r *repl) decrypt(ctx context.Context, arg string) error {
+	fields := strings.Fields(arg)
+	if len(fields) == 0 || len(fields) > 2 {
+		return errors.New("usage: decrypt <data> [key-id]")
+	}
+	ciphertext, err := decodeInput(fields[0], outputBase64)
+	if err != nil {
+		return err
+	}
+	// the v2 API takes the key ID and annotations returned with the ciphertext
+	keyID, annotations := r.keyID, map[string][]byte(nil)
+	if res, ok := r.encrypted[string(ciphertext)]; ok {
+		keyID, annotations = res.KeyID, res.Annotations
+	}
+	if len(fields) == 2 {
+		keyID = fields[1]
+	}
+
+	start := time.Now()
+	plaintext, err := r.clients[r.apiVersion].Decrypt(ctx, client.NewUID(), ciphertext, keyID, annotations)
+	took := time.Since(start)
+	if err != nil {
+		return fmt.Errorf("decrypt failed after %s: %v", took, err)
+	}
+	r.last = plaintext
+
+	fmt.Fprintf(r.out, "plaintext:   %s\n", r.format(plaintext, true))
+	fmt.Fprintf(r.out, "took:        %s\n", took)
+	return nil
+}
+
+func (r *repl) status(ctx context.Context) error {
+	start := time.Now()
+	res, err := r.clients[r.apiVersion].Status(ctx)
+	took := time.Since(start)
+	if err != nil {
+		return fmt.Errorf("status failed after %s: %v", took, err)
+	}
+	if res.KeyID != "" {
+		r.keyID = res.KeyID
+	}
+	printStatus(r.out, res)
+	fmt.Fprintf(r.out, "took:        %s\n", took)
+	return nil
+}
+
+func printStatus(w io.Writer, res *client.StatusResponse) {
+	fmt.Fprintf(w, "API version: %s\n", res.APIVersion)
+	fmt.Fprintf(w, "version:     %s\n", res.Version)
+	if res.RuntimeName != "" {
+		fmt.Fprintf(w, "runtime:     %s %s\n", res.RuntimeName, res.RuntimeVersion)
+	}
+	if res.Healthz != "" {
+		fmt.Fprintf(w, "healthz:     %s\n", res.Healthz)
+	}
+	if res.KeyID != "" {
+		fmt.Fprintf(w, "key ID:      %s\n", res.KeyID)
+	}
+}
+
+func printAnnotations(w io.Writer, annotations map[string][]byte) {
+	keys := make([]string, 0, len(annotations))
+	for k := range annotations {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "annotation:  %s=%s\n", k, base64.StdEncoding.EncodeToString(annotations[k]))
+	}
+}
+
+// format prints data in the output format, plaintexts are printed as text in
+// auto format when they are printable
+func (r *repl) format(data []byte, plaintext bool) string {
+	switch {
+	case r.output == outputText, r.output == outputAuto && plaintext && printable(data):
+		return strconv.Quote(string(data))
+	case r.output == outputHex:
+		return "hex:" + hex.EncodeToString(data)
+	}
+	return "base64:" + base64.StdEncoding.EncodeToString(data)
+}
+
+func printable(data []byte) bool {
+	if !utf8.Valid(data) {
+		return false
+	}
+	for _, c := range string(data) {
+		if !unicode.IsPrint(c) && !unicode.IsSpace(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeInput decodes data prefixed with text:, hex:, base64: or file:, or
+// in format if it has none of them
+func decodeInput(data, format string) ([]byte, error) {
+	if prefix, rest, ok := strings.Cut(data, ":"); ok {
+		switch prefix {
+		case outputText, outputHex, outputBase64:
+			format, data = prefix, rest
+		case "file":
+			b, err := os.ReadFile(rest)
+			if err != nil {
+				return nil, err
+			}
+			return b, nil
+		}
+	}
+	switch format {
+	case outputHex:
+		b, err := hex.DecodeString(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex: %v", err)
+		}
+		return b, nil
+	case outputBase64:
+		b, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64: %v", err)
+		}
+		return b, nil
+	}
+	return []byte(data), nil
+}
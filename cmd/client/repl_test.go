@@ -0,0 +1,164 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/aws-encryption-provider/pkg/client"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+	"sigs.k8s.io/aws-encryption-provider/pkg/connection"
+	"sigs.k8s.io/aws-encryption-provider/pkg/plugin"
+)
+
+const testKey = "arn:aws:kms:us-west-2:111122223333:key/client"
+
+// startREPL serves the v1 and v2 plugins backed by c, and returns a REPL
+// calling them that writes to out and errOut
+func startREPL(t *testing.T, c *cloud.KMSMock, out, errOut *bytes.Buffer) *repl {
+	t.Helper()
+	s, err := plugin.NewLocalServer(testKey, c, plugin.LocalServerOptions{})
+	require.NoError(t, err)
+	t.Cleanup(s.Stop)
+	require.NoError(t, s.Start())
+	conn, err := connection.New(s.Addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	clients := map[string]client.Client{}
+	for _, v := range []string{client.APIVersionV1, client.APIVersionV2} {
+		clients[v], err = client.NewFromConn(context.Background(), conn, client.Options{APIVersion: v, Timeout: 5 * time.Second})
+		require.NoError(t, err)
+	}
+	return newREPL(clients, client.APIVersionV2, out, errOut)
+}
+
+func TestREPL(t *testing.T) {
+	c := &cloud.KMSMock{}
+	// the health check of status encrypts another plaintext
+	c.SetEncryptResp("c0", nil)
+	for plaintext, ciphertext := range map[string]string{"hello world": "c1", "\x00\xff": "c2", "from file": "c3"} {
+		c.AddEncryptRule(func(params *kms.EncryptInput) bool {
+			return string(params.Plaintext) == plaintext
+		}, ciphertext, nil)
+	}
+	c.SetDecryptResp("\x00\xff", nil)
+
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	require.NoError(t, os.WriteFile(in, []byte("from file"), 0600))
+	saved := filepath.Join(dir, "saved")
+
+	var out, errOut bytes.Buffer
+	r := startREPL(t, c, &out, &errOut)
+	r.run(context.Background(), strings.NewReader(strings.Join([]string{
+		"encrypt hello world",
+		"encrypt hex:00ff",
+		"encrypt base64:AP8=",
+		"encrypt file:" + in,
+		"decrypt base64:MWMy",
+		"save " + saved,
+		"output hex",
+		"decrypt MWMy",
+		"status",
+		"api v1",
+		"encrypt hello world",
+		"history",
+		"!1",
+		"quit",
+		"encrypt never run",
+	}, "\n")))
+
+	assert.Empty(t, errOut.String())
+	got := out.String()
+	assert.Contains(t, got, "ciphertext:  base64:MWMx\n", "base64 of the storage version and c1")
+	assert.Equal(t, 2, strings.Count(got, "ciphertext:  hex:316331\n"))
+	assert.Equal(t, 2, strings.Count(got, "ciphertext:  base64:MWMy\n"))
+	assert.Contains(t, got, "ciphertext:  base64:MWMz\n")
+	assert.Contains(t, got, "key ID:      "+testKey+"\n")
+	assert.Contains(t, got, `plaintext:   base64:AP8=`)
+	assert.Contains(t, got, `plaintext:   hex:00ff`)
+	assert.Contains(t, got, "healthz:     ok\n")
+	assert.Contains(t, got, "  11  encrypt hello world\n")
+	assert.Contains(t, got, "took:        ")
+	assert.NotContains(t, got, "never run")
+	data, err := os.ReadFile(saved)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("\x00\xff"), data)
+}
+
+func TestREPLErrors(t *testing.T) {
+	c := &cloud.KMSMock{}
+	c.SetEncryptResp("", errors.New("AccessDeniedException"))
+	c.SetDecryptResp("plain", nil)
+
+	var out, errOut bytes.Buffer
+	r := startREPL(t, c, &out, &errOut)
+	// every failure is reported, and the commands after it still run
+	r.run(context.Background(), strings.NewReader(strings.Join([]string{
+		"!!",
+		"encrypt",
+		"encrypt secret",
+		"decrypt",
+		"decrypt %%%",
+		"encrypt hex:zz",
+		"encrypt file:" + filepath.Join(t.TempDir(), "missing"),
+		"api v3",
+		"output yaml",
+		"save",
+		"!42",
+		"frobnicate",
+		"decrypt MXg=",
+	}, "\n")))
+
+	assert.Equal(t, strings.Join([]string{
+		"error: history is empty",
+		"error: usage: encrypt <data>",
+		"error: encrypt failed after ",
+		"error: usage: decrypt <data> [key-id]",
+		"error: invalid base64: illegal base64 data at input byte 0",
+		"error: invalid hex: encoding/hex: invalid byte: U+007A 'z'",
+		"error: open ",
+		"error: usage: api <v1|v2>",
+		"error: usage: output <auto|text|hex|base64>",
+		"error: usage: save <file>",
+		"error: no command 42 in history",
+		`error: unknown command "frobnicate", run help for the list of commands`,
+	}, "\n"), firstWords(errOut.String()))
+	assert.Contains(t, errOut.String(), "AccessDeniedException")
+	assert.Contains(t, out.String(), `plaintext:   "plain"`)
+}
+
+// firstWords keeps the beginning of each line that does not vary between runs
+func firstWords(s string) string {
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(s), "\n") {
+		for _, prefix := range []string{"error: encrypt failed after ", "error: open "} {
+			if strings.HasPrefix(line, prefix) {
+				line = prefix
+			}
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,431 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	mathrand "math/rand/v2"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sigs.k8s.io/aws-encryption-provider/pkg/client"
+	"sigs.k8s.io/aws-encryption-provider/pkg/connection"
+)
+
+// Operations bench can call, weighted by --mix
+const (
+	benchV1Encrypt = "v1-encrypt"
+	benchV1Decrypt = "v1-decrypt"
+	benchV2Encrypt = "v2-encrypt"
+	benchV2Decrypt = "v2-decrypt"
+)
+
+var benchOperations = []string{benchV1Encrypt, benchV1Decrypt, benchV2Encrypt, benchV2Decrypt}
+
+type benchOptions struct {
+	socket      string
+	timeout     time.Duration
+	mix         map[string]int
+	duration    time.Duration
+	requests    int
+	concurrency int
+	rate        float64
+	payloadSize int
+	inProcess   bool
+	mockLatency time.Duration
+	output      string
+}
+
+func (o *benchOptions) validate() error {
+	total := 0
+	for op, weight := range o.mix {
+		if !slices.Contains(benchOperations, op) {
+			return usageErrorf("invalid --mix operation %q, must be one of %s", op, strings.Join(benchOperations, ", "))
+		}
+		if weight < 0 {
+			return usageErrorf("invalid --mix weight %d for %s", weight, op)
+		}
+		total += weight
+	}
+	switch {
+	case total == 0:
+		return usageErrorf("--mix must give a positive weight to at least one operation")
+	case o.timeout <= 0:
+		return usageErrorf("--timeout must be positive")
+	case o.duration <= 0 && o.requests <= 0:
+		return usageErrorf("one of --duration or --requests must be positive")
+	case o.concurrency <= 0:
+		return usageErrorf("--concurrency must be positive")
+	case o.rate < 0 || math.IsNaN(o.rate):
+		return usageErrorf("--rate must not be negative")
+	case o.rate > 0 && rateInterval(o.rate) <= 0:
+		return usageErrorf("--rate must be at most %d calls per second", time.Second)
+	case o.payloadSize <= 0:
+		return usageErrorf("--payload-size must be positive")
+	case o.output != "text" && o.output != formatJSON:
+		return usageErrorf("invalid --output %q, must be text or json", o.output)
+	}
+	return nil
+}
+
+// rateInterval is the interval between calls at rate calls per second
+func rateInterval(rate float64) time.Duration {
+	return time.Duration(float64(time.Second) / rate)
+}
+
+// runBench drives a weighted mix of v1 and v2 calls against the plugin, at a
+// target rate or as fast as the workers allow, and reports latencies, error
+// classes and throughput
+func runBench(ctx context.Context, args []string, s streams) error {
+	o := benchOptions{}
+	fs := newFlagSet("bench", s)
+	fs.StringVar(&o.socket, "socket", defaultSocket, "path of the unix socket the plugin listens on")
+	fs.DurationVar(&o.timeout, "timeout", 10*time.Second, "deadline of each call to the plugin")
+	fs.StringToIntVar(&o.mix, "mix", map[string]int{benchV2Encrypt: 1, benchV2Decrypt: 1}, "relative weights of the operations to call, among "+strings.Join(benchOperations, ", "))
+	fs.DurationVar(&o.duration, "duration", 10*time.Second, "how long to run for, 0 to only stop after --requests")
+	fs.IntVar(&o.requests, "requests", 0, "number of calls to make, 0 to only stop after --duration")
+	fs.IntVar(&o.concurrency, "concurrency", 8, "number of calls in flight at most")
+	fs.Float64Var(&o.rate, "rate", 0, "target calls per second across workers, 0 to call as fast as --concurrency allows")
+	fs.IntVar(&o.payloadSize, "payload-size", 1024, "size in bytes of the random plaintext to encrypt")
+	fs.BoolVar(&o.inProcess, "in-process", false, "run against plugins served in-process by a mock KMS instead of --socket")
+	fs.DurationVar(&o.mockLatency, "mock-latency", 0, "latency the mock KMS answers with, with --in-process")
+	fs.StringVar(&o.output, "output", "text", "format of the report: text or json")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if err := o.validate(); err != nil {
+		return err
+	}
+	if o.inProcess && fs.Changed("socket") {
+		return usageErrorf("--socket and --in-process are mutually exclusive")
+	}
+	if !o.inProcess && fs.Changed("mock-latency") {
+		return usageErrorf("--mock-latency requires --in-process")
+	}
+
+	if o.inProcess {
+		addr, stop, err := startInProcess(o.mockLatency)
+		if err != nil {
+			return err
+		}
+		defer stop()
+		o.socket = addr
+	}
+
+	conn, err := connection.New(o.socket)
+	if err != nil {
+		return err
+	}
+	defer conn.Close() //nolint:errcheck
+	clients := map[string]client.Client{}
+	for _, apiVersion := range []string{apiV1, apiV2} {
+		if clients[apiVersion], err = client.NewFromConn(ctx, conn, client.Options{APIVersion: apiVersion}); err != nil {
+			return err
+		}
+	}
+
+	b, err := newBench(ctx, o, clients)
+	if err != nil {
+		return err
+	}
+	report := b.run(ctx)
+
+	if o.output == formatJSON {
+		enc := json.NewEncoder(s.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	report.print(s.out)
+	return nil
+}
+
+// benchCall is one operation of the mix, with what it needs to be called
+type benchCall struct {
+	operation string
+	weight    int
+	client    client.Client
+	decrypt   bool
+	// ciphertext and its key ID and annotations, to decrypt
+	encrypted *client.EncryptResponse
+}
+
+type bench struct {
+	opts      benchOptions
+	calls     []benchCall
+	total     int
+	plaintext []byte
+}
+
+// newBench prepares the calls of the mix, encrypting the payload once with
+// each API version it decrypts with
+func newBench(ctx context.Context, o benchOptions, clients map[string]client.Client) (*bench, error) {
+	b := &bench{opts: o, plaintext: make([]byte, o.payloadSize)}
+	_, _ = rand.Read(b.plaintext)
+
+	for _, op := range benchOperations {
+		weight := o.mix[op]
+		if weight == 0 {
+			continue
+		}
+		apiVersion, method, _ := strings.Cut(op, "-")
+		call := benchCall{operation: op, weight: weight, client: clients[apiVersion], decrypt: method == "decrypt"}
+		if call.decrypt {
+			callCtx, cancel := context.WithTimeout(ctx, o.timeout)
+			res, err := call.client.Encrypt(callCtx, client.NewUID(), b.plaintext)
+			cancel()
+			if err != nil {
+				return nil, fmt.Errorf("failed to encrypt the payload to decrypt with %s: %w", apiVersion, err)
+			}
+			call.encrypted = res
+		}
+		b.calls = append(b.calls, call)
+		b.total += weight
+	}
+	return b, nil
+}
+
+// pick returns a call of the mix at random, by weight
+func (b *bench) pick() *benchCall {
+	n := mathrand.IntN(b.total)
+	for i := range b.calls {
+		if n < b.calls[i].weight {
+			return &b.calls[i]
+		}
+		n -= b.calls[i].weight
+	}
+	return &b.calls[len(b.calls)-1]
+}
+
+func (b *bench) call(ctx context.Context, c *benchCall) error {
+	ctx, cancel := context.WithTimeout(ctx, b.opts.timeout)
+	defer cancel()
+	if c.decrypt {
+		_, err := c.client.Decrypt(ctx, client.NewUID(), c.encrypted.Ciphertext, c.encrypted.KeyID, c.encrypted.Annotations)
+		return err
+	}
+	_, err := c.client.Encrypt(ctx, client.NewUID(), b.plaintext)
+	return err
+}
+
+// benchResults are the latencies and errors recorded by a worker
+type benchResults struct {
+	latencies map[string][]time.Duration
+	errors    map[string]int
+	classes   map[string]int
+}
+
+func newBenchResults() *benchResults {
+	return &benchResults{latencies: map[string][]time.Duration{}, errors: map[string]int{}, classes: map[string]int{}}
+}
+
+func (r *benchResults) merge(o *benchResults) {
+	for op, l := range o.latencies {
+		r.latencies[op] = append(r.latencies[op], l...)
+	}
+	for op, n := range o.errors {
+		r.errors[op] += n
+	}
+	for class, n := range o.classes {
+		r.classes[class] += n
+	}
+}
+
+// run calls the plugin until the duration elapses, the requests are made or
+// ctx is cancelled, and reports on the calls completed
+func (b *bench) run(ctx context.Context) *benchReport {
+	if b.opts.duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.opts.duration)
+		defer cancel()
+	}
+
+	// with a target rate, workers wait for a tick before each call
+	var ticks <-chan time.Time
+	if b.opts.rate > 0 {
+		ticker := time.NewTicker(rateInterval(b.opts.rate))
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	var (
+		issued  atomic.Int64
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		results = newBenchResults()
+	)
+	start := time.Now()
+	for range b.opts.concurrency {
+		wg.Go(func() {
+			r := newBenchResults()
+			defer func() {
+				mu.Lock()
+				results.merge(r)
+				mu.Unlock()
+			}()
+			for {
+				if ticks != nil {
+					select {
+					case <-ctx.Done():
+						return
+					case <-ticks:
+					}
+				}
+				if ctx.Err() != nil {
+					return
+				}
+				if b.opts.requests > 0 && issued.Add(1) > int64(b.opts.requests) {
+					return
+				}
+				c := b.pick()
+				callStart := time.Now()
+				err := b.call(ctx, c)
+				// calls cut short by the end of the run are not accounted
+				if err != nil && ctx.Err() != nil {
+					return
+				}
+				r.latencies[c.operation] = append(r.latencies[c.operation], time.Since(callStart))
+				if err != nil {
+					r.errors[c.operation]++
+					r.classes[errorClass(err)]++
+				}
+			}
+		})
+	}
+	wg.Wait()
+	return newBenchReport(b.opts, time.Since(start), results)
+}
+
+// errorClass returns the gRPC code of err, plugin errors that are not gRPC
+// statuses being Unknown
+func errorClass(err error) string {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return codes.DeadlineExceeded.String()
+	case errors.Is(err, context.Canceled):
+		return codes.Canceled.String()
+	}
+	return status.Code(err).String()
+}
+
+// benchReport is the result of a run, latencies are in milliseconds
+type benchReport struct {
+	DurationSeconds float64           `json:"durationSeconds"`
+	Concurrency     int               `json:"concurrency"`
+	TargetRate      float64           `json:"targetRate,omitempty"`
+	Requests        int               `json:"requests"`
+	Errors          int               `json:"errors"`
+	Throughput      float64           `json:"throughput"`
+	Operations      []operationReport `json:"operations"`
+	ErrorClasses    map[string]int    `json:"errorClasses"`
+}
+
+type operationReport struct {
+	Operation string  `json:"operation"`
+	Requests  int     `json:"requests"`
+	Errors    int     `json:"errors"`
+	P50       float64 `json:"p50"`
+	P90       float64 `json:"p90"`
+	P99       float64 `json:"p99"`
+	Max       float64 `json:"max"`
+}
+
+func newBenchReport(o benchOptions, elapsed time.Duration, r *benchResults) *benchReport {
+	report := &benchReport{
+		DurationSeconds: elapsed.Seconds(),
+		Concurrency:     o.concurrency,
+		TargetRate:      o.rate,
+		Operations:      []operationReport{},
+		ErrorClasses:    r.classes,
+	}
+	for _, op := range benchOperations {
+		latencies := r.latencies[op]
+		if len(latencies) == 0 {
+			continue
+		}
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		report.Operations = append(report.Operations, operationReport{
+			Operation: op,
+			Requests:  len(latencies),
+			Errors:    r.errors[op],
+			P50:       percentile(latencies, 50),
+			P90:       percentile(latencies, 90),
+			P99:       percentile(latencies, 99),
+			Max:       milliseconds(latencies[len(latencies)-1]),
+		})
+		report.Requests += len(latencies)
+		report.Errors += r.errors[op]
+	}
+	if elapsed > 0 {
+		report.Throughput = float64(report.Requests) / elapsed.Seconds()
+	}
+	return report
+}
+
+// percentile returns the p-th percentile of sorted latencies in milliseconds,
+// by the nearest-rank method
+func percentile(sorted []time.Duration, p int) float64 {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return milliseconds(sorted[rank-1])
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func (r *benchReport) print(w io.Writer) {
+	fmt.Fprintf(w, "Duration:    %.1fs\n", r.DurationSeconds)
+	fmt.Fprintf(w, "Concurrency: %d\n", r.Concurrency)
+	if r.TargetRate > 0 {
+		fmt.Fprintf(w, "Target rate: %.1f/s\n", r.TargetRate)
+	}
+	fmt.Fprintf(w, "Requests:    %d (%d errors)\n", r.Requests, r.Errors)
+	fmt.Fprintf(w, "Throughput:  %.1f/s\n", r.Throughput)
+	fmt.Fprintln(w)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "OPERATION\tREQUESTS\tERRORS\tP50 (ms)\tP90 (ms)\tP99 (ms)\tMAX (ms)\t")
+	for _, op := range r.Operations {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.2f\t%.2f\t%.2f\t%.2f\t\n", op.Operation, op.Requests, op.Errors, op.P50, op.P90, op.P99, op.Max)
+	}
+	_ = tw.Flush()
+
+	if len(r.ErrorClasses) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Errors:")
+		classes := make([]string, 0, len(r.ErrorClasses))
+		for class := range r.ErrorClasses {
+			classes = append(classes, class)
+		}
+		sort.Strings(classes)
+		for _, class := range classes {
+			fmt.Fprintf(w, "  %-20s %d\n", class, r.ErrorClasses[class])
+		}
+	}
+}
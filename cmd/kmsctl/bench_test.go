@@ -0,0 +1,112 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+)
+
+func TestPercentile(t *testing.T) {
+	var latencies []time.Duration
+	for i := 1; i <= 200; i++ {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	assert.Equal(t, 100.0, percentile(latencies, 50))
+	assert.Equal(t, 180.0, percentile(latencies, 90))
+	assert.Equal(t, 198.0, percentile(latencies, 99))
+	assert.Equal(t, 1.0, percentile(latencies[:1], 50))
+}
+
+func runBenchReport(t *testing.T, args ...string) benchReport {
+	t.Helper()
+	code, out, stderr := runCommand("", append([]string{"bench", "--output", "json"}, args...)...)
+	require.Equal(t, exitOK, code, stderr)
+	var report benchReport
+	require.NoError(t, json.Unmarshal([]byte(out), &report), out)
+	return report
+}
+
+func TestBenchInProcess(t *testing.T) {
+	report := runBenchReport(t, "--in-process", "--requests", "200", "--duration", "0",
+		"--mix", "v1-encrypt=1,v1-decrypt=1,v2-encrypt=1,v2-decrypt=1", "--mock-latency", "2ms")
+
+	assert.Equal(t, 200, report.Requests)
+	assert.Zero(t, report.Errors)
+	assert.Empty(t, report.ErrorClasses)
+	assert.Positive(t, report.Throughput)
+	require.Len(t, report.Operations, 4)
+	total := 0
+	for _, op := range report.Operations {
+		total += op.Requests
+		// the mock latency is a floor
+		assert.GreaterOrEqual(t, op.P50, 2.0, op.Operation)
+		assert.LessOrEqual(t, op.P50, op.P90, op.Operation)
+		assert.LessOrEqual(t, op.P90, op.P99, op.Operation)
+		assert.LessOrEqual(t, op.P99, op.Max, op.Operation)
+	}
+	assert.Equal(t, 200, total)
+}
+
+func TestBenchRate(t *testing.T) {
+	report := runBenchReport(t, "--in-process", "--rate", "50", "--duration", "1s")
+	// one call per 20ms tick at most, fewer on a loaded machine
+	assert.LessOrEqual(t, report.Requests, 50)
+	assert.GreaterOrEqual(t, report.Requests, 25)
+	assert.Equal(t, 50.0, report.TargetRate)
+}
+
+func TestBenchErrorClasses(t *testing.T) {
+	c := newMock()
+	addr := startPlugin(t, c)
+	// the payload to decrypt is encrypted fine, but every decryption fails
+	c.SetDecryptResp("", &kmstypes.InvalidCiphertextException{Message: aws.String("invalid ciphertext")})
+
+	report := runBenchReport(t, "--socket", addr, "--requests", "20", "--mix", "v2-encrypt=1,v2-decrypt=1")
+	assert.Equal(t, 20, report.Requests)
+	for _, op := range report.Operations {
+		if op.Operation == benchV2Decrypt {
+			assert.Equal(t, op.Requests, op.Errors)
+		} else {
+			assert.Zero(t, op.Errors)
+		}
+	}
+	assert.Equal(t, map[string]int{codes.Unknown.String(): report.Errors}, report.ErrorClasses)
+}
+
+func TestBenchUsage(t *testing.T) {
+	for _, args := range [][]string{
+		{"--mix", "v3-encrypt=1"},
+		{"--mix", "v2-encrypt=0"},
+		{"--concurrency", "0"},
+		{"--duration", "0"},
+		{"--rate", "-1"},
+		// the interval between calls rounds to 0
+		{"--rate", "2e9"},
+		{"--rate", "+Inf"},
+		{"--rate", "NaN"},
+		{"--in-process", "--socket", "/tmp/kms.sock"},
+		{"--mock-latency", "1ms"},
+	} {
+		code, _, stderr := runCommand("", append([]string{"bench"}, args...)...)
+		assert.Equal(t, exitUsage, code, "%v: %s", args, stderr)
+	}
+}
@@ -0,0 +1,59 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"time"
+
+	flag "github.com/spf13/pflag"
+	"sigs.k8s.io/aws-encryption-provider/pkg/client"
+)
+
+// KMS API versions
+const (
+	apiV1 = client.APIVersionV1
+	apiV2 = client.APIVersionV2
+)
+
+const defaultSocket = "/var/run/kmsplugin/socket.sock"
+
+// connectionOptions are the flags shared by the commands that call the plugin
+type connectionOptions struct {
+	socket     string
+	apiVersion string
+	timeout    time.Duration
+}
+
+func (o *connectionOptions) addFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.socket, "socket", defaultSocket, "path of the unix socket the plugin listens on")
+	fs.StringVar(&o.apiVersion, "api-version", apiV2, "KMS API version to call the plugin with: v1 or v2")
+	fs.DurationVar(&o.timeout, "timeout", 10*time.Second, "deadline of each call to the plugin")
+}
+
+func (o *connectionOptions) validate() error {
+	if o.apiVersion != apiV1 && o.apiVersion != apiV2 {
+		return usageErrorf("invalid --api-version %q, must be %s or %s", o.apiVersion, apiV1, apiV2)
+	}
+	if o.timeout <= 0 {
+		return usageErrorf("--timeout must be positive")
+	}
+	return nil
+}
+
+// dial returns a client for the configured API version, each of its calls
+// bounded by the timeout
+func (o *connectionOptions) dial(ctx context.Context) (client.Client, error) {
+	return client.New(ctx, o.socket, client.Options{APIVersion: o.apiVersion, Timeout: o.timeout})
+}
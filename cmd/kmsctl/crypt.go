@@ -0,0 +1,256 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	flag "github.com/spf13/pflag"
+	"sigs.k8s.io/aws-encryption-provider/pkg/client"
+)
+
+// Formats of the data read and written by encrypt and decrypt
+const (
+	formatRaw    = "raw"
+	formatBase64 = "base64"
+	formatJSON   = "json"
+)
+
+// ciphertextJSON is the json output of encrypt and input of decrypt, byte
+// fields are base64 encoded
+type ciphertextJSON struct {
+	UID         string            `json:"uid,omitempty"`
+	Ciphertext  []byte            `json:"ciphertext"`
+	KeyID       string            `json:"keyID,omitempty"`
+	Annotations map[string][]byte `json:"annotations,omitempty"`
+}
+
+// plaintextJSON is the json output of decrypt
+type plaintextJSON struct {
+	UID       string `json:"uid,omitempty"`
+	Plaintext []byte `json:"plaintext"`
+}
+
+// dataOptions are the flags of the commands reading and writing data
+type dataOptions struct {
+	in           string
+	out          string
+	inputFormat  string
+	outputFormat string
+	uid          string
+}
+
+func (o *dataOptions) addFlags(fs *flag.FlagSet, inputFormat, outputFormat string) {
+	fs.StringVar(&o.in, "in", "-", "file to read the input from, - for stdin")
+	fs.StringVar(&o.out, "out", "-", "file to write the output to, - for stdout")
+	fs.StringVar(&o.inputFormat, "input-format", inputFormat, "format of the input: raw, base64 or json")
+	fs.StringVar(&o.outputFormat, "output", outputFormat, "format of the output: raw, base64 or json")
+	fs.StringVar(&o.uid, "uid", "", "UID of v2 requests, a random one is used if empty")
+}
+
+func (o *dataOptions) validate() error {
+	for flagName, format := range map[string]string{"input-format": o.inputFormat, "output": o.outputFormat} {
+		switch format {
+		case formatRaw, formatBase64, formatJSON:
+		default:
+			return usageErrorf("invalid --%s %q, must be raw, base64 or json", flagName, format)
+		}
+	}
+	if o.uid == "" {
+		o.uid = client.NewUID()
+	}
+	return nil
+}
+
+// read returns the input, base64 decoded if needed. JSON input is returned
+// as is for the caller to decode.
+func (o *dataOptions) read(stdin io.Reader) ([]byte, error) {
+	return readInput(stdin, o.in, o.inputFormat)
+}
+
+// readInput reads the file in, or stdin if in is -, base64 decoding it if
+// format is base64
+func readInput(stdin io.Reader, in, format string) ([]byte, error) {
+	var (
+		data []byte
+		err  error
+	)
+	if in == "-" {
+		data, err = io.ReadAll(stdin)
+	} else {
+		data, err = os.ReadFile(in)
+	}
+	if err != nil {
+		return nil, usageErrorf("failed to read input: %v", err)
+	}
+	if format == formatBase64 {
+		decoded, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data)))
+		if err != nil {
+			return nil, usageErrorf("failed to decode base64 input: %v", err)
+		}
+		return decoded, nil
+	}
+	return data, nil
+}
+
+// write writes data, or v as json if the output format is json
+func (o *dataOptions) write(stdout io.Writer, data []byte, v any) (err error) {
+	w := stdout
+	if o.out != "-" {
+		var f *os.File
+		f, err = os.OpenFile(o.out, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to open output: %v", err)
+		}
+		bw := bufio.NewWriter(f)
+		defer func() {
+			ferr := bw.Flush()
+			if cerr := f.Close(); ferr == nil {
+				ferr = cerr
+			}
+			if err == nil && ferr != nil {
+				err = fmt.Errorf("failed to write output: %v", ferr)
+			}
+		}()
+		w = bw
+	}
+
+	switch o.outputFormat {
+	case formatBase64:
+		_, err = fmt.Fprintln(w, base64.StdEncoding.EncodeToString(data))
+	case formatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(v)
+	default:
+		_, err = w.Write(data)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write output: %v", err)
+	}
+	return nil
+}
+
+func runEncrypt(ctx context.Context, args []string, s streams) error {
+	var (
+		conn connectionOptions
+		data dataOptions
+	)
+	fs := newFlagSet("encrypt", s)
+	conn.addFlags(fs)
+	data.addFlags(fs, formatRaw, formatBase64)
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if err := conn.validate(); err != nil {
+		return err
+	}
+	if err := data.validate(); err != nil {
+		return err
+	}
+	if data.inputFormat == formatJSON {
+		return usageErrorf("--input-format json is only supported by decrypt")
+	}
+
+	plaintext, err := data.read(s.in)
+	if err != nil {
+		return err
+	}
+	if len(plaintext) == 0 {
+		return usageErrorf("input is empty")
+	}
+
+	c, err := conn.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close() //nolint:errcheck
+	res, err := c.Encrypt(ctx, data.uid, plaintext)
+	if err != nil {
+		return err
+	}
+
+	return data.write(s.out, res.Ciphertext, ciphertextJSON{
+		UID:         data.uidFor(conn.apiVersion),
+		Ciphertext:  res.Ciphertext,
+		KeyID:       res.KeyID,
+		Annotations: res.Annotations,
+	})
+}
+
+func runDecrypt(ctx context.Context, args []string, s streams) error {
+	var (
+		conn  connectionOptions
+		data  dataOptions
+		keyID string
+	)
+	fs := newFlagSet("decrypt", s)
+	conn.addFlags(fs)
+	data.addFlags(fs, formatBase64, formatRaw)
+	fs.StringVar(&keyID, "key-id", "", "key ID returned by the v2 encryption, overrides the one of json input")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if err := conn.validate(); err != nil {
+		return err
+	}
+	if err := data.validate(); err != nil {
+		return err
+	}
+
+	input, err := data.read(s.in)
+	if err != nil {
+		return err
+	}
+	req := ciphertextJSON{Ciphertext: input}
+	if data.inputFormat == formatJSON {
+		req = ciphertextJSON{}
+		if err := json.Unmarshal(input, &req); err != nil {
+			return usageErrorf("failed to decode json input: %v", err)
+		}
+	}
+	if keyID != "" {
+		req.KeyID = keyID
+	}
+	if len(req.Ciphertext) == 0 {
+		return usageErrorf("ciphertext is empty")
+	}
+
+	c, err := conn.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close() //nolint:errcheck
+	plaintext, err := c.Decrypt(ctx, data.uid, req.Ciphertext, req.KeyID, req.Annotations)
+	if err != nil {
+		return err
+	}
+
+	return data.write(s.out, plaintext, plaintextJSON{UID: data.uidFor(conn.apiVersion), Plaintext: plaintext})
+}
+
+// uidFor returns the UID requests are sent with, v1 requests have none
+func (o *dataOptions) uidFor(apiVersion string) string {
+	if apiVersion == apiV1 {
+		return ""
+	}
+	return o.uid
+}
@@ -0,0 +1,439 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+	"sigs.k8s.io/aws-encryption-provider/pkg/client"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+	"sigs.k8s.io/aws-encryption-provider/pkg/plugin"
+)
+
+// Outcomes of a doctor check
+const (
+	checkPass = "pass"
+	checkWarn = "warn"
+	checkFail = "fail"
+	checkSkip = "skip"
+)
+
+// checkResult is the outcome of a doctor check, with a hint to fix it
+type checkResult struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	Hint    string `json:"hint,omitempty"`
+}
+
+// doctorOptions mirror the flags of the plugin they check
+type doctorOptions struct {
+	listen         []string
+	keys           []string
+	region         string
+	kmsEndpoint    string
+	stsEndpoint    string
+	encryptionCtxs []string
+	sourceArn      string
+	keyAccount     string
+	timeout        time.Duration
+	skipRoundTrip  bool
+	output         string
+
+	listenSet bool
+}
+
+type doctor struct {
+	opts    doctorOptions
+	results []checkResult
+}
+
+func (d *doctor) add(name, status, message, hint string) {
+	d.results = append(d.results, checkResult{Name: name, Status: status, Message: message, Hint: hint})
+}
+
+// runDoctor checks the deployment of a plugin configured with the same
+// flags, and reports each check with a hint when it does not pass
+func runDoctor(ctx context.Context, args []string, s streams) error {
+	o := doctorOptions{}
+	fs := newFlagSet("doctor", s)
+	fs.StringSliceVar(&o.listen, "listen", []string{defaultSocket}, "comma separated list of the sockets the plugin listens on")
+	fs.StringSliceVar(&o.keys, "key", nil, "comma separated list of the AWS KMS keys of the plugin")
+	fs.StringVar(&o.region, "region", "", "AWS region of the plugin, resolved like the plugin does if empty")
+	fs.StringVar(&o.kmsEndpoint, "kms-endpoint", "", "KMS endpoint of the plugin")
+	fs.StringVar(&o.stsEndpoint, "sts-endpoint", "", "use this STS endpoint to resolve the identity instead of the one generated by AWS sdk")
+	fs.StringArrayVar(&o.encryptionCtxs, "encryption-context", nil, "AWS KMS encryption context of each key of the plugin (e.g. 'a=b,c=d')")
+	fs.StringVar(&o.sourceArn, "source-arn", "", "AWS source ARN of the plugin")
+	fs.StringVar(&o.keyAccount, "key-account", "", "AWS account ID expected to own each key (defaults to the account of --source-arn)")
+	fs.DurationVar(&o.timeout, "timeout", 30*time.Second, "deadline of each check")
+	fs.BoolVar(&o.skipRoundTrip, "skip-round-trip", false, "do not encrypt and decrypt with each key")
+	fs.StringVar(&o.output, "output", "text", "format of the report: text or json")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if o.timeout <= 0 {
+		return usageErrorf("--timeout must be positive")
+	}
+	if o.output != "text" && o.output != formatJSON {
+		return usageErrorf("invalid --output %q, must be text or json", o.output)
+	}
+	o.listenSet = fs.Changed("listen")
+
+	d := &doctor{opts: o}
+	d.run(ctx)
+
+	if o.output == formatJSON {
+		enc := json.NewEncoder(s.out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(d.results); err != nil {
+			return err
+		}
+	} else {
+		printChecks(s.out, d.results)
+	}
+	if n := countChecks(d.results, checkFail); n > 0 {
+		return fmt.Errorf("%d checks failed", n)
+	}
+	return nil
+}
+
+func (d *doctor) run(ctx context.Context) {
+	d.checkFlags()
+	for _, path := range d.opts.listen {
+		d.checkSocket(ctx, path)
+	}
+
+	cfg, region, ok := d.checkRegion(ctx)
+	if !ok {
+		d.add("credentials", checkSkip, "skipped, the region is unknown", "")
+		return
+	}
+	if !d.checkCredentials(ctx, cfg) {
+		d.add("identity", checkSkip, "skipped, no credentials", "")
+		return
+	}
+	identity := d.checkIdentity(ctx, cfg)
+	d.checkKeys(ctx, region, identity)
+}
+
+// checkFlags lints the key and encryption context flags, which can only be
+// found wrong when decrypting otherwise
+func (d *doctor) checkFlags() {
+	o := d.opts
+	switch {
+	case len(o.keys) == 0:
+		d.add("keys", checkFail, "no key given", "pass the --key flag of the plugin")
+	case o.listenSet && len(o.keys) != len(o.listen):
+		d.add("keys", checkFail, fmt.Sprintf("%d keys for %d sockets", len(o.keys), len(o.listen)), "the plugin serves each key on the socket at the same position in --listen, both lists must have the same length")
+	default:
+		d.add("keys", checkPass, fmt.Sprintf("%d keys", len(o.keys)), "")
+	}
+
+	if len(o.encryptionCtxs) > len(o.keys) {
+		d.add("encryption-context", checkWarn, fmt.Sprintf("%d encryption contexts for %d keys, the extra ones are ignored", len(o.encryptionCtxs), len(o.keys)), "the plugin uses the encryption context at the same position as each key in --key")
+	}
+	for i, val := range o.encryptionCtxs {
+		name := fmt.Sprintf("encryption-context[%d]", i)
+		errs, warnings := plugin.LintEncryptionContext(val)
+		switch {
+		case len(errs) > 0:
+			d.add(name, checkFail, strings.Join(append(errs, warnings...), "; "), "KMS rejects this encryption context, fix the --encryption-context flag")
+		case len(warnings) > 0:
+			d.add(name, checkWarn, strings.Join(warnings, "; "), "data can only be decrypted with the exact encryption context it was encrypted with, check the flag is what was intended")
+		default:
+			ctx, _ := plugin.ParseEncryptionContext(val)
+			d.add(name, checkPass, fmt.Sprintf("%d keys", len(ctx)), "")
+		}
+	}
+}
+
+// checkSocket checks that the plugin listens on path, that the socket can
+// be used and that it is not open to every user
+func (d *doctor) checkSocket(ctx context.Context, path string) {
+	name := "socket " + path
+	fi, err := os.Stat(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		d.add(name, checkFail, "does not exist", "start the plugin, and check that --listen matches the endpoint of the EncryptionConfiguration of kube-apiserver")
+		return
+	case err != nil:
+		d.add(name, checkFail, err.Error(), "")
+		return
+	case fi.Mode()&os.ModeSocket == 0:
+		d.add(name, checkFail, "is not a socket", "remove it, the plugin creates the socket when it starts")
+		return
+	}
+
+	conn, err := net.DialTimeout("unix", path, d.opts.timeout)
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		d.add(name, checkFail, "stale socket, no process is listening on it", "restart the plugin, it replaces stale sockets when it starts")
+		return
+	case errors.Is(err, syscall.EACCES):
+		d.add(name, checkFail, "permission denied", "run as the user kube-apiserver runs as, or give it access to the socket")
+		return
+	case err != nil:
+		d.add(name, checkFail, err.Error(), "")
+		return
+	}
+	_ = conn.Close()
+
+	c, err := client.New(ctx, path, client.Options{APIVersion: apiV2, Timeout: d.opts.timeout})
+	if err != nil {
+		d.add(name, checkFail, err.Error(), "")
+		return
+	}
+	defer c.Close() //nolint:errcheck
+	st, err := c.Status(ctx)
+	switch {
+	case err != nil:
+		d.add(name, checkFail, fmt.Sprintf("v2 status failed: %v", err), "check the plugin logs, and that the socket belongs to aws-encryption-provider")
+	case st.Healthz != healthzOK:
+		d.add(name, checkFail, fmt.Sprintf("plugin is unhealthy: %s", st.Healthz), "check the plugin logs and its /healthz endpoint")
+	case fi.Mode().Perm()&0o002 != 0:
+		d.add(name, checkWarn, fmt.Sprintf("writable by any user (%s), who can then decrypt secrets", fi.Mode().Perm()), "restrict the permissions of the socket or its directory to the user of kube-apiserver")
+	default:
+		d.add(name, checkPass, fmt.Sprintf("plugin is healthy, key %s", st.KeyID), "")
+	}
+}
+
+// checkRegion loads the AWS config like the plugin and resolves its region
+func (d *doctor) checkRegion(ctx context.Context) (aws.Config, string, bool) {
+	ctx, cancel := context.WithTimeout(ctx, d.opts.timeout)
+	defer cancel()
+
+	var optFns []func(*config.LoadOptions) error
+	if d.opts.region != "" {
+		optFns = append(optFns, config.WithRegion(d.opts.region))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, optFns...)
+	if err != nil {
+		d.add("region", checkFail, fmt.Sprintf("failed to load AWS config: %v", err), "check AWS_PROFILE, AWS_CONFIG_FILE and the shared config files")
+		return cfg, "", false
+	}
+	region, source, err := cloud.ResolveRegion(ctx, cfg)
+	if err != nil {
+		d.add("region", checkFail, err.Error(), "set --region or AWS_REGION, or let the plugin reach the instance metadata service (containers need a hop limit of 2 with IMDSv2)")
+		return cfg, "", false
+	}
+	cfg.Region = region
+	switch {
+	case source == cloud.RegionSourceIMDS:
+		source = "the instance metadata service"
+	case d.opts.region != "":
+		source = "--region"
+	default:
+		source = "the environment or shared config"
+	}
+	d.add("region", checkPass, fmt.Sprintf("%s, from %s", region, source), "")
+	return cfg, region, true
+}
+
+func (d *doctor) checkCredentials(ctx context.Context, cfg aws.Config) bool {
+	ctx, cancel := context.WithTimeout(ctx, d.opts.timeout)
+	defer cancel()
+
+	creds, err := cfg.Credentials.Retrieve(ctx)
+	if err != nil {
+		d.add("credentials", checkFail, err.Error(), "configure IAM roles for service accounts, EKS Pod Identity, an instance profile or the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables")
+		return false
+	}
+	message := fmt.Sprintf("access key %s from %s", maskAccessKey(creds.AccessKeyID), creds.Source)
+	if creds.CanExpire {
+		message += fmt.Sprintf(", expiring at %s", creds.Expires.UTC().Format(time.RFC3339))
+	}
+	d.add("credentials", checkPass, message, "")
+	return true
+}
+
+// maskAccessKey keeps the last 4 characters of an access key ID
+func maskAccessKey(id string) string {
+	if len(id) <= 4 {
+		return id
+	}
+	return strings.Repeat("*", len(id)-4) + id[len(id)-4:]
+}
+
+// checkIdentity resolves the identity of the credentials and returns its
+// ARN, checking its account is the one expected to own the keys
+func (d *doctor) checkIdentity(ctx context.Context, cfg aws.Config) string {
+	ctx, cancel := context.WithTimeout(ctx, d.opts.timeout)
+	defer cancel()
+
+	var optFns []func(*sts.Options)
+	if d.opts.stsEndpoint != "" {
+		optFns = append(optFns, func(o *sts.Options) { o.BaseEndpoint = aws.String(d.opts.stsEndpoint) })
+	}
+	out, err := sts.NewFromConfig(cfg, optFns...).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		d.add("identity", checkFail, err.Error(), "check that the credentials are valid and not expired, and that STS can be reached")
+		return ""
+	}
+	arn, account := aws.ToString(out.Arn), aws.ToString(out.Account)
+	if expected := d.expectedAccount(); expected != "" && expected != account {
+		d.add("identity", checkWarn, fmt.Sprintf("%s, in account %s while the keys are expected in account %s", arn, account, expected), "the key policy of cross-account keys must allow this identity")
+		return arn
+	}
+	d.add("identity", checkPass, arn, "")
+	return arn
+}
+
+func (d *doctor) expectedAccount() string {
+	if d.opts.keyAccount != "" || d.opts.sourceArn == "" {
+		return d.opts.keyAccount
+	}
+	account, _ := cloud.SourceAccount(d.opts.sourceArn)
+	return account
+}
+
+// checkKeys describes each key like --key-validation does, and encrypts and
+// decrypts with it and its encryption context
+func (d *doctor) checkKeys(ctx context.Context, region, identity string) {
+	if len(d.opts.keys) == 0 {
+		return
+	}
+	c, err := cloud.New(region, d.opts.kmsEndpoint, 0, 0, 0, d.opts.sourceArn)
+	if err != nil {
+		d.add("kms", checkFail, err.Error(), "check --kms-endpoint and --source-arn")
+		return
+	}
+	expect := plugin.KeyExpectations{Region: region, Account: d.expectedAccount()}
+	for i, key := range d.opts.keys {
+		checkCtx, cancel := context.WithTimeout(ctx, d.opts.timeout)
+		v := plugin.ValidateKey(checkCtx, c, key, expect)
+		cancel()
+		name := fmt.Sprintf("key %s", key)
+		switch {
+		case v.Err != nil:
+			d.add(name, checkFail, fmt.Sprintf("DescribeKey failed: %v", v.Err), kmsErrorHint(v.Err, "kms:DescribeKey", identity, region))
+		case len(v.Problems) > 0:
+			d.add(name, checkFail, strings.Join(v.Problems, "; "), keyHint(v.Metadata))
+		default:
+			d.add(name, checkPass, fmt.Sprintf("%s is %s", aws.ToString(v.Metadata.Arn), v.Metadata.KeyState), "")
+		}
+
+		if d.opts.skipRoundTrip {
+			continue
+		}
+		var encryptionCtx map[string]string
+		if i < len(d.opts.encryptionCtxs) {
+			// lint errors were reported by checkFlags, KMS reports them again
+			encryptionCtx, _ = plugin.ParseEncryptionContext(d.opts.encryptionCtxs[i])
+		}
+		checkCtx, cancel = context.WithTimeout(ctx, d.opts.timeout)
+		d.checkRoundTrip(checkCtx, c, key, encryptionCtx, identity, region)
+		cancel()
+	}
+}
+
+func (d *doctor) checkRoundTrip(ctx context.Context, c cloud.AWSKMSv2, key string, encryptionCtx map[string]string, identity, region string) {
+	name := fmt.Sprintf("round trip %s", key)
+	plaintext := make([]byte, 32)
+	_, _ = rand.Read(plaintext)
+
+	enc, err := c.Encrypt(ctx, &kms.EncryptInput{KeyId: aws.String(key), Plaintext: plaintext, EncryptionContext: encryptionCtx})
+	if err != nil {
+		d.add(name, checkFail, fmt.Sprintf("Encrypt failed: %v", err), kmsErrorHint(err, "kms:Encrypt", identity, region))
+		return
+	}
+	dec, err := c.Decrypt(ctx, &kms.DecryptInput{KeyId: aws.String(key), CiphertextBlob: enc.CiphertextBlob, EncryptionContext: encryptionCtx})
+	if err != nil {
+		d.add(name, checkFail, fmt.Sprintf("Decrypt failed: %v", err), kmsErrorHint(err, "kms:Decrypt", identity, region))
+		return
+	}
+	if !bytes.Equal(dec.Plaintext, plaintext) {
+		d.add(name, checkFail, "decrypted data differs from the encrypted data", "")
+		return
+	}
+	d.add(name, checkPass, fmt.Sprintf("encrypted and decrypted with an encryption context of %d keys", len(encryptionCtx)), "")
+}
+
+// kmsErrorHint suggests a fix for an error KMS returned for a call needing permission
+func kmsErrorHint(err error, permission, identity, region string) string {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return "check that KMS can be reached, through a VPC endpoint or proxy if needed, or set --kms-endpoint"
+	}
+	switch apiErr.ErrorCode() {
+	case "AccessDeniedException":
+		who := "the plugin"
+		if identity != "" {
+			who = identity
+		}
+		return fmt.Sprintf("allow %s on the key to %s, in the key policy and its IAM policies", permission, who)
+	case "NotFoundException":
+		return fmt.Sprintf("check the key ID, and that the key is in region %s", region)
+	case "DisabledException", "KMSInvalidStateException":
+		return "enable the key, or cancel its deletion if it is pending deletion"
+	case "InvalidCiphertextException":
+		return "check that the encryption context is the one the data was encrypted with"
+	case "ThrottlingException":
+		return "KMS request quota exceeded, retry later or request a quota increase"
+	}
+	return ""
+}
+
+// keyHint suggests a fix for a described key unsuitable for the plugin
+func keyHint(md *kmstypes.KeyMetadata) string {
+	switch {
+	case md.KeyState == kmstypes.KeyStatePendingDeletion:
+		return "cancel the deletion of the key with 'aws kms cancel-key-deletion', then enable it with 'aws kms enable-key'"
+	case md.KeyState == kmstypes.KeyStateDisabled:
+		return "enable the key with 'aws kms enable-key'"
+	case md.KeySpec != kmstypes.KeySpecSymmetricDefault || md.KeyUsage != kmstypes.KeyUsageTypeEncryptDecrypt:
+		return "the plugin needs a symmetric encryption key"
+	}
+	return "check --region, --key-account and --source-arn"
+}
+
+func countChecks(results []checkResult, status string) int {
+	n := 0
+	for _, r := range results {
+		if r.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+func printChecks(w io.Writer, results []checkResult) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", strings.ToUpper(r.Status), r.Name, r.Message)
+		if r.Hint != "" {
+			fmt.Fprintf(tw, "\t\thint: %s\n", r.Hint)
+		}
+	}
+	_ = tw.Flush()
+	fmt.Fprintf(w, "\n%d passed, %d warnings, %d failed, %d skipped\n",
+		countChecks(results, checkPass), countChecks(results, checkWarn), countChecks(results, checkFail), countChecks(results, checkSkip))
+}
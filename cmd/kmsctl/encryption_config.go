@@ -0,0 +1,203 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// encryptionConfiguration is the apiserver.config.k8s.io/v1
+// EncryptionConfiguration of kube-apiserver, limited to the KMS and identity
+// providers
+type encryptionConfiguration struct {
+	Kind       string                  `yaml:"kind"`
+	APIVersion string                  `yaml:"apiVersion"`
+	Resources  []resourceConfiguration `yaml:"resources"`
+}
+
+type resourceConfiguration struct {
+	Resources []string                `yaml:"resources"`
+	Providers []providerConfiguration `yaml:"providers"`
+}
+
+type providerConfiguration struct {
+	KMS      *kmsConfiguration `yaml:"kms,omitempty"`
+	Identity *struct{}         `yaml:"identity,omitempty"`
+}
+
+type kmsConfiguration struct {
+	APIVersion string `yaml:"apiVersion"`
+	Name       string `yaml:"name"`
+	Endpoint   string `yaml:"endpoint"`
+	CacheSize  *int   `yaml:"cachesize,omitempty"`
+	Timeout    string `yaml:"timeout"`
+}
+
+type encryptionConfigOptions struct {
+	listen      []string
+	keys        []string
+	names       []string
+	apiVersions []string
+	resources   []string
+	rotateTo    string
+	timeout     time.Duration
+	cacheSize   int
+	identity    bool
+	out         string
+
+	cacheSizeSet bool
+}
+
+func (o *encryptionConfigOptions) validate() error {
+	switch {
+	case len(o.keys) == 0:
+		return usageErrorf("--key is required")
+	case len(o.keys) != len(o.listen):
+		return usageErrorf("%d keys for %d sockets, the plugin serves each key on the socket at the same position in --listen", len(o.keys), len(o.listen))
+	case len(o.names) > 0 && len(o.names) != len(o.keys):
+		return usageErrorf("%d names for %d keys", len(o.names), len(o.keys))
+	case len(o.apiVersions) != 1 && len(o.apiVersions) != len(o.keys):
+		return usageErrorf("%d API versions for %d keys, set one for all keys or one per key", len(o.apiVersions), len(o.keys))
+	case len(o.resources) == 0:
+		return usageErrorf("--resources is required")
+	case o.timeout <= 0:
+		return usageErrorf("--timeout must be positive")
+	case o.rotateTo != "" && !slices.Contains(o.keys, o.rotateTo):
+		return usageErrorf("--rotate-to %s is not one of --key", o.rotateTo)
+	}
+	for _, path := range o.listen {
+		if !filepath.IsAbs(path) {
+			return usageErrorf("socket %q must be an absolute path", path)
+		}
+	}
+	for _, v := range o.apiVersions {
+		if v != apiV1 && v != apiV2 {
+			return usageErrorf("invalid --api-version %q, must be %s or %s", v, apiV1, apiV2)
+		}
+	}
+	return nil
+}
+
+// runGenEncryptionConfig prints the EncryptionConfiguration of kube-apiserver
+// for a plugin configured with the same --key and --listen flags.
+//
+// The first provider encrypts and all of them decrypt, so providers are in
+// the order of --key, except for --rotate-to which goes first while the
+// others are kept to read the data they encrypted until it is rewritten.
+func runGenEncryptionConfig(_ context.Context, args []string, s streams) error {
+	o := encryptionConfigOptions{}
+	fs := newFlagSet("gen-encryption-config", s)
+	fs.StringSliceVar(&o.listen, "listen", []string{defaultSocket}, "comma separated list of the sockets the plugin listens on")
+	fs.StringSliceVar(&o.keys, "key", nil, "comma separated list of the AWS KMS keys of the plugin")
+	fs.StringSliceVar(&o.names, "name", nil, "comma separated list of the provider names of the keys, derived from the keys if empty. Values are stored with the name of their provider, which must not change once data is encrypted")
+	fs.StringSliceVar(&o.apiVersions, "api-version", []string{apiV2}, "KMS API version of the providers, one for all keys or one per key: v1 or v2")
+	fs.StringSliceVar(&o.resources, "resources", []string{"secrets"}, "comma separated list of the resources to encrypt (e.g. 'secrets,configmaps')")
+	fs.StringVar(&o.rotateTo, "rotate-to", "", "key to encrypt with, placed first while the other keys are kept for reads")
+	fs.DurationVar(&o.timeout, "timeout", 3*time.Second, "deadline of each call of kube-apiserver to the plugin")
+	fs.IntVar(&o.cacheSize, "cache-size", 1000, "number of data encryption keys cached by kube-apiserver in clear, v1 providers only")
+	fs.BoolVar(&o.identity, "identity", true, "add the identity provider last, to read the data written before encryption was enabled")
+	fs.StringVar(&o.out, "out", "-", "file to write the configuration to, - for stdout")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	o.cacheSizeSet = fs.Changed("cache-size")
+	if err := o.validate(); err != nil {
+		return err
+	}
+
+	config, err := newEncryptionConfiguration(o)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(config); err != nil {
+		return err
+	}
+	if o.out == "-" {
+		_, err = s.out.Write(buf.Bytes())
+		return err
+	}
+	return os.WriteFile(o.out, buf.Bytes(), 0600)
+}
+
+func newEncryptionConfiguration(o encryptionConfigOptions) (*encryptionConfiguration, error) {
+	var providers []providerConfiguration
+	seen := map[string]bool{}
+	for i, key := range o.keys {
+		name := providerName(key)
+		if len(o.names) > 0 {
+			name = o.names[i]
+		}
+		switch {
+		case name == "":
+			return nil, usageErrorf("provider name of key %q is empty", key)
+		case strings.Contains(name, ":"):
+			return nil, usageErrorf("provider name %q must not contain ':'", name)
+		case seen[name]:
+			return nil, usageErrorf("provider name %q is used by more than one key, set --name", name)
+		}
+		seen[name] = true
+
+		apiVersion := o.apiVersions[0]
+		if len(o.apiVersions) > 1 {
+			apiVersion = o.apiVersions[i]
+		}
+		kms := &kmsConfiguration{
+			APIVersion: apiVersion,
+			Name:       name,
+			Endpoint:   "unix://" + o.listen[i],
+			Timeout:    o.timeout.String(),
+		}
+		// kube-apiserver rejects a cache size for v2 providers, which cache
+		// their keys
+		if apiVersion == apiV1 && o.cacheSizeSet {
+			kms.CacheSize = &o.cacheSize
+		}
+		p := providerConfiguration{KMS: kms}
+		if key == o.rotateTo {
+			providers = append([]providerConfiguration{p}, providers...)
+		} else {
+			providers = append(providers, p)
+		}
+	}
+	if o.identity {
+		providers = append(providers, providerConfiguration{Identity: &struct{}{}})
+	}
+
+	return &encryptionConfiguration{
+		Kind:       "EncryptionConfiguration",
+		APIVersion: "apiserver.config.k8s.io/v1",
+		Resources:  []resourceConfiguration{{Resources: o.resources, Providers: providers}},
+	}, nil
+}
+
+// providerName derives a provider name from the resource of a key ARN, or
+// from a key ID or alias, e.g. aws-key-1234abcd-12ab-34cd-56ef-1234567890ab
+func providerName(key string) string {
+	if key == "" {
+		return ""
+	}
+	resource := key[strings.LastIndex(key, ":")+1:]
+	return "aws-" + strings.ReplaceAll(resource, "/", "-")
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	oldKey = "arn:aws:kms:us-west-2:111122223333:key/1234abcd-12ab-34cd-56ef-1234567890ab"
+	newKey = "alias/new"
+)
+
+func TestGenEncryptionConfig(t *testing.T) {
+	code, out, stderr := runCommand("", "gen-encryption-config", "--key", oldKey)
+	require.Equal(t, exitOK, code, stderr)
+	assert.Equal(t, `kind: EncryptionConfiguration
+apiVersion: apiserver.config.k8s.io/v1
+resources:
+  - resources:
+      - secrets
+    providers:
+      - kms:
+          apiVersion: v2
+          name: aws-key-1234abcd-12ab-34cd-56ef-1234567890ab
+          endpoint: unix:///var/run/kmsplugin/socket.sock
+          timeout: 3s
+      - identity: {}
+`, out)
+}
+
+func TestGenEncryptionConfigRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "encryption-config.yaml")
+	code, out, stderr := runCommand("", "gen-encryption-config",
+		"--key", oldKey+","+newKey, "--listen", "/var/run/kmsplugin/old.sock,/var/run/kmsplugin/new.sock",
+		"--name", "aws-encryption-provider,aws-encryption-provider-2", "--api-version", "v1,v2",
+		"--cache-size", "100", "--timeout", "10s", "--resources", "secrets,configmaps",
+		"--identity=false", "--rotate-to", newKey, "--out", path)
+	require.Equal(t, exitOK, code, stderr)
+	assert.Empty(t, out)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, `kind: EncryptionConfiguration
+apiVersion: apiserver.config.k8s.io/v1
+resources:
+  - resources:
+      - secrets
+      - configmaps
+    providers:
+      - kms:
+          apiVersion: v2
+          name: aws-encryption-provider-2
+          endpoint: unix:///var/run/kmsplugin/new.sock
+          timeout: 10s
+      - kms:
+          apiVersion: v1
+          name: aws-encryption-provider
+          endpoint: unix:///var/run/kmsplugin/old.sock
+          cachesize: 100
+          timeout: 10s
+`, string(data))
+}
+
+func TestGenEncryptionConfigInvalid(t *testing.T) {
+	tt := []struct {
+		name string
+		args []string
+		err  string
+	}{
+		{name: "no key", err: "--key is required"},
+		{name: "more keys than sockets", args: []string{"--key", oldKey + "," + newKey}, err: "2 keys for 1 sockets"},
+		{name: "relative socket", args: []string{"--key", oldKey, "--listen", "socket.sock"}, err: `socket "socket.sock" must be an absolute path`},
+		{name: "api versions", args: []string{"--key", oldKey, "--api-version", "v1,v2"}, err: "2 API versions for 1 keys"},
+		{name: "api version", args: []string{"--key", oldKey, "--api-version", "v3"}, err: `invalid --api-version "v3"`},
+		{name: "names", args: []string{"--key", oldKey, "--name", "a,b"}, err: "2 names for 1 keys"},
+		{name: "name with colon", args: []string{"--key", oldKey, "--name", "aws:kms"}, err: `provider name "aws:kms" must not contain ':'`},
+		{name: "duplicate name", args: []string{"--key", newKey + "," + newKey, "--listen", "/a.sock,/b.sock"}, err: `provider name "aws-alias-new" is used by more than one key`},
+		{name: "rotate to unknown key", args: []string{"--key", oldKey, "--rotate-to", newKey}, err: "--rotate-to alias/new is not one of --key"},
+		{name: "timeout", args: []string{"--key", oldKey, "--timeout", "0s"}, err: "--timeout must be positive"},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			code, out, stderr := runCommand("", append([]string{"gen-encryption-config"}, tc.args...)...)
+			assert.Equal(t, exitUsage, code)
+			assert.Empty(t, out)
+			assert.Contains(t, stderr, tc.err)
+		})
+	}
+}
@@ -0,0 +1,48 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"time"
+
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+	"sigs.k8s.io/aws-encryption-provider/pkg/plugin"
+)
+
+// inProcessKey is the key of the plugins served in-process
+const inProcessKey = "arn:aws:kms:us-west-2:000000000000:key/in-process"
+
+// startInProcess serves the v1 and v2 plugins backed by a mock KMS answering
+// after latency on a temporary socket, and returns its path and a function
+// stopping them
+func startInProcess(latency time.Duration) (string, func(), error) {
+	c := &cloud.KMSMock{}
+	c.SetEncryptResp("in-process-ciphertext", nil).SetEncryptDelay(latency)
+	c.SetDecryptResp("in-process-plaintext", nil).SetDecryptDelay(latency)
+	return servePlugins(inProcessKey, c, nil)
+}
+
+// servePlugins serves the v1 and v2 plugins of key backed by c on a temporary
+// socket, and returns its path and a function stopping them
+func servePlugins(key string, c cloud.AWSKMSv2, encryptionCtx map[string]string) (string, func(), error) {
+	s, err := plugin.NewLocalServer(key, c, plugin.LocalServerOptions{EncryptionContext: encryptionCtx})
+	if err != nil {
+		return "", nil, err
+	}
+	if err := s.Start(); err != nil {
+		s.Stop()
+		return "", nil, err
+	}
+	return s.Addr, s.Stop, nil
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"sigs.k8s.io/aws-encryption-provider/pkg/storage"
+)
+
+// inspectReport describes a stored value. Byte fields are base64 encoded.
+type inspectReport struct {
+	// APIVersion is the KMS API version of the kube-apiserver envelope, empty
+	// for a bare plugin ciphertext
+	APIVersion string `json:"apiVersion,omitempty"`
+	Provider   string `json:"provider,omitempty"`
+	// KeyID, Annotations and EncryptedDEKSourceType are those of v2 envelopes
+	KeyID                  string                    `json:"keyID,omitempty"`
+	Annotations            map[string][]byte         `json:"annotations,omitempty"`
+	EncryptedDEKSourceType string                    `json:"encryptedDEKSourceType,omitempty"`
+	EncryptedDataLength    int                       `json:"encryptedDataLength,omitempty"`
+	PluginCiphertext       *storage.PluginCiphertext `json:"pluginCiphertext,omitempty"`
+	Problems               []string                  `json:"problems,omitempty"`
+}
+
+// runInspect describes a value stored by kube-apiserver, or a plugin
+// ciphertext, without calling the plugin or KMS. It fails if the value
+// cannot be parsed.
+func runInspect(_ context.Context, args []string, s streams) error {
+	var in, inputFormat string
+	fs := newFlagSet("inspect", s)
+	fs.StringVar(&in, "in", "-", "file to read the value from, - for stdin")
+	fs.StringVar(&inputFormat, "input-format", formatRaw, "format of the value: raw or base64")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if inputFormat != formatRaw && inputFormat != formatBase64 {
+		return usageErrorf("invalid --input-format %q, must be raw or base64", inputFormat)
+	}
+	data, err := readInput(s.in, in, inputFormat)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return usageErrorf("input is empty")
+	}
+
+	report := inspect(data)
+	enc := json.NewEncoder(s.out)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+	if len(report.Problems) > 0 {
+		return fmt.Errorf("value is invalid: %s", report.Problems[0])
+	}
+	return nil
+}
+
+func inspect(data []byte) *inspectReport {
+	report := &inspectReport{}
+	v, err := storage.ParseValue(data)
+	if err != nil {
+		report.Problems = append(report.Problems, err.Error())
+		if bytes.HasSuffix(data, []byte("\n")) {
+			report.Problems = append(report.Problems, "the value ends with a newline, which etcdctl adds after values: remove it, or pass the base64 value of 'etcdctl get -w json' with --input-format base64")
+		}
+		return report
+	}
+
+	report.APIVersion = v.APIVersion
+	report.Provider = v.Provider
+	report.EncryptedDataLength = len(v.EncryptedData)
+	if v.Object != nil {
+		report.KeyID = v.Object.KeyID
+		report.Annotations = v.Object.Annotations
+		report.EncryptedDEKSourceType = v.Object.EncryptedDEKSourceType.String()
+		if v.Object.KeyID == "" {
+			report.Problems = append(report.Problems, "v2 envelope has no key ID")
+		}
+	}
+
+	c, err := storage.InspectPluginCiphertext(v.PluginCiphertext)
+	if len(v.PluginCiphertext) > 0 {
+		report.PluginCiphertext = &c
+	}
+	if err != nil {
+		report.Problems = append(report.Problems, err.Error())
+	}
+	return report
+}
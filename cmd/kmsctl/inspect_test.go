@@ -0,0 +1,81 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/aws-encryption-provider/pkg/storage"
+)
+
+func TestInspect(t *testing.T) {
+	addr := startPlugin(t, newMock())
+
+	// store what the v2 plugin returns the way kube-apiserver does
+	code, out, stderr := runCommand("dek seed", "encrypt", "--socket", addr, "--output", "json")
+	require.Equal(t, exitOK, code, stderr)
+	var enc ciphertextJSON
+	require.NoError(t, json.Unmarshal([]byte(out), &enc))
+	object := &storage.EncryptedObject{
+		EncryptedData:          []byte("encrypted secret"),
+		KeyID:                  enc.KeyID,
+		EncryptedDEKSource:     enc.Ciphertext,
+		Annotations:            enc.Annotations,
+		EncryptedDEKSourceType: storage.HKDFSHA256XNonceAESGCMSeed,
+	}
+	value := append([]byte(storage.PrefixKMSv2+"aws-encryption-provider:"), object.Marshal()...)
+
+	code, out, stderr = runCommand(base64.StdEncoding.EncodeToString(value), "inspect", "--input-format", "base64")
+	require.Equal(t, exitOK, code, stderr)
+	var report inspectReport
+	require.NoError(t, json.Unmarshal([]byte(out), &report))
+	assert.Equal(t, inspectReport{
+		APIVersion:             storage.APIVersionV2,
+		Provider:               "aws-encryption-provider",
+		KeyID:                  testKey,
+		EncryptedDEKSourceType: "HKDF_SHA256_XNONCE_AES_GCM_SEED",
+		EncryptedDataLength:    len("encrypted secret"),
+		PluginCiphertext: &storage.PluginCiphertext{
+			StorageVersion: "1",
+			KMSCiphertext:  storage.InspectBlob([]byte(testCiphertext)),
+		},
+	}, report)
+}
+
+func TestInspectInvalid(t *testing.T) {
+	tt := []struct {
+		name    string
+		value   string
+		problem string
+	}{
+		{name: "storage version", value: "k8s:enc:kms:v1:aws:\x00\x039ab", problem: "unknown storage version 0x39"},
+		{name: "truncated", value: "k8s:enc:kms:v1:aws:\x00\x09ab", problem: "shorter than its 9 bytes encrypted DEK"},
+		{name: "etcdctl newline", value: "k8s:enc:kms:v2:aws:\x12\x03key\n", problem: "ends with a newline"},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			code, out, stderr := runCommand(tc.value, "inspect")
+			assert.Equal(t, exitFailure, code)
+			assert.True(t, strings.HasPrefix(stderr, "kmsctl inspect: value is invalid: "), stderr)
+			var report inspectReport
+			require.NoError(t, json.Unmarshal([]byte(out), &report))
+			assert.Contains(t, strings.Join(report.Problems, "\n"), tc.problem)
+		})
+	}
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// kmsctl calls an aws-encryption-provider plugin over its unix socket the way
+// kube-apiserver does, for use in scripts and when troubleshooting.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	flag "github.com/spf13/pflag"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Exit codes
+const (
+	exitOK = 0
+	// exitFailure means the plugin or KMS returned an error
+	exitFailure = 1
+	// exitUsage means the flags or input are invalid
+	exitUsage = 2
+	// exitUnavailable means the plugin could not be reached in time
+	exitUnavailable = 3
+)
+
+// streams are the standard streams of a command, replaced in tests
+type streams struct {
+	in  io.Reader
+	out io.Writer
+	err io.Writer
+}
+
+type command struct {
+	name    string
+	summary string
+	run     func(ctx context.Context, args []string, s streams) error
+}
+
+func commands() []command {
+	return []command{
+		{name: "encrypt", summary: "encrypt data read from a file or stdin", run: runEncrypt},
+		{name: "decrypt", summary: "decrypt data read from a file or stdin", run: runDecrypt},
+		{name: "status", summary: "report the plugin version and health", run: runStatus},
+		{name: "version", summary: "alias of status", run: runStatus},
+		{name: "bench", summary: "measure latency and throughput under a mix of calls", run: runBench},
+		{name: "doctor", summary: "check the socket, AWS credentials, region and keys of a plugin", run: runDoctor},
+		{name: "rewrap", summary: "re-encrypt ciphertexts under a new key or encryption context", run: runRewrap},
+		{name: "selftest", summary: "check the plugin round trips and decrypts the golden ciphertexts of past releases", run: runSelftest},
+		{name: "gen-encryption-config", summary: "print the EncryptionConfiguration of kube-apiserver for the plugin keys", run: runGenEncryptionConfig},
+		{name: "snapshot", summary: "decrypt the values of an etcd snapshot without kube-apiserver", run: runSnapshot},
+		{name: "inspect", summary: "describe a stored value or ciphertext without calling KMS", run: runInspect},
+	}
+}
+
+func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	code := run(ctx, os.Args[1:], streams{in: os.Stdin, out: os.Stdout, err: os.Stderr})
+	cancel()
+	os.Exit(code)
+}
+
+// run runs the command named by args[0] and returns the exit code
+func run(ctx context.Context, args []string, s streams) int {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		usage(s.err)
+		if len(args) == 0 {
+			return exitUsage
+		}
+		return exitOK
+	}
+	for _, c := range commands() {
+		if c.name != args[0] {
+			continue
+		}
+		err := c.run(ctx, args[1:], s)
+		if err == nil || errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		fmt.Fprintf(s.err, "kmsctl %s: %v\n", c.name, err)
+		return exitCode(err)
+	}
+	fmt.Fprintf(s.err, "kmsctl: unknown command %q\n", args[0])
+	usage(s.err)
+	return exitUsage
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: kmsctl <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	width := 0
+	for _, c := range commands() {
+		width = max(width, len(c.name))
+	}
+	for _, c := range commands() {
+		fmt.Fprintf(w, "  %-*s %s\n", width, c.name, c.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run 'kmsctl <command> --help' for the flags of a command.")
+}
+
+// newFlagSet returns a FlagSet for command that reports errors instead of exiting
+func newFlagSet(name string, s streams) *flag.FlagSet {
+	fs := flag.NewFlagSet("kmsctl "+name, flag.ContinueOnError)
+	fs.SetOutput(s.err)
+	return fs
+}
+
+// parse parses args, returning a usage error for invalid flags or arguments
+func parse(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return usageError(err)
+	}
+	if fs.NArg() > 0 {
+		return usageErrorf("unexpected arguments %v", fs.Args())
+	}
+	return nil
+}
+
+// exitError carries the exit code of an error
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+func usageError(err error) error {
+	return &exitError{code: exitUsage, err: err}
+}
+
+func usageErrorf(format string, a ...any) error {
+	return usageError(fmt.Errorf(format, a...))
+}
+
+// exitCode maps err to an exit code, telling apart errors returned by the
+// plugin from a plugin that cannot be reached
+func exitCode(err error) int {
+	var e *exitError
+	if errors.As(err, &e) {
+		return e.code
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return exitUnavailable
+	}
+	if s, ok := status.FromError(err); ok {
+		switch s.Code() {
+		case codes.Unavailable, codes.DeadlineExceeded:
+			return exitUnavailable
+		}
+	}
+	return exitFailure
+}
@@ -0,0 +1,195 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/aws-encryption-provider/pkg/client"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+)
+
+const (
+	testKey        = "arn:aws:kms:us-west-2:111122223333:key/kmsctl"
+	testPlaintext  = "hello kmsctl"
+	testCiphertext = "kms-ciphertext"
+)
+
+// startPlugin serves the v1 and v2 plugins of testKey backed by c on a unix
+// socket and returns its path
+func startPlugin(t *testing.T, c cloud.AWSKMSv2) string {
+	t.Helper()
+	return startPluginWith(t, testKey, c, nil)
+}
+
+// startPluginWith serves the v1 and v2 plugins of key backed by c with
+// encryptionCtx, like the in-process plugin, and returns the socket path
+func startPluginWith(t *testing.T, key string, c cloud.AWSKMSv2, encryptionCtx map[string]string) string {
+	t.Helper()
+	addr, stop, err := servePlugins(key, c, encryptionCtx)
+	require.NoError(t, err)
+	t.Cleanup(stop)
+	return addr
+}
+
+func newMock() *cloud.KMSMock {
+	c := &cloud.KMSMock{}
+	c.SetEncryptResp(testCiphertext, nil)
+	c.SetDecryptResp(testPlaintext, nil)
+	return c
+}
+
+// runCommand runs kmsctl with args and stdin, returning the exit code, stdout and stderr
+func runCommand(stdin string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), args, streams{in: strings.NewReader(stdin), out: &stdout, err: &stderr})
+	return code, stdout.String(), stderr.String()
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	addr := startPlugin(t, newMock())
+
+	for _, apiVersion := range []string{apiV1, apiV2} {
+		t.Run(apiVersion, func(t *testing.T) {
+			conn := []string{"--socket", addr, "--api-version", apiVersion}
+
+			// base64 output by default, piped into decrypt
+			code, out, stderr := runCommand(testPlaintext, append([]string{"encrypt"}, conn...)...)
+			require.Equal(t, exitOK, code, stderr)
+			ciphertext, err := base64.StdEncoding.DecodeString(strings.TrimSpace(out))
+			require.NoError(t, err)
+			prefix := kmsplugin.StorageVersion
+			if apiVersion == apiV2 {
+				prefix = string(kmsplugin.KMSStorageVersionV2)
+			}
+			assert.Equal(t, prefix+testCiphertext, string(ciphertext))
+
+			code, out, stderr = runCommand(out, append([]string{"decrypt"}, conn...)...)
+			require.Equal(t, exitOK, code, stderr)
+			assert.Equal(t, testPlaintext, out)
+
+			// raw files
+			dir := t.TempDir()
+			in, cipherFile, plainFile := filepath.Join(dir, "in"), filepath.Join(dir, "cipher"), filepath.Join(dir, "plain")
+			require.NoError(t, os.WriteFile(in, []byte(testPlaintext), 0600))
+			code, _, stderr = runCommand("", append([]string{"encrypt", "--in", in, "--out", cipherFile, "--output", "raw"}, conn...)...)
+			require.Equal(t, exitOK, code, stderr)
+			got, err := os.ReadFile(cipherFile)
+			require.NoError(t, err)
+			assert.Equal(t, ciphertext, got)
+			code, _, stderr = runCommand("", append([]string{"decrypt", "--in", cipherFile, "--input-format", "raw", "--out", plainFile}, conn...)...)
+			require.Equal(t, exitOK, code, stderr)
+			got, err = os.ReadFile(plainFile)
+			require.NoError(t, err)
+			assert.Equal(t, testPlaintext, string(got))
+
+			// json
+			code, out, stderr = runCommand(testPlaintext, append([]string{"encrypt", "--output", "json", "--uid", "my-uid"}, conn...)...)
+			require.Equal(t, exitOK, code, stderr)
+			var enc ciphertextJSON
+			require.NoError(t, json.Unmarshal([]byte(out), &enc))
+			assert.Equal(t, ciphertext, enc.Ciphertext)
+			if apiVersion == apiV2 {
+				assert.Equal(t, "my-uid", enc.UID)
+				assert.Equal(t, testKey, enc.KeyID)
+			} else {
+				assert.Empty(t, enc.UID)
+				assert.Empty(t, enc.KeyID)
+			}
+			code, out, stderr = runCommand(out, append([]string{"decrypt", "--input-format", "json", "--output", "json"}, conn...)...)
+			require.Equal(t, exitOK, code, stderr)
+			var dec plaintextJSON
+			require.NoError(t, json.Unmarshal([]byte(out), &dec))
+			assert.Equal(t, testPlaintext, string(dec.Plaintext))
+		})
+	}
+}
+
+func TestEncryptOutputError(t *testing.T) {
+	// writes to /dev/full fail, once the output is flushed
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("/dev/full is not available")
+	}
+	addr := startPlugin(t, newMock())
+
+	code, _, stderr := runCommand(testPlaintext, "encrypt", "--socket", addr, "--out", "/dev/full")
+	assert.Equal(t, exitFailure, code)
+	assert.Contains(t, stderr, "failed to write output")
+}
+
+func TestStatus(t *testing.T) {
+	addr := startPlugin(t, newMock())
+
+	code, out, stderr := runCommand("", "status", "--socket", addr, "--output", "json")
+	require.Equal(t, exitOK, code, stderr)
+	var st client.StatusResponse
+	require.NoError(t, json.Unmarshal([]byte(out), &st))
+	assert.Equal(t, client.StatusResponse{APIVersion: apiV2, Version: "v2beta1", Healthz: healthzOK, KeyID: testKey}, st)
+
+	code, out, stderr = runCommand("", "version", "--socket", addr, "--api-version", apiV1)
+	require.Equal(t, exitOK, code, stderr)
+	assert.Contains(t, out, "API version:     v1")
+	assert.Contains(t, out, "Version:         v1beta1")
+}
+
+func TestExitCodes(t *testing.T) {
+	c := newMock()
+	addr := startPlugin(t, c)
+	missing := filepath.Join(filepath.Dir(addr), "missing.sock")
+
+	tt := []struct {
+		name  string
+		stdin string
+		args  []string
+		want  int
+	}{
+		{name: "no command", want: exitUsage},
+		{name: "help", args: []string{"help"}, want: exitOK},
+		{name: "command help", args: []string{"encrypt", "--help"}, want: exitOK},
+		{name: "unknown command", args: []string{"frobnicate"}, want: exitUsage},
+		{name: "unknown flag", args: []string{"encrypt", "--frobnicate"}, want: exitUsage},
+		{name: "extra arguments", args: []string{"status", "extra"}, want: exitUsage},
+		{name: "invalid api version", args: []string{"status", "--api-version", "v3"}, want: exitUsage},
+		{name: "invalid output", stdin: "x", args: []string{"encrypt", "--output", "yaml"}, want: exitUsage},
+		{name: "empty input", args: []string{"encrypt", "--socket", addr}, want: exitUsage},
+		{name: "missing input file", args: []string{"encrypt", "--socket", addr, "--in", missing}, want: exitUsage},
+		{name: "invalid base64", stdin: "%%%", args: []string{"decrypt", "--socket", addr}, want: exitUsage},
+		{name: "unreachable", stdin: "x", args: []string{"encrypt", "--socket", missing, "--timeout", "200ms"}, want: exitUnavailable},
+		{name: "plugin error", stdin: base64.StdEncoding.EncodeToString([]byte("9invalid")), args: []string{"decrypt", "--socket", addr}, want: exitFailure},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			code, _, stderr := runCommand(tc.stdin, tc.args...)
+			assert.Equal(t, tc.want, code, stderr)
+		})
+	}
+
+	// an unhealthy v2 plugin fails status
+	c.SetEncryptResp("", &kmstypes.DisabledException{Message: aws.String("key disabled")})
+	code, out, _ := runCommand("", "status", "--socket", addr)
+	assert.Equal(t, exitFailure, code)
+	assert.NotContains(t, out, "Healthz:         ok")
+}
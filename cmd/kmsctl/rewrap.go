@@ -0,0 +1,470 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+	"sigs.k8s.io/aws-encryption-provider/pkg/plugin"
+	"sigs.k8s.io/aws-encryption-provider/pkg/storage"
+)
+
+// newKMS creates the KMS client of rewrap, replaced in tests
+var newKMS = cloud.New
+
+type rewrapOptions struct {
+	in                  string
+	out                 string
+	progress            string
+	resume              bool
+	key                 string
+	sourceKey           string
+	encryptionCtx       string
+	sourceEncryptionCtx string
+	region              string
+	kmsEndpoint         string
+	sourceArn           string
+	concurrency         int
+	rate                float64
+	maxErrors           int
+	checkpointLines     int
+	timeout             time.Duration
+
+	// parsed from encryptionCtx and sourceEncryptionCtx
+	destinationContext map[string]string
+	sourceContext      map[string]string
+}
+
+func (o *rewrapOptions) validate() error {
+	var err error
+	switch {
+	case o.key == "":
+		return usageErrorf("--key is required")
+	case o.concurrency <= 0:
+		return usageErrorf("--concurrency must be positive")
+	case o.rate < 0 || math.IsNaN(o.rate):
+		return usageErrorf("--rate must not be negative")
+	case o.rate > 0 && rateInterval(o.rate) <= 0:
+		return usageErrorf("--rate must be at most %d calls per second", time.Second)
+	case o.maxErrors < 0:
+		return usageErrorf("--max-errors must not be negative")
+	case o.checkpointLines <= 0:
+		return usageErrorf("--checkpoint must be positive")
+	case o.timeout <= 0:
+		return usageErrorf("--timeout must be positive")
+	case o.resume && o.progress == "":
+		return usageErrorf("--resume requires --progress")
+	case o.progress != "" && o.out == "-":
+		return usageErrorf("--progress requires --out to be a file, stdout cannot be resumed")
+	}
+	if o.destinationContext, err = plugin.ParseEncryptionContext(o.encryptionCtx); err != nil {
+		return usageErrorf("invalid --encryption-context: %v", err)
+	}
+	if o.sourceContext, err = plugin.ParseEncryptionContext(o.sourceEncryptionCtx); err != nil {
+		return usageErrorf("invalid --source-encryption-context: %v", err)
+	}
+	return nil
+}
+
+// runRewrap re-encrypts plugin ciphertexts under a new key or encryption
+// context with KMS ReEncrypt, the data keys never leaving KMS.
+//
+// Each input line is a base64 plugin ciphertext, optionally preceded by an
+// identifier and a space (e.g. the etcd key it was read from) that is copied
+// to the output line. Lines are written in input order. Lines that fail are
+// skipped and logged to the progress log, until more than --max-errors fail.
+func runRewrap(ctx context.Context, args []string, s streams) error {
+	o := rewrapOptions{}
+	fs := newFlagSet("rewrap", s)
+	fs.StringVar(&o.in, "in", "-", "file to read the ciphertexts from, one per line, - for stdin")
+	fs.StringVar(&o.out, "out", "-", "file to write the new ciphertexts to, - for stdout")
+	fs.StringVar(&o.progress, "progress", "", "file to log progress and failed lines to, needed to resume an interrupted run")
+	fs.BoolVar(&o.resume, "resume", false, "resume the run logged in --progress, skipping the lines already written to --out")
+	fs.StringVar(&o.key, "key", "", "AWS KMS key to re-encrypt to")
+	fs.StringVar(&o.sourceKey, "source-key", "", "AWS KMS key the ciphertexts were encrypted with, checked by KMS if set")
+	fs.StringVar(&o.encryptionCtx, "encryption-context", "", "AWS KMS encryption context to re-encrypt with (e.g. 'a=b,c=d')")
+	fs.StringVar(&o.sourceEncryptionCtx, "source-encryption-context", "", "AWS KMS encryption context the ciphertexts were encrypted with")
+	fs.StringVar(&o.region, "region", "", "AWS region, resolved like the plugin does if empty")
+	fs.StringVar(&o.kmsEndpoint, "kms-endpoint", "", "use this KMS endpoint instead of the one generated by AWS sdk")
+	fs.StringVar(&o.sourceArn, "source-arn", "", "AWS source ARN of the plugin")
+	fs.IntVar(&o.concurrency, "concurrency", 8, "number of ReEncrypt calls in flight at most")
+	fs.Float64Var(&o.rate, "rate", 0, "ReEncrypt calls per second at most, 0 for no limit")
+	fs.IntVar(&o.maxErrors, "max-errors", 0, "number of lines that may fail before the run stops")
+	fs.IntVar(&o.checkpointLines, "checkpoint", 100, "number of lines written between progress checkpoints")
+	fs.DurationVar(&o.timeout, "timeout", 30*time.Second, "deadline of each ReEncrypt call")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if err := o.validate(); err != nil {
+		return err
+	}
+
+	in := s.in
+	if o.in != "-" {
+		f, err := os.Open(o.in)
+		if err != nil {
+			return usageErrorf("failed to open input: %v", err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	var cp checkpoint
+	if o.progress != "" {
+		var err error
+		if cp, err = readProgress(o.progress, o.resume); err != nil {
+			return err
+		}
+	}
+
+	r := &rewrapper{opts: o, stderr: s.err}
+	if o.out == "-" {
+		r.out = bufio.NewWriter(s.out)
+	} else {
+		flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if o.resume {
+			flags = os.O_WRONLY | os.O_CREATE
+		}
+		f, err := os.OpenFile(o.out, flags, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to open output: %v", err)
+		}
+		defer f.Close()
+		// drop what was written after the last checkpoint
+		if err := f.Truncate(cp.Offset); err != nil {
+			return fmt.Errorf("failed to truncate output: %v", err)
+		}
+		if _, err := f.Seek(cp.Offset, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to seek output: %v", err)
+		}
+		r.outFile, r.out, r.offset = f, bufio.NewWriter(f), cp.Offset
+	}
+	if o.progress != "" {
+		f, err := os.OpenFile(o.progress, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to open progress log: %v", err)
+		}
+		defer f.Close()
+		r.progress = f
+	}
+
+	c, err := newKMS(o.region, o.kmsEndpoint, 0, 0, 0, o.sourceArn)
+	if err != nil {
+		return err
+	}
+	r.kms = c
+
+	sum, err := r.run(ctx, in, cp.Lines)
+	fmt.Fprintf(s.err, "kmsctl rewrap: %d ciphertexts re-encrypted, %d failed", sum.rewrapped, sum.failed)
+	if cp.Lines > 0 {
+		fmt.Fprintf(s.err, ", resumed after line %d", cp.Lines)
+	}
+	fmt.Fprintln(s.err)
+	return err
+}
+
+// checkpoint is an entry of the progress log. Lines were read from the
+// input and written to the output up to Offset, if Error is empty.
+// Otherwise Line failed with Error.
+type checkpoint struct {
+	Lines  int    `json:"lines,omitempty"`
+	Offset int64  `json:"offset,omitempty"`
+	Line   int    `json:"line,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// readProgress returns the last checkpoint of the progress log at path,
+// refusing to overwrite an existing log unless resuming
+func readProgress(path string, resume bool) (checkpoint, error) {
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return checkpoint{}, nil
+	case err != nil:
+		return checkpoint{}, fmt.Errorf("failed to read progress log: %v", err)
+	case !resume && len(data) > 0:
+		return checkpoint{}, usageErrorf("progress log %s exists, pass --resume to resume its run or remove it", path)
+	}
+	var last checkpoint
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		var c checkpoint
+		// a line cut short by a crash is ignored
+		if err := json.Unmarshal(sc.Bytes(), &c); err != nil {
+			continue
+		}
+		if c.Error == "" {
+			last = c
+		}
+	}
+	return last, sc.Err()
+}
+
+// rewrapJob is an input line, and the new ciphertext once re-encrypted
+type rewrapJob struct {
+	line       int
+	id         string
+	ciphertext []byte
+	err        error
+}
+
+type rewrapSummary struct {
+	rewrapped int
+	failed    int
+}
+
+type rewrapper struct {
+	opts     rewrapOptions
+	kms      cloud.AWSKMSv2
+	stderr   io.Writer
+	out      *bufio.Writer
+	outFile  *os.File
+	progress *os.File
+	offset   int64
+}
+
+// run re-encrypts the lines of in after the first skip ones. Workers
+// re-encrypt lines concurrently and the results are written in input order,
+// checkpointing every --checkpoint lines.
+func (r *rewrapper) run(ctx context.Context, in io.Reader, skip int) (rewrapSummary, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// with a rate, workers wait for a tick before each call
+	var ticks <-chan time.Time
+	if r.opts.rate > 0 {
+		ticker := time.NewTicker(rateInterval(r.opts.rate))
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	// tokens bound the lines read ahead of the next line to write
+	tokens := make(chan struct{}, 4*r.opts.concurrency)
+	jobs := make(chan *rewrapJob)
+	results := make(chan *rewrapJob)
+	var readErr error
+
+	go func() {
+		defer close(jobs)
+		sc := bufio.NewScanner(in)
+		sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for line := 1; sc.Scan(); line++ {
+			if line <= skip {
+				continue
+			}
+			select {
+			case tokens <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			job := &rewrapJob{line: line}
+			job.id, job.ciphertext, job.err = parseRewrapLine(sc.Text())
+			select {
+			case jobs <- job:
+			case <-ctx.Done():
+				return
+			}
+		}
+		readErr = sc.Err()
+	}()
+
+	var wg sync.WaitGroup
+	for range r.opts.concurrency {
+		wg.Go(func() {
+			for job := range jobs {
+				if job.err == nil && job.ciphertext != nil {
+					if ticks != nil {
+						select {
+						case <-ticks:
+						case <-ctx.Done():
+						}
+					}
+					job.ciphertext, job.err = r.rewrap(ctx, job.ciphertext)
+				}
+				select {
+				case results <- job:
+				case <-ctx.Done():
+					return
+				}
+			}
+		})
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var (
+		sum     rewrapSummary
+		next    = skip + 1
+		pending = map[int]*rewrapJob{}
+		err     error
+	)
+	for job := range results {
+		if err != nil {
+			continue
+		}
+		pending[job.line] = job
+		for job, ok := pending[next]; ok && err == nil; job, ok = pending[next] {
+			// calls cut short by an interruption are not failures, they
+			// are retried on resume
+			if err = ctx.Err(); err != nil {
+				break
+			}
+			delete(pending, next)
+			<-tokens
+			if err = r.write(job, &sum); err != nil {
+				break
+			}
+			next++
+			if (next-1)%r.opts.checkpointLines == 0 {
+				err = r.checkpoint(next - 1)
+			}
+		}
+		if err != nil {
+			cancel()
+		}
+	}
+	if err == nil && readErr != nil {
+		err = fmt.Errorf("failed to read input: %v", readErr)
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	// lines written before an error are kept, and resumed after
+	if cpErr := r.checkpoint(next - 1); err == nil {
+		err = cpErr
+	}
+	return sum, err
+}
+
+// write writes the result of job, or logs its error until there are more
+// than --max-errors
+func (r *rewrapper) write(job *rewrapJob, sum *rewrapSummary) error {
+	if job.err != nil {
+		sum.failed++
+		if sum.failed > r.opts.maxErrors {
+			// not logged as failed, the line is retried on resume
+			return fmt.Errorf("line %d: %v", job.line, job.err)
+		}
+		fmt.Fprintf(r.stderr, "kmsctl rewrap: line %d: %v\n", job.line, job.err)
+		return r.log(checkpoint{Line: job.line, Error: job.err.Error()})
+	}
+	if job.ciphertext == nil {
+		return nil
+	}
+	var line strings.Builder
+	if job.id != "" {
+		line.WriteString(job.id)
+		line.WriteByte(' ')
+	}
+	line.WriteString(base64.StdEncoding.EncodeToString(job.ciphertext))
+	line.WriteByte('\n')
+	n, err := r.out.WriteString(line.String())
+	r.offset += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write output: %v", err)
+	}
+	sum.rewrapped++
+	return nil
+}
+
+// checkpoint flushes the output to disk and logs that lines are done
+func (r *rewrapper) checkpoint(lines int) error {
+	if err := r.out.Flush(); err != nil {
+		return fmt.Errorf("failed to write output: %v", err)
+	}
+	if r.progress == nil {
+		return nil
+	}
+	if err := r.outFile.Sync(); err != nil {
+		return fmt.Errorf("failed to sync output: %v", err)
+	}
+	return r.log(checkpoint{Lines: lines, Offset: r.offset})
+}
+
+func (r *rewrapper) log(c checkpoint) error {
+	if r.progress == nil {
+		return nil
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if _, err := r.progress.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("failed to write progress log: %v", err)
+	}
+	return r.progress.Sync()
+}
+
+// rewrap re-encrypts the KMS ciphertext of a plugin ciphertext and returns
+// the new plugin ciphertext
+func (r *rewrapper) rewrap(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.opts.timeout)
+	defer cancel()
+	input := &kms.ReEncryptInput{
+		CiphertextBlob:               ciphertext[1:],
+		DestinationKeyId:             aws.String(r.opts.key),
+		DestinationEncryptionContext: r.opts.destinationContext,
+		SourceEncryptionContext:      r.opts.sourceContext,
+	}
+	if r.opts.sourceKey != "" {
+		input.SourceKeyId = aws.String(r.opts.sourceKey)
+	}
+	out, err := r.kms.ReEncrypt(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("ReEncrypt failed: %w", err)
+	}
+	return append([]byte(kmsplugin.StorageVersion), out.CiphertextBlob...), nil
+}
+
+// parseRewrapLine returns the identifier and plugin ciphertext of an input
+// line, or a nil ciphertext for a blank line
+func parseRewrapLine(line string) (string, []byte, error) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return "", nil, nil
+	}
+	id, data, ok := strings.Cut(line, " ")
+	if !ok {
+		id, data = "", line
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(strings.TrimSpace(data))
+	if err != nil {
+		return id, nil, fmt.Errorf("invalid base64: %v", err)
+	}
+	if _, err := storage.InspectPluginCiphertext(ciphertext); err != nil {
+		return id, nil, err
+	}
+	if len(ciphertext) == 1 {
+		return id, nil, errors.New("plugin ciphertext has no KMS ciphertext")
+	}
+	return id, ciphertext, nil
+}
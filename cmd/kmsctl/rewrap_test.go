@@ -0,0 +1,188 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+)
+
+const rewrapKey = "arn:aws:kms:us-west-2:111122223333:key/new"
+
+// useMock makes rewrap call c
+func useMock(t *testing.T, c *cloud.KMSMock) {
+	newKMS = func(string, string, int, int, int, string) (cloud.AWSKMSv2, error) { return c, nil }
+	t.Cleanup(func() { newKMS = cloud.New })
+}
+
+// addRewrapRules makes c re-encrypt the KMS ciphertext "old-<i>" of each of n
+// lines to "new-<i>", and returns the lines
+func addRewrapRules(c *cloud.KMSMock, n int) string {
+	var in strings.Builder
+	for i := 1; i <= n; i++ {
+		old := fmt.Sprintf("old-%d", i)
+		c.AddReEncryptRule(func(params *kms.ReEncryptInput) bool {
+			return string(params.CiphertextBlob) == old
+		}, fmt.Sprintf("new-%d", i), nil)
+		fmt.Fprintf(&in, "s%d %s\n", i, base64.StdEncoding.EncodeToString([]byte(kmsplugin.StorageVersion+old)))
+	}
+	c.SetReEncryptResp("", &kmstypes.InvalidCiphertextException{Message: aws.String("unknown ciphertext")})
+	return in.String()
+}
+
+// rewrapped returns the output lines from to to of addRewrapRules
+func rewrapped(from, to int) string {
+	var out strings.Builder
+	for i := from; i <= to; i++ {
+		fmt.Fprintf(&out, "s%d %s\n", i, base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%snew-%d", kmsplugin.StorageVersion, i))))
+	}
+	return out.String()
+}
+
+func TestRewrap(t *testing.T) {
+	c := &cloud.KMSMock{}
+	useMock(t, c)
+	c.AddReEncryptRule(func(params *kms.ReEncryptInput) bool {
+		return aws.ToString(params.DestinationKeyId) != rewrapKey ||
+			aws.ToString(params.SourceKeyId) != "old" ||
+			params.DestinationEncryptionContext["cluster"] != "prod" ||
+			params.SourceEncryptionContext["cluster"] != "dev"
+	}, "", &kmstypes.IncorrectKeyException{Message: aws.String("unexpected parameters")})
+	in := addRewrapRules(c, 50)
+
+	// blank lines are skipped and lines may have no identifier
+	in = "\n" + in + base64.StdEncoding.EncodeToString([]byte(kmsplugin.StorageVersion+"old-1")) + "\n"
+	code, out, stderr := runCommand(in, "rewrap",
+		"--key", rewrapKey,
+		"--source-key", "old",
+		"--encryption-context", "cluster=prod",
+		"--source-encryption-context", "cluster=dev",
+		"--concurrency", "8",
+		"--rate", "1000",
+	)
+	require.Equal(t, exitOK, code, stderr)
+	assert.Equal(t, rewrapped(1, 50)+base64.StdEncoding.EncodeToString([]byte(kmsplugin.StorageVersion+"new-1"))+"\n", out)
+	assert.Equal(t, "kmsctl rewrap: 51 ciphertexts re-encrypted, 0 failed\n", stderr)
+}
+
+func TestRewrapMaxErrors(t *testing.T) {
+	c := &cloud.KMSMock{}
+	useMock(t, c)
+	in := addRewrapRules(c, 3)
+	in = strings.Replace(in, "s2 ", "s2 %%%", 1) + "s4 " + base64.StdEncoding.EncodeToString([]byte("9old-4")) + "\n"
+
+	dir := t.TempDir()
+	out, progress := filepath.Join(dir, "out"), filepath.Join(dir, "progress")
+	code, _, stderr := runCommand(in, "rewrap", "--key", rewrapKey, "--out", out, "--progress", progress, "--max-errors", "2")
+	require.Equal(t, exitOK, code, stderr)
+	assert.Contains(t, stderr, "line 2: invalid base64")
+	assert.Contains(t, stderr, "line 4: unknown storage version 0x39")
+	assert.Contains(t, stderr, "2 ciphertexts re-encrypted, 2 failed")
+
+	got, err := os.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, rewrapped(1, 1)+rewrapped(3, 3), string(got))
+	got, err = os.ReadFile(progress)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(got)), "\n")
+	require.Len(t, lines, 3)
+	assert.Contains(t, lines[0], `{"line":2,"error":"invalid base64`)
+	assert.Contains(t, lines[1], `{"line":4,"error":"unknown storage version`)
+	assert.Equal(t, fmt.Sprintf(`{"lines":4,"offset":%d}`, len(rewrapped(1, 1)+rewrapped(3, 3))), lines[2])
+
+	// one more failure than allowed stops the run
+	code, _, stderr = runCommand(in, "rewrap", "--key", rewrapKey, "--out", out, "--max-errors", "1")
+	assert.Equal(t, exitFailure, code)
+	assert.Contains(t, stderr, "kmsctl rewrap: line 4: unknown storage version")
+}
+
+func TestRewrapResume(t *testing.T) {
+	c := &cloud.KMSMock{}
+	useMock(t, c)
+	// line 7 fails until the key is fixed
+	c.AddReEncryptRule(func(params *kms.ReEncryptInput) bool {
+		return string(params.CiphertextBlob) == "old-7"
+	}, "", &kmstypes.DisabledException{Message: aws.String("key disabled")})
+	in := addRewrapRules(c, 20)
+
+	dir := t.TempDir()
+	out, progress := filepath.Join(dir, "out"), filepath.Join(dir, "progress")
+	args := []string{"rewrap", "--key", rewrapKey, "--out", out, "--progress", progress, "--checkpoint", "5", "--concurrency", "4"}
+	code, _, stderr := runCommand(in, args...)
+	require.Equal(t, exitFailure, code)
+	assert.Contains(t, stderr, "kmsctl rewrap: line 7: ReEncrypt failed")
+	assert.Contains(t, stderr, "6 ciphertexts re-encrypted, 1 failed")
+	got, err := os.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, rewrapped(1, 6), string(got))
+
+	// the progress log is not overwritten
+	code, _, stderr = runCommand(in, args...)
+	require.Equal(t, exitUsage, code)
+	assert.Contains(t, stderr, "pass --resume")
+
+	// a crash after the last checkpoint left a partial line behind
+	f, err := os.OpenFile(out, os.O_WRONLY|os.O_APPEND, 0600)
+	require.NoError(t, err)
+	_, err = f.WriteString("s7 partial")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	c.ClearRules()
+	in = addRewrapRules(c, 20)
+	code, _, stderr = runCommand(in, append(args, "--resume")...)
+	require.Equal(t, exitOK, code, stderr)
+	assert.Equal(t, "kmsctl rewrap: 14 ciphertexts re-encrypted, 0 failed, resumed after line 6\n", stderr)
+	got, err = os.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, rewrapped(1, 20), string(got))
+}
+
+func TestRewrapUsage(t *testing.T) {
+	tt := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{name: "no key", args: []string{}, want: "--key is required"},
+		{name: "resume without progress", args: []string{"--key", "k", "--resume"}, want: "--resume requires --progress"},
+		{name: "progress to stdout", args: []string{"--key", "k", "--progress", "p"}, want: "stdout cannot be resumed"},
+		{name: "invalid encryption context", args: []string{"--key", "k", "--encryption-context", "a"}, want: "invalid --encryption-context"},
+		{name: "no concurrency", args: []string{"--key", "k", "--concurrency", "0"}, want: "--concurrency must be positive"},
+		{name: "negative rate", args: []string{"--key", "k", "--rate", "-1"}, want: "--rate must not be negative"},
+		{name: "NaN rate", args: []string{"--key", "k", "--rate", "NaN"}, want: "--rate must not be negative"},
+		// the interval between calls rounds to 0
+		{name: "rate too high", args: []string{"--key", "k", "--rate", "2e9"}, want: "--rate must be at most"},
+		{name: "infinite rate", args: []string{"--key", "k", "--rate", "+Inf"}, want: "--rate must be at most"},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			code, _, stderr := runCommand("", append([]string{"rewrap"}, tc.args...)...)
+			assert.Equal(t, exitUsage, code)
+			assert.Contains(t, stderr, tc.want)
+		})
+	}
+}
@@ -0,0 +1,291 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/aws-encryption-provider/pkg/client"
+	"sigs.k8s.io/aws-encryption-provider/pkg/connection"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+	"sigs.k8s.io/aws-encryption-provider/pkg/plugin"
+)
+
+// goldenFile holds ciphertexts written by past releases of the plugin, which
+// later releases must still decrypt
+type goldenFile struct {
+	// Key and EncryptionContext are those of the plugin that encrypted the
+	// ciphertexts
+	Key               string             `json:"key"`
+	EncryptionContext map[string]string  `json:"encryptionContext,omitempty"`
+	Ciphertexts       []goldenCiphertext `json:"ciphertexts"`
+}
+
+type goldenCiphertext struct {
+	Name       string `json:"name"`
+	APIVersion string `json:"apiVersion"`
+	// Prefix is what the plugin prepended to the KMS ciphertext, the storage
+	// version or nothing for ciphertexts of releases before it
+	Prefix      string            `json:"prefix"`
+	Ciphertext  []byte            `json:"ciphertext"`
+	KeyID       string            `json:"keyId,omitempty"`
+	Annotations map[string][]byte `json:"annotations,omitempty"`
+	Plaintext   []byte            `json:"plaintext,omitempty"`
+	// Error is part of the error the plugin must fail to decrypt with, for
+	// ciphertexts it rejects
+	Error string `json:"error,omitempty"`
+}
+
+func readGoldenFile(path string) (*goldenFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var g goldenFile
+	if err := json.Unmarshal(data, &g); err != nil {
+		return nil, fmt.Errorf("invalid golden file %s: %v", path, err)
+	}
+	for i, c := range g.Ciphertexts {
+		switch {
+		case c.Name == "":
+			return nil, fmt.Errorf("invalid golden file %s: ciphertext %d has no name", path, i)
+		case c.APIVersion != apiV1 && c.APIVersion != apiV2:
+			return nil, fmt.Errorf("invalid golden file %s: %s: invalid apiVersion %q", path, c.Name, c.APIVersion)
+		}
+	}
+	return &g, nil
+}
+
+type selftestOptions struct {
+	socket        string
+	key           string
+	encryptionCtx string
+	region        string
+	kmsEndpoint   string
+	sourceArn     string
+	golden        string
+	timeout       time.Duration
+	output        string
+}
+
+// runSelftest encrypts and decrypts through the v1 and v2 plugins, listening
+// on --socket or served in-process, then decrypts the golden ciphertexts of
+// past releases and reports the plaintexts that do not match
+func runSelftest(ctx context.Context, args []string, s streams) error {
+	o := selftestOptions{}
+	fs := newFlagSet("selftest", s)
+	fs.StringVar(&o.socket, "socket", "", "unix socket of a running plugin, the plugin is served in-process with the flags below if empty")
+	fs.StringVar(&o.key, "key", "", "AWS KMS key of the in-process plugin")
+	fs.StringVar(&o.encryptionCtx, "encryption-context", "", "AWS KMS encryption context of the in-process plugin (e.g. 'a=b,c=d')")
+	fs.StringVar(&o.region, "region", "", "AWS region of the in-process plugin, resolved like the plugin does if empty")
+	fs.StringVar(&o.kmsEndpoint, "kms-endpoint", "", "KMS endpoint of the in-process plugin")
+	fs.StringVar(&o.sourceArn, "source-arn", "", "AWS source ARN of the in-process plugin")
+	fs.StringVar(&o.golden, "golden", "", "JSON file of golden ciphertexts to decrypt")
+	fs.DurationVar(&o.timeout, "timeout", 10*time.Second, "deadline of each call to the plugin")
+	fs.StringVar(&o.output, "output", "text", "format of the report: text or json")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	switch {
+	case o.timeout <= 0:
+		return usageErrorf("--timeout must be positive")
+	case o.output != "text" && o.output != formatJSON:
+		return usageErrorf("invalid --output %q, must be text or json", o.output)
+	}
+	encryptionCtx, err := plugin.ParseEncryptionContext(o.encryptionCtx)
+	if err != nil {
+		return usageErrorf("invalid --encryption-context: %v", err)
+	}
+	var golden *goldenFile
+	if o.golden != "" {
+		if golden, err = readGoldenFile(o.golden); err != nil {
+			return usageErrorf("%v", err)
+		}
+		// the in-process plugin is configured like the one that encrypted the
+		// golden ciphertexts, unless told otherwise
+		if !fs.Changed("key") {
+			o.key = golden.Key
+		}
+		if !fs.Changed("encryption-context") {
+			encryptionCtx = golden.EncryptionContext
+		}
+	}
+	if o.socket == "" && o.key == "" {
+		return usageErrorf("--socket or --key is required")
+	}
+
+	socket := o.socket
+	if socket == "" {
+		c, err := newKMS(o.region, o.kmsEndpoint, 0, 0, 0, o.sourceArn)
+		if err != nil {
+			return err
+		}
+		addr, stop, err := servePlugins(o.key, c, encryptionCtx)
+		if err != nil {
+			return err
+		}
+		defer stop()
+		socket = addr
+	}
+	conn, err := connection.New(socket)
+	if err != nil {
+		return err
+	}
+	defer conn.Close() //nolint:errcheck
+	clients := map[string]client.Client{}
+	for _, apiVersion := range []string{apiV1, apiV2} {
+		if clients[apiVersion], err = client.NewFromConn(ctx, conn, client.Options{APIVersion: apiVersion, Timeout: o.timeout}); err != nil {
+			return err
+		}
+	}
+
+	var results []checkResult
+	for _, apiVersion := range []string{apiV1, apiV2} {
+		results = append(results, roundTrip(ctx, clients[apiVersion]))
+	}
+	if golden != nil {
+		var check checkResult
+		if o.socket == "" {
+			check = checkGoldenConfig(golden, o.key, encryptionCtx)
+		} else {
+			check = checkGoldenKey(ctx, clients[apiV2], golden)
+		}
+		results = append(results, check)
+		// the golden ciphertexts are expected to fail to decrypt with another
+		// configuration, which the check already reports
+		if check.Status != checkFail {
+			for _, c := range golden.Ciphertexts {
+				results = append(results, decryptGolden(ctx, clients[c.APIVersion], c))
+			}
+		}
+	}
+
+	if o.output == formatJSON {
+		enc := json.NewEncoder(s.out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			return err
+		}
+	} else {
+		printChecks(s.out, results)
+	}
+	if n := countChecks(results, checkFail); n > 0 {
+		return fmt.Errorf("%d checks failed", n)
+	}
+	return nil
+}
+
+// roundTrip encrypts a random plaintext the size of a data encryption key
+// and decrypts it back
+func roundTrip(ctx context.Context, c client.Client) checkResult {
+	name := c.APIVersion() + " round trip"
+	fail := func(format string, args ...any) checkResult {
+		return checkResult{Name: name, Status: checkFail, Message: fmt.Sprintf(format, args...)}
+	}
+
+	plaintext := make([]byte, 32)
+	_, _ = rand.Read(plaintext)
+	uid := client.NewUID()
+	enc, err := c.Encrypt(ctx, uid, plaintext)
+	if err != nil {
+		return fail("encrypt failed: %v", err)
+	}
+	if !bytes.HasPrefix(enc.Ciphertext, []byte(kmsplugin.StorageVersion)) {
+		return fail("ciphertext does not start with storage version %s", kmsplugin.StorageVersion)
+	}
+	if c.APIVersion() == apiV2 && enc.KeyID == "" {
+		return fail("encrypt returned no key ID")
+	}
+	got, err := c.Decrypt(ctx, uid, enc.Ciphertext, enc.KeyID, enc.Annotations)
+	if err != nil {
+		return fail("decrypt failed: %v", err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		return fail("decrypted plaintext does not match the encrypted one")
+	}
+	return checkResult{Name: name, Status: checkPass, Message: fmt.Sprintf("%d bytes ciphertext decrypted back", len(enc.Ciphertext))}
+}
+
+const goldenConfigCheck = "golden configuration"
+
+// checkGoldenConfig compares the key and encryption context of the in-process
+// plugin with those the golden ciphertexts were encrypted with
+func checkGoldenConfig(golden *goldenFile, key string, encryptionCtx map[string]string) checkResult {
+	hint := "run with the --key and --encryption-context of the golden file, or leave them unset to use those"
+	switch {
+	case golden.Key != "" && key != golden.Key:
+		return checkResult{Name: goldenConfigCheck, Status: checkFail,
+			Message: fmt.Sprintf("the golden ciphertexts were encrypted with key %s, the plugin uses %s", golden.Key, key), Hint: hint}
+	case !maps.Equal(encryptionCtx, golden.EncryptionContext):
+		// values of the encryption context may be sensitive
+		return checkResult{Name: goldenConfigCheck, Status: checkFail,
+			Message: fmt.Sprintf("the golden ciphertexts were encrypted with encryption context keys %v, the plugin uses %v or other values",
+				slices.Sorted(maps.Keys(golden.EncryptionContext)), slices.Sorted(maps.Keys(encryptionCtx))), Hint: hint}
+	}
+	return checkResult{Name: goldenConfigCheck, Status: checkPass, Message: "the plugin uses the key and encryption context of the golden ciphertexts"}
+}
+
+// checkGoldenKey compares the key a running plugin reports with the one the
+// golden ciphertexts were encrypted with, its encryption context is unknown
+func checkGoldenKey(ctx context.Context, c client.Client, golden *goldenFile) checkResult {
+	if golden.Key == "" {
+		return checkResult{Name: goldenConfigCheck, Status: checkSkip, Message: "the golden file has no key"}
+	}
+	res, err := c.Status(ctx)
+	switch {
+	case err != nil:
+		return checkResult{Name: goldenConfigCheck, Status: checkWarn, Message: fmt.Sprintf("status failed, the key of the plugin is unknown: %v", err)}
+	case res.KeyID != golden.Key:
+		return checkResult{Name: goldenConfigCheck, Status: checkFail,
+			Message: fmt.Sprintf("the golden ciphertexts were encrypted with key %s, the plugin uses %s", golden.Key, res.KeyID),
+			Hint:    "run against a plugin started with the --key and --encryption-context of the golden file"}
+	}
+	return checkResult{Name: goldenConfigCheck, Status: checkPass, Message: "the plugin uses the key of the golden ciphertexts, its encryption context must match too"}
+}
+
+// decryptGolden decrypts a golden ciphertext and compares the plaintext, or
+// the error for ciphertexts the plugin rejects
+func decryptGolden(ctx context.Context, c client.Client, g goldenCiphertext) checkResult {
+	name := "golden " + g.Name
+	fail := func(format string, args ...any) checkResult {
+		return checkResult{Name: name, Status: checkFail, Message: fmt.Sprintf(format, args...)}
+	}
+
+	if !bytes.HasPrefix(g.Ciphertext, []byte(g.Prefix)) {
+		return fail("ciphertext does not start with its prefix %q", g.Prefix)
+	}
+	got, err := c.Decrypt(ctx, client.NewUID(), g.Ciphertext, g.KeyID, g.Annotations)
+	switch {
+	case g.Error != "" && err == nil:
+		return fail("decrypted, expected an error containing %q", g.Error)
+	case g.Error != "" && !strings.Contains(err.Error(), g.Error):
+		return fail("decrypt failed with %v, expected an error containing %q", err, g.Error)
+	case g.Error != "":
+		return checkResult{Name: name, Status: checkPass, Message: "rejected as expected"}
+	case err != nil:
+		return fail("decrypt failed: %v", err)
+	case !bytes.Equal(got, g.Plaintext):
+		return fail("decrypted plaintext does not match the golden one")
+	}
+	return checkResult{Name: name, Status: checkPass, Message: "decrypted to the golden plaintext"}
+}
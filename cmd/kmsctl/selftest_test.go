@@ -0,0 +1,182 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+)
+
+const goldenPath = "testdata/golden.json"
+
+// echoKMS encrypts by prefixing the plaintext, so that round trips of random
+// plaintexts succeed, and leaves other ciphertexts to the mock
+type echoKMS struct {
+	*cloud.KMSMock
+}
+
+var echoPrefix = []byte("echo:")
+
+func (e echoKMS) Encrypt(_ context.Context, params *kms.EncryptInput, _ ...func(*kms.Options)) (*kms.EncryptOutput, error) {
+	return &kms.EncryptOutput{CiphertextBlob: append(bytes.Clone(echoPrefix), params.Plaintext...), KeyId: params.KeyId}, nil
+}
+
+func (e echoKMS) Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error) {
+	if plaintext, ok := bytes.CutPrefix(params.CiphertextBlob, echoPrefix); ok {
+		return &kms.DecryptOutput{Plaintext: plaintext}, nil
+	}
+	return e.KMSMock.Decrypt(ctx, params, optFns...)
+}
+
+// goldenMock decrypts the KMS ciphertext of each golden ciphertext to its
+// plaintext, provided it is called with the golden encryption context
+func goldenMock(t *testing.T) (*goldenFile, *cloud.KMSMock) {
+	t.Helper()
+	golden, err := readGoldenFile(goldenPath)
+	require.NoError(t, err)
+	c := &cloud.KMSMock{}
+	c.SetDecryptResp("", errors.New("InvalidCiphertextException"))
+	for _, g := range golden.Ciphertexts {
+		blob := g.Ciphertext[len(g.Prefix):]
+		c.AddDecryptRule(func(params *kms.DecryptInput) bool {
+			return bytes.Equal(params.CiphertextBlob, blob) && maps.Equal(params.EncryptionContext, golden.EncryptionContext)
+		}, string(g.Plaintext), nil)
+	}
+	return golden, c
+}
+
+// serveGolden serves the plugins the golden ciphertexts were encrypted with
+func serveGolden(t *testing.T, c cloud.AWSKMSv2) string {
+	t.Helper()
+	golden, err := readGoldenFile(goldenPath)
+	require.NoError(t, err)
+	return startPluginWith(t, golden.Key, c, golden.EncryptionContext)
+}
+
+func TestSelftestGolden(t *testing.T) {
+	golden, c := goldenMock(t)
+	addr := serveGolden(t, echoKMS{c})
+
+	code, out, stderr := runCommand("", "selftest", "--socket", addr, "--golden", goldenPath, "--output", "json")
+	require.Equal(t, exitOK, code, stderr)
+	var results []checkResult
+	require.NoError(t, json.Unmarshal([]byte(out), &results))
+	require.Len(t, results, 3+len(golden.Ciphertexts))
+	assert.Equal(t, "v1 round trip", results[0].Name)
+	assert.Equal(t, "v2 round trip", results[1].Name)
+	assert.Equal(t, goldenConfigCheck, results[2].Name)
+	for i, g := range golden.Ciphertexts {
+		assert.Equal(t, "golden "+g.Name, results[3+i].Name)
+	}
+	for _, r := range results {
+		assert.Equal(t, checkPass, r.Status, "%s: %s", r.Name, r.Message)
+	}
+}
+
+func TestSelftestInProcess(t *testing.T) {
+	_, c := goldenMock(t)
+	newKMS = func(string, string, int, int, int, string) (cloud.AWSKMSv2, error) { return echoKMS{c}, nil }
+	t.Cleanup(func() { newKMS = cloud.New })
+
+	// the in-process plugin takes the key and encryption context of the golden file
+	code, out, stderr := runCommand("", "selftest", "--golden", goldenPath)
+	require.Equal(t, exitOK, code, stderr)
+	assert.Contains(t, out, "7 passed, 0 warnings, 0 failed, 0 skipped")
+
+	// the golden ciphertexts are not decrypted with another configuration
+	for _, args := range [][]string{
+		{"--key", testKey},
+		{"--encryption-context", "cluster=other"},
+		{"--encryption-context", ""},
+	} {
+		code, out, _ = runCommand("", append([]string{"selftest", "--golden", goldenPath}, args...)...)
+		assert.Equal(t, exitFailure, code, args)
+		assert.Contains(t, out, "FAIL  golden configuration", args)
+		assert.Contains(t, out, "2 passed, 0 warnings, 1 failed, 0 skipped", args)
+	}
+}
+
+func TestSelftestOtherKey(t *testing.T) {
+	_, c := goldenMock(t)
+	addr := startPlugin(t, echoKMS{c})
+
+	code, out, _ := runCommand("", "selftest", "--socket", addr, "--golden", goldenPath)
+	assert.Equal(t, exitFailure, code)
+	assert.Contains(t, out, "the plugin uses "+testKey)
+	assert.Contains(t, out, "2 passed, 0 warnings, 1 failed, 0 skipped")
+}
+
+func TestSelftestMismatch(t *testing.T) {
+	golden, err := readGoldenFile(goldenPath)
+	require.NoError(t, err)
+	// a KMS decrypting every ciphertext to the same plaintext, the plugin still
+	// rejects the ciphertexts without storage version before calling it
+	c := &cloud.KMSMock{}
+	c.SetDecryptResp("other plaintext", nil)
+	addr := serveGolden(t, echoKMS{c})
+
+	code, out, stderr := runCommand("", "selftest", "--socket", addr, "--golden", goldenPath, "--output", "json")
+	assert.Equal(t, exitFailure, code)
+	assert.Contains(t, stderr, "checks failed")
+	var results []checkResult
+	require.NoError(t, json.Unmarshal([]byte(out), &results))
+	require.Len(t, results, 3+len(golden.Ciphertexts))
+	assert.Equal(t, checkPass, results[2].Status)
+	for i, g := range golden.Ciphertexts {
+		r := results[3+i]
+		if g.Error != "" {
+			assert.Equal(t, checkPass, r.Status, r.Name)
+			continue
+		}
+		assert.Equal(t, checkFail, r.Status, r.Name)
+		assert.Equal(t, "decrypted plaintext does not match the golden one", r.Message)
+	}
+}
+
+func TestSelftestInvalid(t *testing.T) {
+	invalid := filepath.Join(t.TempDir(), "golden.json")
+	require.NoError(t, os.WriteFile(invalid, []byte(`{"ciphertexts": [{"name": "v3", "apiVersion": "v3"}]}`), 0600))
+	keyless := filepath.Join(t.TempDir(), "keyless.json")
+	require.NoError(t, os.WriteFile(keyless, []byte(`{"ciphertexts": []}`), 0600))
+	tt := []struct {
+		name string
+		args []string
+		err  string
+	}{
+		{name: "no socket or key", err: "--socket or --key is required"},
+		{name: "no key in golden file", args: []string{"--golden", keyless}, err: "--socket or --key is required"},
+		{name: "output", args: []string{"--key", testKey, "--output", "yaml"}, err: `invalid --output "yaml"`},
+		{name: "encryption context", args: []string{"--key", testKey, "--encryption-context", "a"}, err: "invalid --encryption-context"},
+		{name: "missing golden file", args: []string{"--key", testKey, "--golden", filepath.Join(t.TempDir(), "missing.json")}, err: "no such file"},
+		{name: "invalid golden file", args: []string{"--key", testKey, "--golden", invalid}, err: `v3: invalid apiVersion "v3"`},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			code, _, stderr := runCommand("", append([]string{"selftest"}, tc.args...)...)
+			assert.Equal(t, exitUsage, code)
+			assert.Contains(t, stderr, tc.err)
+		})
+	}
+}
@@ -0,0 +1,172 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/aws-encryption-provider/pkg/client"
+	"sigs.k8s.io/aws-encryption-provider/pkg/connection"
+	"sigs.k8s.io/aws-encryption-provider/pkg/plugin"
+	"sigs.k8s.io/aws-encryption-provider/pkg/storage"
+)
+
+type snapshotOptions struct {
+	in            string
+	outDir        string
+	prefix        string
+	socket        string
+	key           string
+	encryptionCtx string
+	region        string
+	kmsEndpoint   string
+	sourceArn     string
+	timeout       time.Duration
+}
+
+// runSnapshot decrypts the values a KMS provider encrypted in an etcd
+// snapshot, without kube-apiserver, and writes each to a file named after
+// its etcd key. Their keys are decrypted by the plugin, listening on --socket
+// or served in-process, and their data locally.
+func runSnapshot(ctx context.Context, args []string, s streams) error {
+	o := snapshotOptions{}
+	fs := newFlagSet("snapshot", s)
+	fs.StringVar(&o.in, "in", "", "etcd snapshot, or database of a stopped etcd member (member/snap/db)")
+	fs.StringVar(&o.outDir, "out-dir", "", "directory to write the decrypted values to")
+	fs.StringVar(&o.prefix, "prefix", "/registry/", "prefix of the etcd keys to decrypt")
+	fs.StringVar(&o.socket, "socket", "", "unix socket of a running plugin, the plugin is served in-process with the flags below if empty")
+	fs.StringVar(&o.key, "key", "", "AWS KMS key of the in-process plugin")
+	fs.StringVar(&o.encryptionCtx, "encryption-context", "", "AWS KMS encryption context of the in-process plugin (e.g. 'a=b,c=d')")
+	fs.StringVar(&o.region, "region", "", "AWS region of the in-process plugin, resolved like the plugin does if empty")
+	fs.StringVar(&o.kmsEndpoint, "kms-endpoint", "", "KMS endpoint of the in-process plugin")
+	fs.StringVar(&o.sourceArn, "source-arn", "", "AWS source ARN of the in-process plugin")
+	fs.DurationVar(&o.timeout, "timeout", 30*time.Second, "deadline of each call to the plugin")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	switch {
+	case o.in == "":
+		return usageErrorf("--in is required")
+	case o.outDir == "":
+		return usageErrorf("--out-dir is required")
+	case o.timeout <= 0:
+		return usageErrorf("--timeout must be positive")
+	}
+	encryptionCtx, err := plugin.ParseEncryptionContext(o.encryptionCtx)
+	if err != nil {
+		return usageErrorf("invalid --encryption-context: %v", err)
+	}
+
+	kvs, err := storage.ReadSnapshot(o.in, []byte(o.prefix))
+	if err != nil {
+		return err
+	}
+
+	socket := o.socket
+	if socket == "" {
+		c, err := newKMS(o.region, o.kmsEndpoint, 0, 0, 0, o.sourceArn)
+		if err != nil {
+			return err
+		}
+		addr, stop, err := servePlugins(o.key, c, encryptionCtx)
+		if err != nil {
+			return err
+		}
+		defer stop()
+		socket = addr
+	}
+	conn, err := connection.New(socket)
+	if err != nil {
+		return err
+	}
+	defer conn.Close() //nolint:errcheck
+	d := &snapshotDecrypter{keys: map[string][]byte{}, clients: map[string]client.Client{}}
+	for _, apiVersion := range []string{apiV1, apiV2} {
+		if d.clients[apiVersion], err = client.NewFromConn(ctx, conn, client.Options{APIVersion: apiVersion, Timeout: o.timeout}); err != nil {
+			return err
+		}
+	}
+	// fail early rather than once per value if the plugin cannot be reached
+	if _, err := d.clients[apiV1].Status(ctx); err != nil {
+		return err
+	}
+
+	var decrypted, skipped, failed int
+	for _, kv := range kvs {
+		v, err := storage.ParseValue(kv.Value)
+		if err == nil && v.APIVersion == storage.APIVersionNone {
+			skipped++
+			continue
+		}
+		if err == nil {
+			err = d.decrypt(ctx, kv.Key, v, o.outDir)
+		}
+		if err != nil {
+			fmt.Fprintf(s.err, "kmsctl snapshot: %s: %v\n", kv.Key, err)
+			failed++
+			continue
+		}
+		decrypted++
+	}
+	fmt.Fprintf(s.err, "kmsctl snapshot: %d values decrypted to %s, %d failed, %d not encrypted by a KMS provider\n", decrypted, o.outDir, failed, skipped)
+	if failed > 0 {
+		return fmt.Errorf("%d values failed to decrypt", failed)
+	}
+	return nil
+}
+
+type snapshotDecrypter struct {
+	clients map[string]client.Client
+	// keys are the decrypted keys by plugin ciphertext, v2 values share the
+	// seed of their DEK until kube-apiserver rotates it
+	keys map[string][]byte
+}
+
+// decrypt decrypts v stored at etcdKey, and writes it under dir
+func (d *snapshotDecrypter) decrypt(ctx context.Context, etcdKey []byte, v *storage.Value, dir string) error {
+	rel := strings.TrimPrefix(string(etcdKey), "/")
+	if !filepath.IsLocal(rel) {
+		return fmt.Errorf("key cannot be written under %s", dir)
+	}
+
+	cacheKey := v.APIVersion + ":" + string(v.PluginCiphertext)
+	key, ok := d.keys[cacheKey]
+	if !ok {
+		var err error
+		if v.APIVersion == storage.APIVersionV1 {
+			key, err = d.clients[apiV1].Decrypt(ctx, "", v.PluginCiphertext, "", nil)
+		} else {
+			key, err = d.clients[apiV2].Decrypt(ctx, client.NewUID(), v.PluginCiphertext, v.Object.KeyID, v.Object.Annotations)
+		}
+		if err != nil {
+			return fmt.Errorf("plugin failed to decrypt the %s key: %w", v.APIVersion, err)
+		}
+		d.keys[cacheKey] = key
+	}
+
+	plaintext, err := v.DecryptData(key, etcdKey)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, plaintext, 0600)
+}
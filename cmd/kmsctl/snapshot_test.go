@@ -0,0 +1,129 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/protobuf/encoding/protowire"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+	"sigs.k8s.io/aws-encryption-provider/pkg/storage"
+	"sigs.k8s.io/aws-encryption-provider/pkg/storage/storagetest"
+)
+
+// writeSnapshot writes values, each at its own revision, to an etcd database
+func writeSnapshot(t *testing.T, values [][2]string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "snapshot.db")
+	db, err := bolt.Open(path, 0600, nil)
+	require.NoError(t, err)
+	defer db.Close() //nolint:errcheck
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("key"))
+		if err != nil {
+			return err
+		}
+		for i, kv := range values {
+			rev := binary.BigEndian.AppendUint64(nil, uint64(i+1))
+			rev = binary.BigEndian.AppendUint64(append(rev, '_'), 0)
+			var v []byte
+			v = protowire.AppendTag(v, 1, protowire.BytesType)
+			v = protowire.AppendString(v, kv[0])
+			v = protowire.AppendTag(v, 5, protowire.BytesType)
+			v = protowire.AppendString(v, kv[1])
+			if err := b.Put(rev, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	return path
+}
+
+// v1Value returns a v1 value encrypted with dek, itself encrypted by KMS to kmsBlob
+func v1Value(t *testing.T, dek []byte, kmsBlob, plaintext, etcdKey string) string {
+	t.Helper()
+	pluginCiphertext := kmsplugin.StorageVersion + kmsBlob
+	value := binary.BigEndian.AppendUint16([]byte(storage.PrefixKMSv1+"aws:"), uint16(len(pluginCiphertext)))
+	value = append(value, pluginCiphertext...)
+	return string(append(value, storagetest.SealGCM(t, dek, []byte(plaintext), []byte(etcdKey))...))
+}
+
+// v2Value returns a v2 value encrypted with a key derived from seed, itself
+// encrypted by KMS to kmsBlob
+func v2Value(t *testing.T, seed []byte, kmsBlob, plaintext, etcdKey string) string {
+	t.Helper()
+	o := &storage.EncryptedObject{
+		EncryptedData:          storagetest.SealHKDF(t, seed, []byte(plaintext), []byte(etcdKey)),
+		KeyID:                  testKey,
+		EncryptedDEKSource:     []byte(string(kmsplugin.KMSStorageVersionV2) + kmsBlob),
+		EncryptedDEKSourceType: storage.HKDFSHA256XNonceAESGCMSeed,
+	}
+	return storage.PrefixKMSv2 + "aws:" + string(o.Marshal())
+}
+
+func TestSnapshot(t *testing.T) {
+	dek, seed := make([]byte, 32), make([]byte, 32)
+	_, _ = rand.Read(dek)
+	_, _ = rand.Read(seed)
+	c := &cloud.KMSMock{}
+	for blob, key := range map[string][]byte{"v1-dek": dek, "v2-seed": seed} {
+		c.AddDecryptRule(func(params *kms.DecryptInput) bool {
+			return string(params.CiphertextBlob) == blob
+		}, string(key), nil)
+	}
+	useMock(t, c)
+
+	path := writeSnapshot(t, [][2]string{
+		{"/registry/secrets/default/v1", v1Value(t, dek, "v1-dek", "k8s\x00v1 secret", "/registry/secrets/default/v1")},
+		{"/registry/secrets/default/v2", v2Value(t, seed, "v2-seed", "k8s\x00v2 secret", "/registry/secrets/default/v2")},
+		{"/registry/secrets/kube-system/v2", v2Value(t, seed, "v2-seed", "k8s\x00other v2 secret", "/registry/secrets/kube-system/v2")},
+		{"/registry/configmaps/default/plain", "k8s\x00configmap"},
+		// a value copied from another key does not authenticate
+		{"/registry/secrets/default/moved", v2Value(t, seed, "v2-seed", "k8s\x00moved", "/registry/secrets/default/v2")},
+	})
+
+	dir := t.TempDir()
+	code, _, stderr := runCommand("", "snapshot", "--in", path, "--out-dir", dir, "--key", testKey)
+	assert.Equal(t, exitFailure, code)
+	assert.Contains(t, stderr, "kmsctl snapshot: /registry/secrets/default/moved: cipher: message authentication failed")
+	assert.Contains(t, stderr, "3 values decrypted to "+dir+", 1 failed, 1 not encrypted by a KMS provider")
+	for key, want := range map[string]string{
+		"registry/secrets/default/v1":     "k8s\x00v1 secret",
+		"registry/secrets/default/v2":     "k8s\x00v2 secret",
+		"registry/secrets/kube-system/v2": "k8s\x00other v2 secret",
+	} {
+		got, err := os.ReadFile(filepath.Join(dir, key))
+		require.NoError(t, err)
+		assert.Equal(t, want, string(got))
+	}
+	assert.NoFileExists(t, filepath.Join(dir, "registry/configmaps/default/plain"))
+	assert.NoFileExists(t, filepath.Join(dir, "registry/secrets/default/moved"))
+
+	// through a running plugin
+	dir = t.TempDir()
+	code, _, stderr = runCommand("", "snapshot", "--in", path, "--out-dir", dir, "--socket", startPlugin(t, c), "--prefix", "/registry/secrets/kube-system/")
+	require.Equal(t, exitOK, code, stderr)
+	assert.Equal(t, "kmsctl snapshot: 1 values decrypted to "+dir+", 0 failed, 0 not encrypted by a KMS provider\n", stderr)
+	assert.FileExists(t, filepath.Join(dir, "registry/secrets/kube-system/v2"))
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// healthzOK is the healthz of a healthy v2 plugin
+const healthzOK = "ok"
+
+// runStatus prints the plugin version and, with the v2 API, its health and
+// key ID. It fails if the plugin reports it is unhealthy.
+func runStatus(ctx context.Context, args []string, s streams) error {
+	var (
+		conn   connectionOptions
+		output string
+	)
+	fs := newFlagSet("status", s)
+	conn.addFlags(fs)
+	fs.StringVar(&output, "output", "text", "format of the output: text or json")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if err := conn.validate(); err != nil {
+		return err
+	}
+	if output != "text" && output != formatJSON {
+		return usageErrorf("invalid --output %q, must be text or json", output)
+	}
+
+	c, err := conn.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close() //nolint:errcheck
+	res, err := c.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	if output == formatJSON {
+		enc := json.NewEncoder(s.out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(res); err != nil {
+			return err
+		}
+	} else {
+		fmt.Fprintf(s.out, "API version:     %s\n", res.APIVersion)
+		fmt.Fprintf(s.out, "Version:         %s\n", res.Version)
+		if res.RuntimeName != "" {
+			fmt.Fprintf(s.out, "Runtime name:    %s\n", res.RuntimeName)
+			fmt.Fprintf(s.out, "Runtime version: %s\n", res.RuntimeVersion)
+		}
+		if res.APIVersion == apiV2 {
+			fmt.Fprintf(s.out, "Healthz:         %s\n", res.Healthz)
+			fmt.Fprintf(s.out, "Key ID:          %s\n", res.KeyID)
+		}
+	}
+
+	if res.APIVersion == apiV2 && res.Healthz != healthzOK {
+		return fmt.Errorf("plugin is unhealthy: %s", res.Healthz)
+	}
+	return nil
+}
@@ -14,29 +14,42 @@ limitations under the License.
 package main
 
 import (
-	"encoding/csv"
+	"context"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	flag "github.com/spf13/pflag"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"sigs.k8s.io/aws-encryption-provider/pkg/audit"
 	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+	"sigs.k8s.io/aws-encryption-provider/pkg/debugserver"
 	"sigs.k8s.io/aws-encryption-provider/pkg/healthz"
+	"sigs.k8s.io/aws-encryption-provider/pkg/httpserver"
 	"sigs.k8s.io/aws-encryption-provider/pkg/livez"
 	"sigs.k8s.io/aws-encryption-provider/pkg/logging"
 	"sigs.k8s.io/aws-encryption-provider/pkg/plugin"
 	"sigs.k8s.io/aws-encryption-provider/pkg/server"
+	"sigs.k8s.io/aws-encryption-provider/pkg/tracing"
+	"sigs.k8s.io/aws-encryption-provider/pkg/version"
 )
 
 func main() {
 	var (
 		healthPort         = flag.String("health-port", ":8080", "port to serve /healthz and /livez")
+		metricsPort        = flag.String("metrics-port", "", "port to serve /metrics and debug endpoints (defaults to --health-port)")
+		tlsCertFile        = flag.String("tls-cert-file", "", "TLS certificate to serve health and metrics endpoints over HTTPS, reloaded when the file changes")
+		tlsKeyFile         = flag.String("tls-private-key-file", "", "TLS private key matching --tls-cert-file")
+		tlsClientCAFile    = flag.String("tls-client-ca-file", "", "CA bundle used to verify client certificates, which then authenticate requests to /metrics and debug endpoints")
+		authTokenFile      = flag.String("auth-bearer-token-file", "", "file containing a bearer token required to access /metrics and debug endpoints")
 		healthzPath        = flag.String("healthz-path", "/healthz", "deep health check path")
 		livezPath          = flag.String("livez-path", "/livez", "liveness/connectivity check path")
 		addrs              = flag.StringSlice("listen", []string{"/var/run/kmsplugin/socket.sock"}, "comma separated list of GRPC listen address")
@@ -49,10 +62,37 @@ func main() {
 		retryTokenCapacity = flag.Int("retry-token-capacity", 0, "number of tokens for client-side AWS rate-limiting on retries")
 		encryptionCtxsArr  = flag.StringArray("encryption-context", []string{}, "AWS KMS Encryption Context (e.g. 'a=b,c=d')")
 		sourceArn          = flag.String("source-arn", "", "AWS source ARN for confused deputy protection")
-		debug              = flag.Bool("debug", false, "Print debug level logs")
+		keyStatePeriod     = flag.Duration("key-state-check-period", plugin.DefaultKeyStateCheckPeriod, "interval between DescribeKey calls used to monitor the state of each key (0 to disable)")
+		keyValidation      = flag.String("key-validation", plugin.KeyValidationWarn, "validate at startup that each key is an enabled symmetric encryption key in the expected region and account. Valid options: fail (refuse to start), warn, none")
+		keyAccount         = flag.String("key-account", "", "AWS account ID expected to own each key, checked by --key-validation (defaults to the account of --source-arn)")
+		tracingEndpoint    = flag.String("tracing-endpoint", "", "OTLP/gRPC endpoint (host:port) to export traces to, OTEL_EXPORTER_OTLP_* environment variables are also honored")
+		tracingInsecure    = flag.Bool("tracing-insecure", false, "export traces without TLS")
+		tracingSampleRatio = flag.Float64("tracing-sample-ratio", tracing.DefaultSampleRatio, "fraction of traces to sample when the caller did not make a sampling decision")
+		auditLogPath       = flag.String("audit-log-path", "", "file to write an audit event to for every encrypt and decrypt operation (disabled if empty)")
+		auditLogMaxSize    = flag.Int("audit-log-max-size", audit.DefaultMaxSizeMB, "size in megabytes at which the audit log is rotated")
+		auditLogMaxBackups = flag.Int("audit-log-max-backups", audit.DefaultMaxBackups, "number of rotated audit logs to keep (0 to keep all)")
+		auditLogMaxAge     = flag.Int("audit-log-max-age", audit.DefaultMaxAgeDays, "number of days to keep rotated audit logs (0 to keep all)")
+		auditLogCompress   = flag.Bool("audit-log-compress", false, "gzip rotated audit logs")
+		logLevelStr        = flag.String("log-level", "info", "log level: debug, info, warn or error. It can be changed at runtime through /debug/loglevel")
+		logEncoding        = flag.String("log-encoding", "json", "log encoding: json or console")
+		logOutputs         = flag.StringSlice("log-output", []string{"stdout"}, "comma separated list of paths or URLs to write logs to")
+		logSamplingInitial = flag.Int("log-sampling-initial", 100, "number of identical log entries per second written before sampling starts")
+		logSamplingAfter   = flag.Int("log-sampling-thereafter", 100, "write every Nth identical log entry per second once sampling started (0 to disable sampling)")
+		logComponentLevels = flag.StringToString("log-component-level", nil, "comma separated levels of individual components, overriding --log-level (e.g. 'cloud=debug,health=warn'). Components: "+strings.Join(logging.Components, ", "))
+		logRedactContext   = flag.StringSlice("log-redact-encryption-context", []string{logging.RedactAllKeys}, "comma separated encryption context keys whose values are masked in logs, '*' for all or '' for none")
+		logRedactAccounts  = flag.Bool("log-redact-account-ids", false, "mask AWS account IDs in logs")
+		logRedactARNs      = flag.Bool("log-redact-arns", false, "mask the account and resource of ARNs in logs, keeping the service and region")
+		debugAddr          = flag.String("debug-addr", "", "address to serve pprof profiles, goroutine dumps and a redacted config dump on, without authentication (disabled if empty). Use localhost:port or unix:///path/to/socket")
+		debug              = flag.Bool("debug", false, "Print debug level logs, same as --log-level=debug")
+		printVersion       = flag.Bool("version", false, "print version information and exit")
 	)
 	flag.Parse()
 
+	if *printVersion {
+		fmt.Println(version.Get())
+		os.Exit(0)
+	}
+
 	encryptionCtxs := []map[string]string{}
 	for _, encryptionCtxStr := range *encryptionCtxsArr {
 		encryptionCtx, err := stringToStringConv(encryptionCtxStr)
@@ -68,21 +108,55 @@ func main() {
 		os.Exit(1)
 	}
 
-	logLevel := zapcore.InfoLevel
+	switch *keyValidation {
+	case plugin.KeyValidationFail, plugin.KeyValidationWarn, plugin.KeyValidationNone:
+	default:
+		fmt.Fprintf(os.Stderr, "invalid key-validation %q, valid options: fail, warn, none", *keyValidation)
+		os.Exit(1)
+	}
+
+	logOpts := logging.Options{
+		Encoding:           *logEncoding,
+		OutputPaths:        *logOutputs,
+		ErrorOutputPaths:   []string{"stderr"},
+		SamplingInitial:    *logSamplingInitial,
+		SamplingThereafter: *logSamplingAfter,
+		ComponentLevels:    map[string]zapcore.Level{},
+		Redaction: logging.RedactionPolicy{
+			EncryptionContextKeys: *logRedactContext,
+			AccountIDs:            *logRedactAccounts,
+			ARNs:                  *logRedactARNs,
+		},
+	}
+	if err := logOpts.Level.UnmarshalText([]byte(*logLevelStr)); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid log-level: %v", err)
+		os.Exit(1)
+	}
 	if *debug {
-		logLevel = zapcore.DebugLevel
+		logOpts.Level = zapcore.DebugLevel
+	}
+	for component, levelStr := range *logComponentLevels {
+		var level zapcore.Level
+		if err := level.UnmarshalText([]byte(levelStr)); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid log-component-level for %s: %v", component, err)
+			os.Exit(1)
+		}
+		logOpts.ComponentLevels[component] = level
 	}
 
-	l, err := logging.NewStandardLogger(logLevel)
+	l, logLevels, err := logging.New(logOpts)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to configure logging")
+		fmt.Fprintf(os.Stderr, "Failed to configure logging: %v", err)
 		os.Exit(1)
 	}
 
 	zap.ReplaceGlobals(l)
 
 	zap.L().Info("creating kms server",
+		zap.String("version", version.Version),
+		zap.String("commit", version.Commit),
 		zap.String("health-port", *healthPort),
+		zap.String("metrics-port", *metricsPort),
 		zap.String("healthz-path", *healthzPath),
 		zap.String("health-kms-version", *healthKms),
 		zap.String("livez-path", *livezPath),
@@ -92,23 +166,61 @@ func main() {
 		zap.Int("qps-limit", *qpsLimit),
 		zap.Int("burst-limit", *burstLimit),
 		zap.Int("retry-token-capacity", *retryTokenCapacity),
+		zap.Duration("key-state-check-period", *keyStatePeriod),
+		zap.String("key-validation", *keyValidation),
 	)
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Endpoint:    *tracingEndpoint,
+		Insecure:    *tracingInsecure,
+		SampleRatio: *tracingSampleRatio,
+	})
+	if err != nil {
+		zap.L().Fatal("Failed to configure tracing", zap.Error(err))
+	}
+
 	c, err := cloud.New(*region, *kmsEndpoint, *qpsLimit, *burstLimit, *retryTokenCapacity, *sourceArn)
 	if err != nil {
 		zap.L().Fatal("Failed to create new KMS service", zap.Error(err))
 	}
 
+	if *keyValidation != plugin.KeyValidationNone {
+		validateKeys(c, configuredKeys(*keys), *keyValidation, *keyAccount, *sourceArn)
+	}
+
 	for i, encryptionCtx := range encryptionCtxs {
-		for k, v := range encryptionCtx {
-			zap.L().Info("encryption-context", zap.Int("index", i), zap.String("key", k), zap.String(
-				"value", v))
+		zap.L().Info("encryption-context", zap.Int("index", i), logging.EncryptionContext("context", encryptionCtx))
+	}
+
+	metrics, err := plugin.NewMetrics(plugin.MetricsOpts{Registerer: prometheus.DefaultRegisterer})
+	if err != nil {
+		zap.L().Fatal("Failed to register metrics", zap.Error(err))
+	}
+
+	var auditLog *audit.Logger
+	if *auditLogPath != "" {
+		auditLog, err = audit.New(audit.Config{
+			Path:       *auditLogPath,
+			MaxSizeMB:  *auditLogMaxSize,
+			MaxBackups: *auditLogMaxBackups,
+			MaxAgeDays: *auditLogMaxAge,
+			Compress:   *auditLogCompress,
+		})
+		if err != nil {
+			zap.L().Fatal("Failed to create audit log", zap.Error(err))
 		}
+		zap.L().Info("writing audit log", zap.String("path", *auditLogPath))
 	}
 
 	sharedHealthCheck := plugin.NewSharedHealthCheck(plugin.DefaultHealthCheckPeriod, plugin.DefaultErrcBufSize)
 	go sharedHealthCheck.Start()
 	defer sharedHealthCheck.Stop()
 
+	if *keyStatePeriod > 0 {
+		keyStateWatcher := plugin.NewKeyStateWatcher(configuredKeys(*keys), c, *keyStatePeriod, metrics)
+		go keyStateWatcher.Start()
+		defer keyStateWatcher.Stop()
+	}
+
 	servers := []*server.Server{}
 	p1s := []*plugin.V1Plugin{}
 	p2s := []*plugin.V2Plugin{}
@@ -118,9 +230,9 @@ func main() {
 		servers = append(servers, s)
 		encryptionCtx := getOrDefault(encryptionCtxs, i, map[string]string{})
 
-		p := plugin.New(key, c, encryptionCtx, sharedHealthCheck)
+		p := plugin.New(key, c, encryptionCtx, sharedHealthCheck, metrics, auditLog)
 		p.Register(s.Server)
-		p2 := plugin.NewV2(key, c, encryptionCtx, sharedHealthCheck)
+		p2 := plugin.NewV2(key, c, encryptionCtx, sharedHealthCheck, metrics, auditLog)
 		p2.Register(s.Server)
 		if *healthKms == "v1" {
 			p1s = append(p1s, p)
@@ -130,15 +242,80 @@ func main() {
 		}
 	}
 
-	go func() {
-		http.Handle(*healthzPath, healthz.NewHandler(p1s, p2s))
-		http.Handle(*livezPath, livez.NewHandler(p1s, p2s))
-		http.Handle("/metrics", promhttp.Handler())
-		if err := http.ListenAndServe(*healthPort, nil); err != nil {
-			zap.L().Fatal("Failed to start healthcheck server", zap.Error(err))
+	auth := httpserver.NewAuthenticator(*authTokenFile, *tlsClientCAFile != "")
+	if auth.Enabled() && *tlsCertFile == "" {
+		zap.L().Warn("authentication is enabled without TLS, bearer tokens are sent in clear text")
+	}
+
+	healthMux := http.NewServeMux()
+	healthMux.Handle(*healthzPath, healthz.NewHandler(p1s, p2s))
+	healthMux.Handle(*livezPath, livez.NewHandler(p1s, p2s))
+	metricsMux := healthMux
+	if *metricsPort != "" && *metricsPort != *healthPort {
+		metricsMux = http.NewServeMux()
+	}
+	// OpenMetrics is negotiated with scrapers that support it, exposing the exemplars of KMS latency histograms
+	metricsHandler := promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}))
+	metricsMux.Handle("/metrics", auth.Wrap(metricsHandler))
+	metricsMux.Handle("/version", auth.Wrap(version.Handler()))
+	debugHandlers := map[string]http.Handler{
+		"/debug/loglevel": logLevels,
+		"/debug/usage":    metrics.UsageHandler(),
+	}
+	registerDebugHandlers(metricsMux, auth, debugHandlers)
+
+	type httpListener struct {
+		addr string
+		mux  *http.ServeMux
+	}
+	httpListeners := []httpListener{{addr: *healthPort, mux: healthMux}}
+	if metricsMux != healthMux {
+		httpListeners = append(httpListeners, httpListener{addr: *metricsPort, mux: metricsMux})
+	}
+	httpServers := []*httpserver.Server{}
+	for _, hs := range httpListeners {
+		srv, err := httpserver.New(httpserver.Config{
+			Addr:         hs.addr,
+			TLSCertFile:  *tlsCertFile,
+			TLSKeyFile:   *tlsKeyFile,
+			ClientCAFile: *tlsClientCAFile,
+		}, hs.mux)
+		if err != nil {
+			zap.L().Fatal("Failed to create http server", zap.Error(err))
+		}
+		httpServers = append(httpServers, srv)
+		go func() {
+			if err := srv.ListenAndServe(); err != nil {
+				zap.L().Fatal("Failed to start http server", zap.Error(err))
+			}
+		}()
+		zap.L().Info("HTTP server started", zap.String("port", hs.addr), zap.Bool("tls", *tlsCertFile != ""))
+	}
+
+	if *debugAddr != "" {
+		debugMux := debugserver.NewHandler(flag.CommandLine)
+		for path, h := range debugHandlers {
+			debugMux.Handle(path, h)
 		}
-	}()
-	zap.L().Info("Healthchecks server started", zap.String("port", *healthPort))
+		srv, err := httpserver.New(httpserver.Config{Addr: *debugAddr}, debugMux)
+		if err != nil {
+			zap.L().Fatal("Failed to create debug server", zap.Error(err))
+		}
+		// CPU profiles and execution traces stream for as long as requested
+		srv.WriteTimeout = 0
+		l, err := debugserver.Listen(*debugAddr)
+		if err != nil {
+			zap.L().Fatal("Failed to start debug server", zap.Error(err))
+		}
+		httpServers = append(httpServers, srv)
+		go func() {
+			if err := srv.Serve(l); err != nil {
+				zap.L().Fatal("Failed to start debug server", zap.Error(err))
+			}
+		}()
+		zap.L().Info("Debug server started", zap.String("address", *debugAddr))
+	}
 
 	for i, addr := range *addrs {
 		s := servers[i]
@@ -162,10 +339,83 @@ func main() {
 	for _, s := range servers {
 		s.GracefulStop()
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), httpserver.DefaultShutdownTimeout)
+	defer cancel()
+	for _, srv := range httpServers {
+		if err := srv.Shutdown(ctx); err != nil {
+			zap.L().Warn("Failed to shut down http server", zap.Error(err))
+		}
+	}
+	if err := auditLog.Close(); err != nil {
+		zap.L().Warn("Failed to close audit log", zap.Error(err))
+	}
+	if err := shutdownTracing(ctx); err != nil {
+		zap.L().Warn("Failed to flush traces", zap.Error(err))
+	}
 	zap.L().Info("Exiting...")
 	os.Exit(0)
 }
 
+// registerDebugHandlers serves handlers on mux only if requests to it are
+// authenticated. mux may be the one of the kubelet probes, reachable by anyone
+// who can reach the probe port, so without authentication the handlers are
+// only served by the --debug-addr server.
+func registerDebugHandlers(mux *http.ServeMux, auth *httpserver.Authenticator, handlers map[string]http.Handler) {
+	if !auth.Enabled() {
+		zap.L().Info("debug endpoints are only served on --debug-addr, as authentication is disabled")
+		return
+	}
+	for path, h := range handlers {
+		mux.Handle(path, auth.Wrap(h))
+	}
+}
+
+// validateKeys checks every configured key with DescribeKey and reports each
+// result, exiting when mode is "fail" and any key is unsuitable.
+func validateKeys(c cloud.AWSKMSv2, keys []string, mode, account, sourceArn string) {
+	expect := plugin.KeyExpectations{
+		Region:  cloud.Region(c),
+		Account: account,
+	}
+	if expect.Account == "" && sourceArn != "" {
+		// the source ARN was already validated when creating the KMS client
+		expect.Account, _ = cloud.SourceAccount(sourceArn)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	failed := false
+	for _, v := range plugin.ValidateKeys(ctx, c, keys, expect) {
+		if v.OK() {
+			zap.L().Info("key validation passed", zap.String("key", v.KeyID), zap.String("arn", aws.ToString(v.Metadata.Arn)))
+			continue
+		}
+		failed = true
+		zap.L().Warn("key validation failed",
+			zap.String("key", v.KeyID),
+			zap.Strings("problems", v.Problems),
+			zap.Error(v.Err),
+			zap.String("report", v.String()),
+		)
+	}
+	if failed && mode == plugin.KeyValidationFail {
+		zap.L().Fatal("refusing to start with unsuitable keys, set --key-validation=warn to start anyway")
+	}
+}
+
+// configuredKeys returns the keys that are set, the plugin is started with an
+// empty --key by default
+func configuredKeys(keys []string) []string {
+	configured := []string{}
+	for _, key := range keys {
+		if key != "" {
+			configured = append(configured, key)
+		}
+	}
+	return configured
+}
+
 // get index in array or return default value if out of index
 func getOrDefault[T any](arr []T, index int, defaultVal T) T {
 	if index >= len(arr) || index < 0 {
@@ -177,23 +427,5 @@ func getOrDefault[T any](arr []T, index int, defaultVal T) T {
 // parses string into map[string]string
 // based on plog's stringToString implementation
 func stringToStringConv(val string) (interface{}, error) {
-	val = strings.Trim(val, "[]")
-	// An empty string would cause an empty map
-	if len(val) == 0 {
-		return map[string]string{}, nil
-	}
-	r := csv.NewReader(strings.NewReader(val))
-	ss, err := r.Read()
-	if err != nil {
-		return nil, err
-	}
-	out := make(map[string]string, len(ss))
-	for _, pair := range ss {
-		kv := strings.SplitN(pair, "=", 2)
-		if len(kv) != 2 {
-			return nil, fmt.Errorf("%s must be formatted as key=value", pair)
-		}
-		out[kv[0]] = kv[1]
-	}
-	return out, nil
+	return plugin.ParseEncryptionContext(val)
 }
@@ -14,9 +14,16 @@ limitations under the License.
 package main
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/aws-encryption-provider/pkg/httpserver"
 )
 
 func TestGetOrDefault(t *testing.T) {
@@ -71,6 +78,11 @@ func TestGetOrDefault(t *testing.T) {
 	}
 }
 
+func TestConfiguredKeys(t *testing.T) {
+	assert.Empty(t, configuredKeys([]string{""}))
+	assert.Equal(t, []string{"a", "b"}, configuredKeys([]string{"a", "", "b"}))
+}
+
 func TestStringToStringConv(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -106,3 +118,38 @@ func TestStringToStringConv(t *testing.T) {
 		})
 	}
 }
+
+func TestRegisterDebugHandlers(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("secret\n"), 0600))
+	handlers := map[string]http.Handler{
+		"/debug/loglevel": http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) { rw.WriteHeader(http.StatusNoContent) }),
+	}
+
+	tests := []struct {
+		name     string
+		auth     *httpserver.Authenticator
+		token    string
+		expected int
+	}{
+		// with the default flags, the mux is the one of the kubelet probes
+		{name: "authentication disabled", auth: httpserver.NewAuthenticator("", false), expected: http.StatusNotFound},
+		{name: "unauthenticated", auth: httpserver.NewAuthenticator(tokenFile, false), expected: http.StatusUnauthorized},
+		{name: "wrong token", auth: httpserver.NewAuthenticator(tokenFile, false), token: "other", expected: http.StatusUnauthorized},
+		{name: "authenticated", auth: httpserver.NewAuthenticator(tokenFile, false), token: "secret", expected: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			registerDebugHandlers(mux, tt.auth, handlers)
+
+			req := httptest.NewRequest(http.MethodPut, "/debug/loglevel?level=debug", strings.NewReader(`{"level":"debug"}`))
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rw := httptest.NewRecorder()
+			mux.ServeHTTP(rw, req)
+			assert.Equal(t, tt.expected, rw.Code)
+		})
+	}
+}
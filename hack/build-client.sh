@@ -4,5 +4,5 @@ set -euo pipefail
 source hack/setup-go.sh
 
 go version
-go build -ldflags "-w -s" -o bin/grpcclient cmd/client/main.go
-go build -ldflags "-w -s" -o bin/grpcclientv2 cmd/clientv2/main.go
+go build -ldflags "-w -s" -o bin/grpcclient ./cmd/client
+go build -ldflags "-w -s" -o bin/kmsctl ./cmd/kmsctl
@@ -3,7 +3,10 @@ set -euo pipefail
 
 source hack/setup-go.sh
 
+COMMIT=${COMMIT:-$(git rev-parse --short HEAD 2>/dev/null || echo UNKNOWN)}
+DATE=${DATE:-$(date -u +%Y-%m-%dT%H:%M:%SZ)}
+
 go version
 go build -ldflags \
-		"-w -s -X sigs.k8s.io/aws-encryption-provider/pkg/version.Version=${TAG}" \
+		"-w -s -X sigs.k8s.io/aws-encryption-provider/pkg/version.Version=${TAG} -X sigs.k8s.io/aws-encryption-provider/pkg/version.Commit=${COMMIT} -X sigs.k8s.io/aws-encryption-provider/pkg/version.Date=${DATE}" \
 		-o bin/aws-encryption-provider cmd/server/main.go
@@ -0,0 +1,184 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package audit writes one structured event for every encrypt and decrypt
+// operation served by the plugin, recording which key was used, by whom and
+// with what outcome. Events never contain plaintext, ciphertext or the values
+// of the encryption context.
+package audit
+
+import (
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const (
+	DefaultMaxSizeMB  = 100
+	DefaultMaxBackups = 10
+	DefaultMaxAgeDays = 30
+)
+
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Sources of operations
+const (
+	// SourceCaller are operations requested over the socket, by kube-apiserver
+	SourceCaller = "caller"
+	// SourceHealthCheck are operations the plugin made to check its health
+	SourceHealthCheck = "health_check"
+)
+
+// Config configures an audit log written to a file that is rotated once it
+// reaches MaxSizeMB.
+type Config struct {
+	Path       string
+	MaxSizeMB  int
+	MaxBackups int
+	MaxAgeDays int
+	Compress   bool
+}
+
+// Peer identifies the process that called the plugin over its unix socket
+type Peer struct {
+	PID int32
+	UID uint32
+	GID uint32
+}
+
+// Event is a single encrypt or decrypt operation
+type Event struct {
+	Time       time.Time
+	APIVersion string
+	Operation  string
+	// Source tells operations requested by callers apart from health checks
+	Source     string
+	RequestUID string
+	KeyID      string
+	// KeyARN is the ARN of the key KMS used, as returned by KMS
+	KeyARN string
+	// EncryptionContextKeys are the keys of the encryption context, values may be sensitive
+	EncryptionContextKeys []string
+	Peer                  *Peer
+	Outcome               string
+	ErrorType             string
+	ErrorCode             string
+	KMSRequestID          string
+	Latency               time.Duration
+}
+
+// MarshalLogObject implements zapcore.ObjectMarshaler
+func (e Event) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddTime("time", e.Time)
+	enc.AddString("apiVersion", e.APIVersion)
+	enc.AddString("operation", e.Operation)
+	if e.Source != "" {
+		enc.AddString("source", e.Source)
+	}
+	if e.RequestUID != "" {
+		enc.AddString("requestUID", e.RequestUID)
+	}
+	enc.AddString("keyID", e.KeyID)
+	if e.KeyARN != "" {
+		enc.AddString("keyARN", e.KeyARN)
+	}
+	if len(e.EncryptionContextKeys) > 0 {
+		if err := enc.AddArray("encryptionContextKeys", zapcore.ArrayMarshalerFunc(func(arr zapcore.ArrayEncoder) error {
+			for _, k := range e.EncryptionContextKeys {
+				arr.AppendString(k)
+			}
+			return nil
+		})); err != nil {
+			return err
+		}
+	}
+	if e.Peer != nil {
+		if err := enc.AddObject("peer", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+			enc.AddInt32("pid", e.Peer.PID)
+			enc.AddUint32("uid", e.Peer.UID)
+			enc.AddUint32("gid", e.Peer.GID)
+			return nil
+		})); err != nil {
+			return err
+		}
+	}
+	enc.AddString("outcome", e.Outcome)
+	if e.ErrorType != "" {
+		enc.AddString("errorType", e.ErrorType)
+	}
+	if e.ErrorCode != "" {
+		enc.AddString("errorCode", e.ErrorCode)
+	}
+	if e.KMSRequestID != "" {
+		enc.AddString("kmsRequestID", e.KMSRequestID)
+	}
+	enc.AddFloat64("latencySeconds", e.Latency.Seconds())
+	return nil
+}
+
+// Logger writes audit events. A nil *Logger discards every event, so plugins
+// can log unconditionally.
+type Logger struct {
+	logger *zap.Logger
+	closer func() error
+}
+
+// New returns a *Logger writing JSON lines to the file at cfg.Path
+func New(cfg Config) (*Logger, error) {
+	if cfg.Path == "" {
+		return nil, errors.New("audit log path is required")
+	}
+	w := &lumberjack.Logger{
+		Filename:   cfg.Path,
+		MaxSize:    cfg.MaxSizeMB,
+		MaxBackups: cfg.MaxBackups,
+		MaxAge:     cfg.MaxAgeDays,
+		Compress:   cfg.Compress,
+	}
+	encoder := zapcore.NewJSONEncoder(zapcore.EncoderConfig{
+		EncodeTime:     zapcore.RFC3339NanoTimeEncoder,
+		EncodeDuration: zapcore.SecondsDurationEncoder,
+	})
+	l := NewWithCore(zapcore.NewCore(encoder, zapcore.AddSync(w), zapcore.InfoLevel))
+	l.closer = w.Close
+	return l, nil
+}
+
+// NewWithCore returns a *Logger writing events to core, e.g. to send them to
+// the same destination as other logs.
+func NewWithCore(core zapcore.Core) *Logger {
+	return &Logger{logger: zap.New(core), closer: func() error { return nil }}
+}
+
+// Log writes e. Audit events are never sampled or dropped.
+func (l *Logger) Log(e Event) {
+	if l == nil {
+		return
+	}
+	l.logger.Info("", zap.Inline(e))
+}
+
+// Close flushes buffered events and closes the underlying file
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	_ = l.logger.Sync()
+	return l.closer()
+}
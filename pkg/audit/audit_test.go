@@ -0,0 +1,98 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogWritesJSONLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := New(Config{Path: path, MaxSizeMB: 1})
+	require.NoError(t, err)
+
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	l.Log(Event{
+		Time:                  ts,
+		APIVersion:            "v2",
+		Operation:             "decrypt",
+		Source:                SourceCaller,
+		RequestUID:            "uid-1",
+		KeyID:                 "alias/test",
+		KeyARN:                "arn:aws:kms:us-west-2:111122223333:key/test",
+		EncryptionContextKeys: []string{"cluster"},
+		Peer:                  &Peer{PID: 42, UID: 1000, GID: 1000},
+		Outcome:               OutcomeFailure,
+		ErrorType:             "user-induced",
+		ErrorCode:             "DisabledException",
+		KMSRequestID:          "req-1",
+		Latency:               1500 * time.Millisecond,
+	})
+	l.Log(Event{Time: ts, APIVersion: "v1", Operation: "encrypt", KeyID: "alias/test", Outcome: OutcomeSuccess})
+	require.NoError(t, l.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+
+	var got map[string]any
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &got))
+	assert.Equal(t, map[string]any{
+		"time":                  "2024-01-02T03:04:05Z",
+		"apiVersion":            "v2",
+		"operation":             "decrypt",
+		"source":                "caller",
+		"requestUID":            "uid-1",
+		"keyID":                 "alias/test",
+		"keyARN":                "arn:aws:kms:us-west-2:111122223333:key/test",
+		"encryptionContextKeys": []any{"cluster"},
+		"peer":                  map[string]any{"pid": 42.0, "uid": 1000.0, "gid": 1000.0},
+		"outcome":               "failure",
+		"errorType":             "user-induced",
+		"errorCode":             "DisabledException",
+		"kmsRequestID":          "req-1",
+		"latencySeconds":        1.5,
+	}, got)
+
+	got = nil
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &got))
+	assert.Equal(t, map[string]any{
+		"time":           "2024-01-02T03:04:05Z",
+		"apiVersion":     "v1",
+		"operation":      "encrypt",
+		"keyID":          "alias/test",
+		"outcome":        "success",
+		"latencySeconds": 0.0,
+	}, got)
+}
+
+func TestNilLogger(t *testing.T) {
+	var l *Logger
+	l.Log(Event{Operation: "encrypt"})
+	assert.NoError(t, l.Close())
+}
+
+func TestNewRequiresPath(t *testing.T) {
+	_, err := New(Config{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,272 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package client calls an aws-encryption-provider plugin over its unix
+// socket with the v1 or v2 KMS API, as kube-apiserver does.
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	pb1 "k8s.io/kms/apis/v1beta1"
+	pb "k8s.io/kms/apis/v2"
+	"sigs.k8s.io/aws-encryption-provider/pkg/connection"
+)
+
+// KMS API versions
+const (
+	APIVersionV1 = "v1"
+	APIVersionV2 = "v2"
+)
+
+// DefaultRetryBackoff is the wait before the first retry of a call
+const DefaultRetryBackoff = 100 * time.Millisecond
+
+// Options configure a Client
+type Options struct {
+	// APIVersion is the KMS API version to call the plugin with. If empty, it
+	// is negotiated: v2 if the plugin serves it, v1 otherwise.
+	APIVersion string
+	// Timeout bounds each call, retries included, if positive
+	Timeout time.Duration
+	// Retries is the number of times a call failing with Unavailable is retried
+	Retries int
+	// RetryBackoff is the wait before the first retry, doubled after each
+	// retry. Defaults to DefaultRetryBackoff.
+	RetryBackoff time.Duration
+}
+
+// EncryptResponse is what the plugin returns for an encryption, KeyID and
+// Annotations are only set by the v2 API
+type EncryptResponse struct {
+	Ciphertext  []byte
+	KeyID       string
+	Annotations map[string][]byte
+}
+
+// StatusResponse is the plugin version and, with the v2 API, its health
+type StatusResponse struct {
+	APIVersion     string `json:"apiVersion"`
+	Version        string `json:"version"`
+	RuntimeName    string `json:"runtimeName,omitempty"`
+	RuntimeVersion string `json:"runtimeVersion,omitempty"`
+	Healthz        string `json:"healthz,omitempty"`
+	KeyID          string `json:"keyID,omitempty"`
+}
+
+// Client calls a plugin with either KMS API version. Calls wait for the
+// plugin to be ready until their deadline, as a plugin that was just started
+// may not listen yet.
+type Client interface {
+	// APIVersion is the KMS API version the plugin is called with
+	APIVersion() string
+	// Encrypt encrypts plaintext, uid is ignored by the v1 API
+	Encrypt(ctx context.Context, uid string, plaintext []byte) (*EncryptResponse, error)
+	// Decrypt decrypts ciphertext, uid, keyID and annotations are ignored by
+	// the v1 API
+	Decrypt(ctx context.Context, uid string, ciphertext []byte, keyID string, annotations map[string][]byte) ([]byte, error)
+	// Status calls Version with the v1 API and Status with the v2 API
+	Status(ctx context.Context) (*StatusResponse, error)
+	// Close closes the connection created by New
+	Close() error
+}
+
+// NewUID returns a random UUID to send with a v2 request, like the one
+// kube-apiserver sends with every request so that it can be correlated with
+// the plugin logs
+func NewUID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// New connects to the plugin listening on the unix socket at addr. The API
+// version is negotiated within ctx if opts has none.
+func New(ctx context.Context, addr string, opts Options) (Client, error) {
+	conn, err := connection.New(addr)
+	if err != nil {
+		return nil, err
+	}
+	c, err := newClient(ctx, conn, opts)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	c.close = conn.Close
+	return c, nil
+}
+
+// NewFromConn returns a client calling the plugin over conn, which is left
+// open by Close. The API version is negotiated within ctx if opts has none.
+func NewFromConn(ctx context.Context, conn grpc.ClientConnInterface, opts Options) (Client, error) {
+	return newClient(ctx, conn, opts)
+}
+
+func newClient(ctx context.Context, conn grpc.ClientConnInterface, opts Options) (*client, error) {
+	if opts.RetryBackoff <= 0 {
+		opts.RetryBackoff = DefaultRetryBackoff
+	}
+	c := &client{
+		opts:       opts,
+		apiVersion: opts.APIVersion,
+		v1:         pb1.NewKeyManagementServiceClient(conn),
+		v2:         pb.NewKeyManagementServiceClient(conn),
+		close:      func() error { return nil },
+	}
+	switch opts.APIVersion {
+	case APIVersionV1, APIVersionV2:
+		return c, nil
+	case "":
+		if err := c.negotiate(ctx); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+	return nil, fmt.Errorf("invalid API version %q, must be %s or %s", opts.APIVersion, APIVersionV1, APIVersionV2)
+}
+
+type client struct {
+	opts       Options
+	apiVersion string
+	v1         pb1.KeyManagementServiceClient
+	v2         pb.KeyManagementServiceClient
+	close      func() error
+}
+
+// callOptions makes calls wait for the plugin to come up until their deadline
+var callOptions = []grpc.CallOption{grpc.WaitForReady(true)}
+
+// negotiate picks v2 if the plugin serves it, v1 otherwise
+func (c *client) negotiate(ctx context.Context) error {
+	c.apiVersion = APIVersionV2
+	_, err := c.Status(ctx)
+	if status.Code(err) == codes.Unimplemented {
+		c.apiVersion = APIVersionV1
+		_, err = c.Status(ctx)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to negotiate the KMS API version: %w", err)
+	}
+	return nil
+}
+
+// call calls f within the timeout, retrying while it fails with Unavailable
+func (c *client) call(ctx context.Context, f func(ctx context.Context) error) error {
+	if c.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.opts.Timeout)
+		defer cancel()
+	}
+	backoff := c.opts.RetryBackoff
+	for retry := 0; ; retry++ {
+		err := f(ctx)
+		if err == nil || retry >= c.opts.Retries || status.Code(err) != codes.Unavailable {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (c *client) APIVersion() string {
+	return c.apiVersion
+}
+
+func (c *client) Encrypt(ctx context.Context, uid string, plaintext []byte) (*EncryptResponse, error) {
+	var res *EncryptResponse
+	err := c.call(ctx, func(ctx context.Context) error {
+		if c.apiVersion == APIVersionV1 {
+			resp, err := c.v1.Encrypt(ctx, &pb1.EncryptRequest{Version: "v1beta1", Plain: plaintext}, callOptions...)
+			if err != nil {
+				return err
+			}
+			res = &EncryptResponse{Ciphertext: resp.Cipher}
+			return nil
+		}
+		resp, err := c.v2.Encrypt(ctx, &pb.EncryptRequest{Uid: uid, Plaintext: plaintext}, callOptions...)
+		if err != nil {
+			return err
+		}
+		res = &EncryptResponse{Ciphertext: resp.Ciphertext, KeyID: resp.KeyId, Annotations: resp.Annotations}
+		return nil
+	})
+	return res, err
+}
+
+func (c *client) Decrypt(ctx context.Context, uid string, ciphertext []byte, keyID string, annotations map[string][]byte) ([]byte, error) {
+	var plaintext []byte
+	err := c.call(ctx, func(ctx context.Context) error {
+		if c.apiVersion == APIVersionV1 {
+			resp, err := c.v1.Decrypt(ctx, &pb1.DecryptRequest{Version: "v1beta1", Cipher: ciphertext}, callOptions...)
+			if err != nil {
+				return err
+			}
+			plaintext = resp.Plain
+			return nil
+		}
+		resp, err := c.v2.Decrypt(ctx, &pb.DecryptRequest{Uid: uid, Ciphertext: ciphertext, KeyId: keyID, Annotations: annotations}, callOptions...)
+		if err != nil {
+			return err
+		}
+		plaintext = resp.Plaintext
+		return nil
+	})
+	return plaintext, err
+}
+
+func (c *client) Status(ctx context.Context) (*StatusResponse, error) {
+	var res *StatusResponse
+	err := c.call(ctx, func(ctx context.Context) error {
+		if c.apiVersion == APIVersionV1 {
+			resp, err := c.v1.Version(ctx, &pb1.VersionRequest{Version: "v1beta1"}, callOptions...)
+			if err != nil {
+				return err
+			}
+			res = &StatusResponse{
+				APIVersion:     APIVersionV1,
+				Version:        resp.Version,
+				RuntimeName:    resp.RuntimeName,
+				RuntimeVersion: resp.RuntimeVersion,
+			}
+			return nil
+		}
+		resp, err := c.v2.Status(ctx, &pb.StatusRequest{}, callOptions...)
+		if err != nil {
+			return err
+		}
+		res = &StatusResponse{
+			APIVersion: APIVersionV2,
+			Version:    resp.Version,
+			Healthz:    resp.Healthz,
+			KeyID:      resp.KeyId,
+		}
+		return nil
+	})
+	return res, err
+}
+
+func (c *client) Close() error {
+	return c.close()
+}
@@ -0,0 +1,189 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+	"sigs.k8s.io/aws-encryption-provider/pkg/plugin"
+)
+
+const (
+	testKey        = "arn:aws:kms:us-west-2:111122223333:key/client"
+	testPlaintext  = "hello client"
+	testCiphertext = "kms-ciphertext"
+)
+
+// testPlugin serves the v1 plugin, and the v2 plugin if v2 is set, backed by
+// a KMS mock
+type testPlugin struct {
+	*plugin.LocalServer
+	kms *cloud.KMSMock
+	// unavailable is the number of calls to fail with Unavailable
+	unavailable atomic.Int32
+	calls       atomic.Int32
+}
+
+func newTestPlugin(t *testing.T, v2 bool) *testPlugin {
+	t.Helper()
+	p := &testPlugin{kms: &cloud.KMSMock{}}
+	p.kms.SetEncryptResp(testCiphertext, nil)
+	p.kms.SetDecryptResp(testPlaintext, nil)
+
+	var err error
+	p.LocalServer, err = plugin.NewLocalServer(testKey, p.kms, plugin.LocalServerOptions{
+		V1Only: !v2,
+		Interceptor: func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			p.calls.Add(1)
+			if p.unavailable.Add(-1) >= 0 {
+				return nil, status.Error(codes.Unavailable, "not ready")
+			}
+			return handler(ctx, req)
+		},
+	})
+	require.NoError(t, err)
+	t.Cleanup(p.Stop)
+	return p
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	p := newTestPlugin(t, true)
+	require.NoError(t, p.Start())
+
+	for _, apiVersion := range []string{APIVersionV1, APIVersionV2} {
+		t.Run(apiVersion, func(t *testing.T) {
+			c, err := New(context.Background(), p.Addr, Options{APIVersion: apiVersion, Timeout: 5 * time.Second})
+			require.NoError(t, err)
+			defer c.Close() //nolint:errcheck
+			assert.Equal(t, apiVersion, c.APIVersion())
+
+			enc, err := c.Encrypt(context.Background(), "uid", []byte(testPlaintext))
+			require.NoError(t, err)
+			assert.Equal(t, kmsplugin.StorageVersion+testCiphertext, string(enc.Ciphertext))
+			if apiVersion == APIVersionV2 {
+				assert.Equal(t, testKey, enc.KeyID)
+			} else {
+				assert.Empty(t, enc.KeyID)
+			}
+
+			plaintext, err := c.Decrypt(context.Background(), "uid", enc.Ciphertext, enc.KeyID, enc.Annotations)
+			require.NoError(t, err)
+			assert.Equal(t, testPlaintext, string(plaintext))
+
+			st, err := c.Status(context.Background())
+			require.NoError(t, err)
+			assert.Equal(t, apiVersion, st.APIVersion)
+			if apiVersion == APIVersionV2 {
+				assert.Equal(t, &StatusResponse{APIVersion: APIVersionV2, Version: "v2beta1", Healthz: "ok", KeyID: testKey}, st)
+			} else {
+				assert.Equal(t, "v1beta1", st.Version)
+			}
+		})
+	}
+}
+
+func TestNegotiation(t *testing.T) {
+	tt := []struct {
+		name string
+		v2   bool
+		want string
+	}{
+		{name: "v1 and v2", v2: true, want: APIVersionV2},
+		{name: "v1 only", v2: false, want: APIVersionV1},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newTestPlugin(t, tc.v2)
+			require.NoError(t, p.Start())
+			c, err := New(context.Background(), p.Addr, Options{Timeout: 5 * time.Second})
+			require.NoError(t, err)
+			defer c.Close() //nolint:errcheck
+			assert.Equal(t, tc.want, c.APIVersion())
+		})
+	}
+
+	_, err := New(context.Background(), "unused", Options{APIVersion: "v3"})
+	assert.ErrorContains(t, err, `invalid API version "v3"`)
+}
+
+func TestWaitForReady(t *testing.T) {
+	p := newTestPlugin(t, true)
+	c, err := New(context.Background(), p.Addr, Options{APIVersion: APIVersionV2, Timeout: 5 * time.Second})
+	require.NoError(t, err)
+	defer c.Close() //nolint:errcheck
+
+	// the plugin starts listening after the call is made
+	time.AfterFunc(200*time.Millisecond, func() { assert.NoError(t, p.Start()) })
+	st, err := c.Status(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "ok", st.Healthz)
+}
+
+func TestRetries(t *testing.T) {
+	p := newTestPlugin(t, true)
+	require.NoError(t, p.Start())
+
+	c, err := New(context.Background(), p.Addr, Options{APIVersion: APIVersionV2, Timeout: 5 * time.Second, Retries: 2, RetryBackoff: time.Millisecond})
+	require.NoError(t, err)
+	defer c.Close() //nolint:errcheck
+
+	p.unavailable.Store(2)
+	_, err = c.Encrypt(context.Background(), "uid", []byte(testPlaintext))
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), p.calls.Load())
+
+	p.calls.Store(0)
+	p.unavailable.Store(3)
+	_, err = c.Encrypt(context.Background(), "uid", []byte(testPlaintext))
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Equal(t, int32(3), p.calls.Load())
+
+	// other errors are not retried
+	p.calls.Store(0)
+	p.unavailable.Store(0)
+	_, err = c.Decrypt(context.Background(), "uid", []byte("9invalid"), testKey, nil)
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), p.calls.Load())
+}
+
+func TestTimeout(t *testing.T) {
+	p := newTestPlugin(t, true)
+	p.kms.SetEncryptDelay(time.Second)
+	require.NoError(t, p.Start())
+
+	c, err := New(context.Background(), p.Addr, Options{APIVersion: APIVersionV2, Timeout: 100 * time.Millisecond, Retries: 3})
+	require.NoError(t, err)
+	defer c.Close() //nolint:errcheck
+
+	start := time.Now()
+	_, err = c.Encrypt(context.Background(), "uid", []byte(testPlaintext))
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestNewUID(t *testing.T) {
+	uid := NewUID()
+	assert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, uid)
+	assert.NotEqual(t, uid, NewUID())
+}
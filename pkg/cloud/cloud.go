@@ -15,18 +15,24 @@ package cloud
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync/atomic"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
 	"github.com/aws/aws-sdk-go-v2/aws/ratelimit"
 	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
 	"github.com/aws/aws-sdk-go-v2/service/kms"
 	smithymiddleware "github.com/aws/smithy-go/middleware"
 	smithyhttp "github.com/aws/smithy-go/transport/http"
 	"go.uber.org/zap"
+	"sigs.k8s.io/aws-encryption-provider/pkg/logging"
+	"sigs.k8s.io/aws-encryption-provider/pkg/tracing"
 )
 
 const (
@@ -37,6 +43,9 @@ const (
 type AWSKMSv2 interface {
 	Encrypt(ctx context.Context, params *kms.EncryptInput, optFns ...func(*kms.Options)) (*kms.EncryptOutput, error)
 	Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error)
+	DescribeKey(ctx context.Context, params *kms.DescribeKeyInput, optFns ...func(*kms.Options)) (*kms.DescribeKeyOutput, error)
+	GetKeyRotationStatus(ctx context.Context, params *kms.GetKeyRotationStatusInput, optFns ...func(*kms.Options)) (*kms.GetKeyRotationStatusOutput, error)
+	ReEncrypt(ctx context.Context, params *kms.ReEncryptInput, optFns ...func(*kms.Options)) (*kms.ReEncryptOutput, error)
 }
 
 func New(region, kmsEndpoint string, qps, burst, retryTokenCapacity int, sourceArn string) (AWSKMSv2, error) {
@@ -55,7 +64,7 @@ func New(region, kmsEndpoint string, qps, burst, retryTokenCapacity int, sourceA
 			})
 		}))
 	case qps > 0:
-		zap.L().Info("--qps-limit and --burst-limit are deprecated, use --retry-token-capacity instead")
+		logging.L(logging.ComponentCloud).Info("--qps-limit and --burst-limit are deprecated, use --retry-token-capacity instead")
 		if burst <= 0 {
 			return nil, fmt.Errorf("burst expected >0, got %d", burst)
 		}
@@ -77,21 +86,23 @@ func New(region, kmsEndpoint string, qps, burst, retryTokenCapacity int, sourceA
 		return nil, fmt.Errorf("failed to create AWS config: %w", err)
 	}
 
+	cfg.APIOptions = append(cfg.APIOptions, addAttemptCounter)
+
 	err = addConfusedDeputyHeaders(&cfg, sourceArn)
 	if err != nil {
 		return nil, err
 	}
 
-	if cfg.Region == "" {
-		ec2 := imds.NewFromConfig(cfg)
-		region, err := ec2.GetRegion(context.Background(), &imds.GetRegionInput{})
-		if err != nil {
-			return nil, fmt.Errorf("failed to call the metadata server's region API, %v", err)
-		}
-		cfg.Region = region.Region
+	cfg.Region, _, err = ResolveRegion(context.Background(), cfg)
+	if err != nil {
+		return nil, err
 	}
 
-	var kmsOptFns []func(*kms.Options)
+	kmsOptFns := []func(*kms.Options){
+		func(o *kms.Options) {
+			o.TracerProvider = tracing.SDKTracerProvider()
+		},
+	}
 	if kmsEndpoint != "" {
 		kmsOptFns = append(kmsOptFns, func(o *kms.Options) {
 			o.BaseEndpoint = aws.String(kmsEndpoint)
@@ -102,6 +113,79 @@ func New(region, kmsEndpoint string, qps, burst, retryTokenCapacity int, sourceA
 	return client, nil
 }
 
+// Sources of the region returned by ResolveRegion
+const (
+	// RegionSourceConfig is the --region flag, the environment or the shared config
+	RegionSourceConfig = "config"
+	// RegionSourceIMDS is the EC2 instance metadata service
+	RegionSourceIMDS = "imds"
+)
+
+// ResolveRegion returns the region of cfg, or the region of the EC2 instance
+// from the metadata service if cfg has none, and where it was found.
+func ResolveRegion(ctx context.Context, cfg aws.Config) (string, string, error) {
+	if cfg.Region != "" {
+		return cfg.Region, RegionSourceConfig, nil
+	}
+	region, err := imds.NewFromConfig(cfg).GetRegion(ctx, &imds.GetRegionInput{})
+	if err != nil {
+		return "", RegionSourceIMDS, fmt.Errorf("failed to call the metadata server's region API, %v", err)
+	}
+	return region.Region, RegionSourceIMDS, nil
+}
+
+// Region returns the region a client created by New sends requests to, or an
+// empty string if it is not known (e.g. for mocks).
+func Region(svc AWSKMSv2) string {
+	if client, ok := svc.(*kms.Client); ok {
+		return client.Options().Region
+	}
+	return ""
+}
+
+// RequestID returns the KMS request ID from the metadata of a call's output
+func RequestID(metadata smithymiddleware.Metadata) string {
+	id, _ := awsmiddleware.GetRequestIDMetadata(metadata)
+	return id
+}
+
+// ErrorRequestID returns the KMS request ID of a failed call, or an empty
+// string if the call failed before KMS responded.
+func ErrorRequestID(err error) string {
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) {
+		return respErr.ServiceRequestID()
+	}
+	return ""
+}
+
+// SourceAccount returns the account ID of the confused deputy source ARN
+func SourceAccount(sourceArn string) (string, error) {
+	return getSourceAccount(sourceArn)
+}
+
+type attemptCounterKey struct{}
+
+// WithAttemptCounter returns a context that counts the attempts, including
+// retries, made by calls to a client created by New with that context.
+func WithAttemptCounter(ctx context.Context) (context.Context, *atomic.Int32) {
+	attempts := new(atomic.Int32)
+	return context.WithValue(ctx, attemptCounterKey{}, attempts), attempts
+}
+
+// addAttemptCounter adds a middleware that runs once per attempt, after the
+// SDK's retry middleware, and increments the counter of WithAttemptCounter.
+func addAttemptCounter(stack *smithymiddleware.Stack) error {
+	return stack.Finalize.Insert(smithymiddleware.FinalizeMiddlewareFunc("KMSAttemptCounter", func(
+		ctx context.Context, in smithymiddleware.FinalizeInput, next smithymiddleware.FinalizeHandler,
+	) (smithymiddleware.FinalizeOutput, smithymiddleware.Metadata, error) {
+		if attempts, ok := ctx.Value(attemptCounterKey{}).(*atomic.Int32); ok {
+			attempts.Add(1)
+		}
+		return next.HandleFinalize(ctx, in)
+	}), "Retry", smithymiddleware.After)
+}
+
 func addConfusedDeputyHeaders(cfg *aws.Config, sourceArn string) error {
 	if sourceArn != "" {
 		sourceAccount, err := getSourceAccount(sourceArn)
@@ -122,7 +206,7 @@ func addConfusedDeputyHeaders(cfg *aws.Config, sourceArn string) error {
 			}), smithymiddleware.Before)
 		})
 
-		zap.L().Info("configuring KMS client with confused deputy headers",
+		logging.L(logging.ComponentCloud).Info("configuring KMS client with confused deputy headers",
 			zap.String("sourceArn", sourceArn),
 			zap.String("sourceAccount", sourceAccount),
 		)
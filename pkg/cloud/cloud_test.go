@@ -1,10 +1,20 @@
 package cloud
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"sync/atomic"
 	"testing"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 // TLSBundleCert ai.crt
@@ -128,3 +138,81 @@ func TestGetSourceAccount(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "123456789012", account)
 }
+
+func TestRegion(t *testing.T) {
+	client, err := New("eu-west-1", "", 0, 0, 0, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "eu-west-1", Region(client))
+	assert.Equal(t, "", Region(&KMSMock{}))
+}
+
+// newFlakyKMSServer returns a KMS endpoint failing the first requests with a
+// retryable error, and a count of the requests it received.
+func newFlakyKMSServer(t *testing.T, failures int32) (*httptest.Server, *atomic.Int32) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+
+	calls := new(atomic.Int32)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		if calls.Add(1) <= failures {
+			rw.WriteHeader(http.StatusInternalServerError)
+			_, _ = rw.Write([]byte(`{"__type":"KMSInternalException","message":"test"}`))
+			return
+		}
+		_, _ = rw.Write([]byte(`{"CiphertextBlob":"aGVsbG8gd29ybGQ=","KeyId":"test-key"}`))
+	}))
+	return srv, calls
+}
+
+func TestAttemptCounter(t *testing.T) {
+	srv, calls := newFlakyKMSServer(t, 1)
+	defer srv.Close()
+
+	c, err := New("us-west-2", srv.URL, 0, 0, 0, "")
+	require.NoError(t, err)
+	ctx, attempts := WithAttemptCounter(context.Background())
+	_, err = c.Encrypt(ctx, &kms.EncryptInput{KeyId: aws.String("test-key"), Plaintext: []byte("hello")})
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), calls.Load())
+	assert.Equal(t, int32(2), attempts.Load())
+
+	// calls without a counter are unaffected
+	_, err = c.Encrypt(context.Background(), &kms.EncryptInput{KeyId: aws.String("test-key"), Plaintext: []byte("hello")})
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), attempts.Load())
+}
+
+func TestTracingRetries(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	defer otel.SetTracerProvider(prev)
+
+	srv, calls := newFlakyKMSServer(t, 1)
+	defer srv.Close()
+
+	c, err := New("us-west-2", srv.URL, 0, 0, 0, "")
+	require.NoError(t, err)
+	_, err = c.Encrypt(context.Background(), &kms.EncryptInput{KeyId: aws.String("test-key"), Plaintext: []byte("hello")})
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), calls.Load())
+
+	spans := exporter.GetSpans()
+	var operation *tracetest.SpanStub
+	attempts := 0
+	for i := range spans {
+		switch spans[i].Name {
+		case "KMS.Encrypt":
+			operation = &spans[i]
+		case "Attempt":
+			attempts++
+		}
+	}
+	require.NotNil(t, operation, "no span for the KMS operation in %v", spans.Snapshots())
+	assert.Equal(t, 2, attempts)
+	for _, span := range spans {
+		assert.Equal(t, operation.SpanContext.TraceID(), span.SpanContext.TraceID(), "span %s", span.Name)
+	}
+}
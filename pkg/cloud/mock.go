@@ -19,10 +19,12 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
 )
 
 type EncryptAssertion func(params *kms.EncryptInput) bool
 type DecryptAssertion func(params *kms.DecryptInput) bool
+type ReEncryptAssertion func(params *kms.ReEncryptInput) bool
 
 type EncryptRule struct {
 	Assertion EncryptAssertion
@@ -36,6 +38,12 @@ type DecryptRule struct {
 	Error     error
 }
 
+type ReEncryptRule struct {
+	Assertion ReEncryptAssertion
+	Output    *kms.ReEncryptOutput
+	Error     error
+}
+
 type KMSMock struct {
 	AWSKMSv2
 
@@ -46,14 +54,23 @@ type KMSMock struct {
 	defaultEncErr error
 	defaultDecOut *kms.DecryptOutput
 	defaultDecErr error
+	defaultReOut  *kms.ReEncryptOutput
+	defaultReErr  error
+
+	// Key metadata responses
+	describeKeyOut *kms.DescribeKeyOutput
+	describeKeyErr error
+	rotationOut    *kms.GetKeyRotationStatusOutput
+	rotationErr    error
 
 	// Delay for simulating slow responses
 	encryptDelay time.Duration
 	decryptDelay time.Duration
 
 	// Conditional rules (evaluated in order)
-	encryptRules []EncryptRule
-	decryptRules []DecryptRule
+	encryptRules   []EncryptRule
+	decryptRules   []DecryptRule
+	reEncryptRules []ReEncryptRule
 }
 
 // SetDefaultEncryptResp sets the default encrypt response
@@ -74,6 +91,15 @@ func (m *KMSMock) SetDefaultDecryptResp(dec string, decErr error) *KMSMock {
 	return m
 }
 
+// SetReEncryptResp sets the default ReEncrypt response
+func (m *KMSMock) SetReEncryptResp(enc string, reErr error) *KMSMock {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.defaultReOut = &kms.ReEncryptOutput{CiphertextBlob: []byte(enc)}
+	m.defaultReErr = reErr
+	return m
+}
+
 // Legacy methods for backward compatibility
 func (m *KMSMock) SetEncryptResp(enc string, encErr error) *KMSMock {
 	return m.SetDefaultEncryptResp(enc, encErr)
@@ -109,12 +135,26 @@ func (m *KMSMock) AddDecryptRule(assertion DecryptAssertion, dec string, decErr
 	return m
 }
 
+// AddReEncryptRule adds a conditional ReEncrypt rule
+func (m *KMSMock) AddReEncryptRule(assertion ReEncryptAssertion, enc string, reErr error) *KMSMock {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	rule := ReEncryptRule{
+		Assertion: assertion,
+		Output:    &kms.ReEncryptOutput{CiphertextBlob: []byte(enc)},
+		Error:     reErr,
+	}
+	m.reEncryptRules = append(m.reEncryptRules, rule)
+	return m
+}
+
 // ClearRules removes all conditional rules
 func (m *KMSMock) ClearRules() *KMSMock {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 	m.encryptRules = nil
 	m.decryptRules = nil
+	m.reEncryptRules = nil
 	return m
 }
 
@@ -187,3 +227,54 @@ func (m *KMSMock) Decrypt(ctx context.Context, params *kms.DecryptInput, optFns
 	// Fall back to default response
 	return m.defaultDecOut, m.defaultDecErr
 }
+
+// SetDescribeKeyResp sets the DescribeKey response
+func (m *KMSMock) SetDescribeKeyResp(metadata *kmstypes.KeyMetadata, err error) *KMSMock {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.describeKeyOut = &kms.DescribeKeyOutput{KeyMetadata: metadata}
+	m.describeKeyErr = err
+	return m
+}
+
+// SetKeyRotationStatusResp sets the GetKeyRotationStatus response
+func (m *KMSMock) SetKeyRotationStatusResp(enabled bool, err error) *KMSMock {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.rotationOut = &kms.GetKeyRotationStatusOutput{KeyRotationEnabled: enabled}
+	m.rotationErr = err
+	return m
+}
+
+func (m *KMSMock) DescribeKey(ctx context.Context, params *kms.DescribeKeyInput, optFns ...func(*kms.Options)) (*kms.DescribeKeyOutput, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	if m.describeKeyOut == nil && m.describeKeyErr == nil {
+		return &kms.DescribeKeyOutput{}, nil
+	}
+	return m.describeKeyOut, m.describeKeyErr
+}
+
+func (m *KMSMock) GetKeyRotationStatus(ctx context.Context, params *kms.GetKeyRotationStatusInput, optFns ...func(*kms.Options)) (*kms.GetKeyRotationStatusOutput, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	if m.rotationOut == nil && m.rotationErr == nil {
+		return &kms.GetKeyRotationStatusOutput{}, nil
+	}
+	return m.rotationOut, m.rotationErr
+}
+
+func (m *KMSMock) ReEncrypt(ctx context.Context, params *kms.ReEncryptInput, optFns ...func(*kms.Options)) (*kms.ReEncryptOutput, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	// Check conditional rules first (in order)
+	for _, rule := range m.reEncryptRules {
+		if rule.Assertion(params) {
+			return rule.Output, rule.Error
+		}
+	}
+
+	// Fall back to default response
+	return m.defaultReOut, m.defaultReErr
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package debugserver implements an opt-in HTTP server exposing CPU and heap
+// profiles, goroutine dumps and the running configuration. It is meant to be
+// bound to localhost or a unix socket, access is not authenticated.
+package debugserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	runtimepprof "runtime/pprof"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+	"go.uber.org/zap"
+	"sigs.k8s.io/aws-encryption-provider/pkg/logging"
+)
+
+// UnixPrefix marks an address as the path of a unix socket
+const UnixPrefix = "unix://"
+
+// Redacted replaces the value of sensitive settings in the config dump
+const Redacted = "[REDACTED]"
+
+// sensitive lists substrings of flag and environment variable names whose
+// values are never dumped. Encryption context values may carry sensitive data.
+var sensitive = []string{"secret", "token", "password", "credential", "headers", "encryption-context"}
+
+// environmentPrefixes selects environment variables included in the config dump
+var environmentPrefixes = []string{"AWS_", "OTEL_"}
+
+// NewHandler returns a mux serving
+//
+//	/debug/pprof/     net/http/pprof profiles
+//	/debug/goroutines a dump of all goroutine stacks
+//	/debug/config     flags and AWS_* and OTEL_* environment variables, redacted
+//
+// Further debug endpoints may be added to the returned mux.
+func NewHandler(fs *flag.FlagSet) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/goroutines", func(rw http.ResponseWriter, _ *http.Request) {
+		rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_ = runtimepprof.Lookup("goroutine").WriteTo(rw, 2)
+	})
+	mux.HandleFunc("/debug/config", func(rw http.ResponseWriter, _ *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(rw)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(Config(fs))
+	})
+	return mux
+}
+
+// ConfigDump is the running configuration served on /debug/config
+type ConfigDump struct {
+	GoVersion   string            `json:"goVersion"`
+	PID         int               `json:"pid"`
+	Flags       map[string]string `json:"flags"`
+	Environment map[string]string `json:"environment"`
+}
+
+// Config returns the values of all flags in fs and the AWS_* and OTEL_*
+// environment variables, with sensitive values redacted.
+func Config(fs *flag.FlagSet) ConfigDump {
+	c := ConfigDump{
+		GoVersion:   runtime.Version(),
+		PID:         os.Getpid(),
+		Flags:       map[string]string{},
+		Environment: map[string]string{},
+	}
+	fs.VisitAll(func(f *flag.Flag) {
+		c.Flags[f.Name] = redact(f.Name, f.Value.String())
+	})
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		for _, prefix := range environmentPrefixes {
+			if strings.HasPrefix(name, prefix) {
+				c.Environment[name] = redact(name, value)
+				break
+			}
+		}
+	}
+	return c
+}
+
+func redact(name, value string) string {
+	if value == "" || value == "[]" {
+		return value
+	}
+	lower := strings.ToLower(strings.ReplaceAll(name, "_", "-"))
+	for _, s := range sensitive {
+		if strings.Contains(lower, s) {
+			return Redacted
+		}
+	}
+	return value
+}
+
+// Listen listens on addr, either a TCP host:port or a unix socket path
+// prefixed with UnixPrefix. A unix socket is only accessible to its owner.
+func Listen(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, UnixPrefix)
+	if !ok {
+		if !IsLoopback(addr) {
+			logging.L(logging.ComponentServer).Warn("debug server is reachable from other hosts, bind it to localhost or a unix socket", zap.String("address", addr))
+		}
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create listener: %v", err)
+		}
+		return l, nil
+	}
+
+	// remove a socket left behind by a process that was killed
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to os.Remove existing socket: %v", err)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create listener: %v", err)
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("failed to restrict socket permissions: %v", err)
+	}
+	return l, nil
+}
+
+// IsLoopback returns true if the TCP address addr only accepts connections
+// from the local host.
+func IsLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package debugserver
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	flag "github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestFlagSet(t *testing.T) *flag.FlagSet {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("region", "", "")
+	fs.StringArray("encryption-context", nil, "")
+	fs.String("auth-bearer-token-file", "", "")
+	require.NoError(t, fs.Parse([]string{
+		"--region=us-west-2",
+		"--encryption-context=cluster=secret-name",
+		"--auth-bearer-token-file=/etc/token",
+	}))
+	return fs
+}
+
+func TestConfig(t *testing.T) {
+	t.Setenv("AWS_REGION", "us-west-2")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "very-secret")
+	t.Setenv("AWS_SESSION_TOKEN", "session")
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "authorization=Bearer abc")
+	t.Setenv("UNRELATED", "value")
+
+	rec := httptest.NewRecorder()
+	NewHandler(newTestFlagSet(t)).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/config", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	for _, secret := range []string{"very-secret", "session", "Bearer abc", "secret-name"} {
+		assert.NotContains(t, rec.Body.String(), secret)
+	}
+
+	var c ConfigDump
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &c))
+	assert.Equal(t, os.Getpid(), c.PID)
+	assert.Equal(t, map[string]string{
+		"region":                 "us-west-2",
+		"encryption-context":     Redacted,
+		"auth-bearer-token-file": Redacted,
+	}, c.Flags)
+	assert.Equal(t, "us-west-2", c.Environment["AWS_REGION"])
+	assert.Equal(t, Redacted, c.Environment["AWS_SECRET_ACCESS_KEY"])
+	assert.Equal(t, Redacted, c.Environment["AWS_SESSION_TOKEN"])
+	assert.Equal(t, Redacted, c.Environment["OTEL_EXPORTER_OTLP_HEADERS"])
+	assert.NotContains(t, c.Environment, "UNRELATED")
+}
+
+func TestHandler(t *testing.T) {
+	h := NewHandler(newTestFlagSet(t))
+
+	tt := []struct {
+		path string
+		want string
+	}{
+		{path: "/debug/pprof/", want: "goroutine"},
+		{path: "/debug/pprof/heap?debug=1", want: "heap profile"},
+		{path: "/debug/goroutines", want: "goroutine "},
+	}
+	for _, tc := range tt {
+		t.Run(tc.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+			require.Equal(t, http.StatusOK, rec.Code)
+			assert.Contains(t, rec.Body.String(), tc.want)
+		})
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "debug.sock")
+	// a stale socket is replaced
+	require.NoError(t, os.WriteFile(path, nil, 0o600))
+
+	l, err := Listen(UnixPrefix + path)
+	require.NoError(t, err)
+	srv := &http.Server{Handler: NewHandler(newTestFlagSet(t))}
+	go func() { _ = srv.Serve(l) }()
+	defer srv.Close()
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	resp, err := client.Get("http://unix/debug/goroutines")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), "goroutine ")
+}
+
+func TestIsLoopback(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"localhost:6060": true,
+		"127.0.0.1:6060": true,
+		"[::1]:6060":     true,
+		":6060":          false,
+		"0.0.0.0:6060":   false,
+		"10.0.0.1:6060":  false,
+		"invalid":        false,
+	} {
+		assert.Equal(t, want, IsLoopback(addr), addr)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 
 	"go.uber.org/zap"
+	"sigs.k8s.io/aws-encryption-provider/pkg/logging"
 	"sigs.k8s.io/aws-encryption-provider/pkg/plugin"
 )
 
@@ -26,9 +27,9 @@ func (hd *handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 			rw.WriteHeader(http.StatusInternalServerError)
 			_, e := fmt.Fprint(rw, err)
 			if e != nil {
-				zap.L().Error("error writing response", zap.Error(e))
+				logging.L(logging.ComponentHealth).Error("error writing response", zap.Error(e))
 			}
-			zap.L().Error("health check failed", zap.Error(err))
+			logging.L(logging.ComponentHealth).Error("health check failed", zap.Error(err))
 			return
 		}
 	}
@@ -38,16 +39,16 @@ func (hd *handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 			rw.WriteHeader(http.StatusInternalServerError)
 			_, e := fmt.Fprint(rw, err)
 			if e != nil {
-				zap.L().Error("error writing response", zap.Error(e))
+				logging.L(logging.ComponentHealth).Error("error writing response", zap.Error(e))
 			}
-			zap.L().Error("health check failed", zap.Error(err))
+			logging.L(logging.ComponentHealth).Error("health check failed", zap.Error(err))
 			return
 		}
 	}
 	rw.WriteHeader(http.StatusOK)
 	_, e := fmt.Fprint(rw, http.StatusText(http.StatusOK))
 	if e != nil {
-		zap.L().Error("error writing response", zap.Error(e))
+		logging.L(logging.ComponentHealth).Error("error writing response", zap.Error(e))
 	}
-	zap.L().Debug("health check success")
+	logging.L(logging.ComponentHealth).Debug("health check success")
 }
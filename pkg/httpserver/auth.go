@@ -0,0 +1,83 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package httpserver
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.uber.org/zap"
+	"sigs.k8s.io/aws-encryption-provider/pkg/logging"
+)
+
+// Authenticator guards metrics and debug endpoints. A request is allowed if it
+// presents a client certificate verified by the server's client CA, or a bearer
+// token matching the contents of the token file. When neither is configured
+// every request is allowed.
+type Authenticator struct {
+	bearerTokenFile string
+	clientCerts     bool
+}
+
+// NewAuthenticator returns a new *Authenticator. The token file is read on every
+// request so that the token can be rotated without restarting the process.
+func NewAuthenticator(bearerTokenFile string, clientCerts bool) *Authenticator {
+	return &Authenticator{bearerTokenFile: bearerTokenFile, clientCerts: clientCerts}
+}
+
+// Enabled returns true if requests must be authenticated
+func (a *Authenticator) Enabled() bool {
+	return a.bearerTokenFile != "" || a.clientCerts
+}
+
+// Wrap returns a handler that rejects unauthenticated requests with 401
+func (a *Authenticator) Wrap(h http.Handler) http.Handler {
+	if !a.Enabled() {
+		return h
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if !a.authenticated(req) {
+			logging.L(logging.ComponentServer).Debug("rejecting unauthenticated request", zap.String("path", req.URL.Path), zap.String("remote-addr", req.RemoteAddr))
+			rw.Header().Set("WWW-Authenticate", `Bearer realm="aws-encryption-provider"`)
+			http.Error(rw, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(rw, req)
+	})
+}
+
+func (a *Authenticator) authenticated(req *http.Request) bool {
+	if a.clientCerts && req.TLS != nil && len(req.TLS.VerifiedChains) > 0 {
+		return true
+	}
+	if a.bearerTokenFile == "" {
+		return false
+	}
+	presented, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok || presented == "" {
+		return false
+	}
+	expected, err := os.ReadFile(a.bearerTokenFile)
+	if err != nil {
+		logging.L(logging.ComponentServer).Error("failed to read bearer token file", zap.Error(err))
+		return false
+	}
+	token := strings.TrimSpace(string(expected))
+	if token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(presented), []byte(token)) == 1
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package httpserver implements the HTTP server used for probes, metrics and
+// debug endpoints.
+package httpserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"sigs.k8s.io/aws-encryption-provider/pkg/logging"
+)
+
+const (
+	DefaultReadHeaderTimeout = 5 * time.Second
+	DefaultReadTimeout       = 10 * time.Second
+	DefaultWriteTimeout      = 30 * time.Second
+	DefaultIdleTimeout       = 60 * time.Second
+	DefaultShutdownTimeout   = 10 * time.Second
+)
+
+// Config configures a Server. TLS is enabled when both TLSCertFile and
+// TLSKeyFile are set, and client certificates are verified against
+// ClientCAFile when it is set.
+type Config struct {
+	Addr         string
+	TLSCertFile  string
+	TLSKeyFile   string
+	ClientCAFile string
+}
+
+// TLSEnabled returns true if the config serves HTTPS
+func (c Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" && c.TLSKeyFile != ""
+}
+
+type Server struct {
+	*http.Server
+	tlsEnabled bool
+}
+
+// New returns a *Server serving handler with timeouts set. The TLS certificate
+// is reloaded from disk whenever the files change, so it can be rotated without
+// restarting the process.
+func New(cfg Config, handler http.Handler) (*Server, error) {
+	s := &Server{
+		Server: &http.Server{
+			Addr:              cfg.Addr,
+			Handler:           handler,
+			ReadHeaderTimeout: DefaultReadHeaderTimeout,
+			ReadTimeout:       DefaultReadTimeout,
+			WriteTimeout:      DefaultWriteTimeout,
+			IdleTimeout:       DefaultIdleTimeout,
+		},
+	}
+	if !cfg.TLSEnabled() {
+		if cfg.TLSCertFile != "" || cfg.TLSKeyFile != "" || cfg.ClientCAFile != "" {
+			return nil, errors.New("both a TLS certificate and key are required to serve TLS")
+		}
+		return s, nil
+	}
+
+	certs := &certReloader{certFile: cfg.TLSCertFile, keyFile: cfg.TLSKeyFile}
+	if _, err := certs.GetCertificate(nil); err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certs.GetCertificate,
+	}
+	if cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in client CA file %s", cfg.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		// probes do not present a certificate, endpoints that need one are wrapped by Authenticator
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	s.TLSConfig = tlsConfig
+	s.tlsEnabled = true
+	return s, nil
+}
+
+// ListenAndServe listens on the configured address. It returns nil once the
+// server is shut down.
+func (s *Server) ListenAndServe() error {
+	l, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to create listener: %v", err)
+	}
+	return s.Serve(l)
+}
+
+// Serve serves HTTP or HTTPS on l. It returns nil once the server is shut down.
+func (s *Server) Serve(l net.Listener) error {
+	var err error
+	if s.tlsEnabled {
+		err = s.Server.ServeTLS(l, "", "")
+	} else {
+		err = s.Server.Serve(l)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// certReloader loads a key pair and reloads it when either file is modified
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	certMod time.Time
+	keyMod  time.Time
+}
+
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat TLS certificate: %v", err)
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat TLS key: %v", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cert != nil && certInfo.ModTime().Equal(r.certMod) && keyInfo.ModTime().Equal(r.keyMod) {
+		return r.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		if r.cert != nil {
+			// the files may be mid-rotation, keep serving the previous certificate
+			logging.L(logging.ComponentServer).Warn("failed to reload TLS certificate", zap.Error(err))
+			return r.cert, nil
+		}
+		return nil, fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+	if r.cert != nil {
+		logging.L(logging.ComponentServer).Info("reloaded TLS certificate", zap.String("cert-file", r.certFile))
+	}
+	r.cert, r.certMod, r.keyMod = &cert, certInfo.ModTime(), keyInfo.ModTime()
+	return r.cert, nil
+}
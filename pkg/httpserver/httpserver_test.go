@@ -0,0 +1,208 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package httpserver
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+func newTestCert(t *testing.T, serial int64, parent *testCert, isCA bool) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: fmt.Sprintf("test-%d", serial)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	parentCert, parentKey := tmpl, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parentCert, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func writeFile(t *testing.T, path string, data []byte, mod time.Time) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, data, 0600))
+	require.NoError(t, os.Chtimes(path, mod, mod))
+}
+
+func startServer(t *testing.T, cfg Config, handler http.Handler) string {
+	t.Helper()
+	s, err := New(cfg, handler)
+	require.NoError(t, err)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.Serve(l)
+	}()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultShutdownTimeout)
+		defer cancel()
+		assert.NoError(t, s.Shutdown(ctx))
+		assert.NoError(t, <-errc)
+	})
+	return l.Addr().String()
+}
+
+var okHandler = http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+	_, _ = fmt.Fprint(rw, "OK")
+})
+
+func TestServePlainHTTP(t *testing.T) {
+	zap.ReplaceGlobals(zap.NewExample())
+
+	addr := startServer(t, Config{}, okHandler)
+	resp, err := http.Get("http://" + addr + "/")
+	require.NoError(t, err)
+	defer resp.Body.Close() //nolint:errcheck
+	d, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "OK", string(d))
+}
+
+func TestNewRequiresCertAndKey(t *testing.T) {
+	_, err := New(Config{TLSCertFile: "/tmp/cert.pem"}, okHandler)
+	assert.Error(t, err)
+}
+
+func TestServeTLSReloadsCertificate(t *testing.T) {
+	zap.ReplaceGlobals(zap.NewExample())
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	first := newTestCert(t, 1, nil, false)
+	mod := time.Now().Add(-time.Minute)
+	writeFile(t, certFile, first.certPEM, mod)
+	writeFile(t, keyFile, first.keyPEM, mod)
+
+	addr := startServer(t, Config{TLSCertFile: certFile, TLSKeyFile: keyFile}, okHandler)
+
+	servedSerial := func() int64 {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true}) //nolint:gosec
+		require.NoError(t, err)
+		defer conn.Close() //nolint:errcheck
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+	}
+	assert.Equal(t, int64(1), servedSerial())
+
+	second := newTestCert(t, 2, nil, false)
+	writeFile(t, certFile, second.certPEM, time.Now())
+	writeFile(t, keyFile, second.keyPEM, time.Now())
+	assert.Equal(t, int64(2), servedSerial())
+}
+
+func TestAuthenticator(t *testing.T) {
+	zap.ReplaceGlobals(zap.NewExample())
+
+	dir := t.TempDir()
+	ca := newTestCert(t, 1, nil, true)
+	server := newTestCert(t, 2, ca, false)
+	client := newTestCert(t, 3, ca, false)
+	certFile, keyFile, caFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt")
+	tokenFile := filepath.Join(dir, "token")
+	writeFile(t, certFile, server.certPEM, time.Now())
+	writeFile(t, keyFile, server.keyPEM, time.Now())
+	writeFile(t, caFile, ca.certPEM, time.Now())
+	writeFile(t, tokenFile, []byte("s3cret\n"), time.Now())
+
+	auth := NewAuthenticator(tokenFile, true)
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", okHandler)
+	mux.Handle("/metrics", auth.Wrap(okHandler))
+	addr := startServer(t, Config{TLSCertFile: certFile, TLSKeyFile: keyFile, ClientCAFile: caFile}, mux)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	clientPair, err := tls.X509KeyPair(client.certPEM, client.keyPEM)
+	require.NoError(t, err)
+
+	tt := []struct {
+		name         string
+		path         string
+		token        string
+		clientCert   bool
+		expectStatus int
+	}{
+		{name: "probe without credentials", path: "/healthz", expectStatus: http.StatusOK},
+		{name: "metrics without credentials", path: "/metrics", expectStatus: http.StatusUnauthorized},
+		{name: "metrics with wrong token", path: "/metrics", token: "wrong", expectStatus: http.StatusUnauthorized},
+		{name: "metrics with token", path: "/metrics", token: "s3cret", expectStatus: http.StatusOK},
+		{name: "metrics with client certificate", path: "/metrics", clientCert: true, expectStatus: http.StatusOK},
+	}
+	for _, entry := range tt {
+		t.Run(entry.name, func(t *testing.T) {
+			tlsConfig := &tls.Config{RootCAs: roots}
+			if entry.clientCert {
+				tlsConfig.Certificates = []tls.Certificate{clientPair}
+			}
+			c := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+			req, err := http.NewRequest(http.MethodGet, "https://"+addr+entry.path, nil)
+			require.NoError(t, err)
+			if entry.token != "" {
+				req.Header.Set("Authorization", "Bearer "+entry.token)
+			}
+			resp, err := c.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close() //nolint:errcheck
+			assert.Equal(t, entry.expectStatus, resp.StatusCode)
+		})
+	}
+}
@@ -114,6 +114,104 @@ func ParseError(err error) (errorType KMSErrorType) {
 	return KMSErrorTypeOther
 }
 
+// Degraded reasons reported for a failing key, bounded so they can be used as metric labels.
+const (
+	DegradedReasonNone               = ""
+	DegradedReasonThrottled          = "throttled"
+	DegradedReasonKeyDisabled        = "key-disabled"
+	DegradedReasonKeyInaccessible    = "key-inaccessible"
+	DegradedReasonCredentialsExpired = "credentials-expired"
+	DegradedReasonCorruption         = "corruption"
+	DegradedReasonUnavailable        = "unavailable"
+)
+
+// DegradedReasons lists every non-empty reason returned by ParseDegradedReason.
+var DegradedReasons = []string{
+	DegradedReasonThrottled,
+	DegradedReasonKeyDisabled,
+	DegradedReasonKeyInaccessible,
+	DegradedReasonCredentialsExpired,
+	DegradedReasonCorruption,
+	DegradedReasonUnavailable,
+}
+
+// ParseDegradedReason explains why KMS calls are failing in terms an operator can act on.
+// It refines ParseError, which only distinguishes errors by who is responsible for them.
+func ParseDegradedReason(err error) string {
+	errType := ParseError(err)
+	switch errType {
+	case KMSErrorTypeNil:
+		return DegradedReasonNone
+	case KMSErrorTypeThrottled:
+		return DegradedReasonThrottled
+	case KMSErrorTypeCorruption:
+		return DegradedReasonCorruption
+	}
+
+	var ae smithy.APIError
+	if errors.As(err, &ae) {
+		switch ae.ErrorCode() {
+		case (&kmstypes.DisabledException{}).ErrorCode(),
+			(&kmstypes.KMSInvalidStateException{}).ErrorCode():
+			return DegradedReasonKeyDisabled
+		case "ExpiredTokenException", "ExpiredToken", "RequestExpired", "UnrecognizedClientException":
+			return DegradedReasonCredentialsExpired
+		}
+	}
+	// the SDK fails before sending the request when the credential provider cannot refresh
+	if strings.Contains(err.Error(), "failed to refresh cached credentials") {
+		return DegradedReasonCredentialsExpired
+	}
+	if errType == KMSErrorTypeUserInduced && !errors.Is(err, context.Canceled) {
+		return DegradedReasonKeyInaccessible
+	}
+	return DegradedReasonUnavailable
+}
+
+// ErrorCodeOther is reported by ParseErrorCode for errors that are not known
+// KMS or AWS API errors.
+const ErrorCodeOther = "other"
+
+// errorCodes are the API error codes reported by ParseErrorCode. Anything else
+// is reported as ErrorCodeOther so that the code can be used as a metric label.
+var errorCodes = map[string]bool{
+	(&kmstypes.DependencyTimeoutException{}).ErrorCode(): true,
+	(&kmstypes.DisabledException{}).ErrorCode():          true,
+	(&kmstypes.IncorrectKeyException{}).ErrorCode():      true,
+	(&kmstypes.InvalidArnException{}).ErrorCode():        true,
+	(&kmstypes.InvalidCiphertextException{}).ErrorCode(): true,
+	(&kmstypes.InvalidGrantTokenException{}).ErrorCode(): true,
+	(&kmstypes.InvalidKeyUsageException{}).ErrorCode():   true,
+	(&kmstypes.KeyUnavailableException{}).ErrorCode():    true,
+	(&kmstypes.KMSInternalException{}).ErrorCode():       true,
+	(&kmstypes.KMSInvalidStateException{}).ErrorCode():   true,
+	(&kmstypes.LimitExceededException{}).ErrorCode():     true,
+	(&kmstypes.NotFoundException{}).ErrorCode():          true,
+	(&kmstypes.DryRunOperationException{}).ErrorCode():   true,
+	"AccessDeniedException":                              true,
+	"ExpiredTokenException":                              true,
+	"IncompleteSignature":                                true,
+	"InvalidClientTokenId":                               true,
+	"InvalidSignatureException":                          true,
+	"RequestExpired":                                     true,
+	"ServiceUnavailable":                                 true,
+	"ThrottlingException":                                true,
+	"UnrecognizedClientException":                        true,
+}
+
+// ParseErrorCode returns the AWS API error code of err, an empty string if err
+// is nil, or ErrorCodeOther if it is not a known API error.
+func ParseErrorCode(err error) string {
+	if err == nil {
+		return ""
+	}
+	var ae smithy.APIError
+	if errors.As(err, &ae) && errorCodes[ae.ErrorCode()] {
+		return ae.ErrorCode()
+	}
+	return ErrorCodeOther
+}
+
 const (
 	StatusSuccess           = "success"
 	StatusFailure           = "failure"
@@ -121,6 +219,9 @@ const (
 	StatusFailureCorruption = "failure-corruption"
 	OperationEncrypt        = "encrypt"
 	OperationDecrypt        = "decrypt"
+
+	OperationDescribeKey          = "describe_key"
+	OperationGetKeyRotationStatus = "get_key_rotation_status"
 )
 
 // StorageVersion is a prefix used for versioning encrypted content
@@ -3,6 +3,7 @@ package kmsplugin
 import (
 	"context"
 	"errors"
+	"fmt"
 	"testing"
 
 	"github.com/aws/aws-sdk-go-v2/service/kms/types"
@@ -142,3 +143,108 @@ func TestParseError(t *testing.T) {
 		})
 	}
 }
+
+func TestParseDegradedReason(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected string
+	}{
+		{
+			name:     "nil error",
+			err:      nil,
+			expected: DegradedReasonNone,
+		},
+		{
+			name:     "LimitExceededException",
+			err:      &mockAPIError{code: (&types.LimitExceededException{}).ErrorCode()},
+			expected: DegradedReasonThrottled,
+		},
+		{
+			name:     "DisabledException",
+			err:      &mockAPIError{code: (&types.DisabledException{}).ErrorCode()},
+			expected: DegradedReasonKeyDisabled,
+		},
+		{
+			name:     "KMSInvalidStateException",
+			err:      &mockAPIError{code: (&types.KMSInvalidStateException{}).ErrorCode()},
+			expected: DegradedReasonKeyDisabled,
+		},
+		{
+			name:     "AccessDeniedException caused by key not existing",
+			err:      &mockAPIError{code: "AccessDeniedException", message: "The ciphertext refers to a customer master key that does not exist"},
+			expected: DegradedReasonKeyInaccessible,
+		},
+		{
+			name:     "ExpiredTokenException",
+			err:      &mockAPIError{code: "ExpiredTokenException", message: "The security token included in the request is expired"},
+			expected: DegradedReasonCredentialsExpired,
+		},
+		{
+			name:     "credential provider failure",
+			err:      errors.New("operation error KMS: Encrypt, get identity: get credentials: failed to refresh cached credentials, no EC2 IMDS role found"),
+			expected: DegradedReasonCredentialsExpired,
+		},
+		{
+			name:     "InvalidCiphertextException",
+			err:      &mockAPIError{code: (&types.InvalidCiphertextException{}).ErrorCode()},
+			expected: DegradedReasonCorruption,
+		},
+		{
+			name:     "KMSInternalException",
+			err:      &mockAPIError{code: (&types.KMSInternalException{}).ErrorCode(), message: "Some other internal error"},
+			expected: DegradedReasonUnavailable,
+		},
+		{
+			name:     "non-API error",
+			err:      errors.New("generic error"),
+			expected: DegradedReasonUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, ParseDegradedReason(tt.err))
+		})
+	}
+}
+
+func TestParseErrorCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected string
+	}{
+		{
+			name:     "nil error",
+			err:      nil,
+			expected: "",
+		},
+		{
+			name:     "DisabledException",
+			err:      &mockAPIError{code: (&types.DisabledException{}).ErrorCode()},
+			expected: "DisabledException",
+		},
+		{
+			name:     "wrapped AccessDeniedException",
+			err:      fmt.Errorf("failed to encrypt %w", &mockAPIError{code: "AccessDeniedException"}),
+			expected: "AccessDeniedException",
+		},
+		{
+			name:     "unknown error code",
+			err:      &mockAPIError{code: "SomethingNewException"},
+			expected: ErrorCodeOther,
+		},
+		{
+			name:     "non-API error",
+			err:      context.DeadlineExceeded,
+			expected: ErrorCodeOther,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, ParseErrorCode(tt.err))
+		})
+	}
+}
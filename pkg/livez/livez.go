@@ -6,6 +6,7 @@ import (
 	"net/http"
 
 	"go.uber.org/zap"
+	"sigs.k8s.io/aws-encryption-provider/pkg/logging"
 	"sigs.k8s.io/aws-encryption-provider/pkg/plugin"
 )
 
@@ -26,9 +27,9 @@ func (hd *handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 			rw.WriteHeader(http.StatusInternalServerError)
 			_, e := fmt.Fprint(rw, err)
 			if e != nil {
-				zap.L().Error("error writing response", zap.Error(e))
+				logging.L(logging.ComponentHealth).Error("error writing response", zap.Error(e))
 			}
-			zap.L().Error("live check failed", zap.Error(err))
+			logging.L(logging.ComponentHealth).Error("live check failed", zap.Error(err))
 			return
 		}
 	}
@@ -38,9 +39,9 @@ func (hd *handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 			rw.WriteHeader(http.StatusInternalServerError)
 			_, e := fmt.Fprint(rw, err)
 			if e != nil {
-				zap.L().Error("error writing response", zap.Error(e))
+				logging.L(logging.ComponentHealth).Error("error writing response", zap.Error(e))
 			}
-			zap.L().Error("live check failed", zap.Error(err))
+			logging.L(logging.ComponentHealth).Error("live check failed", zap.Error(err))
 			return
 		}
 	}
@@ -48,7 +49,7 @@ func (hd *handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	rw.WriteHeader(http.StatusOK)
 	_, e := fmt.Fprint(rw, http.StatusText(http.StatusOK))
 	if e != nil {
-		zap.L().Error("error writing response", zap.Error(e))
+		logging.L(logging.ComponentHealth).Error("error writing response", zap.Error(e))
 	}
-	zap.L().Debug("live check success")
+	logging.L(logging.ComponentHealth).Debug("live check success")
 }
@@ -97,7 +97,7 @@ func TestLivez(t *testing.T) {
 			sharedHealthCheck := plugin.NewSharedHealthCheck(plugin.DefaultHealthCheckPeriod, plugin.DefaultErrcBufSize)
 			go sharedHealthCheck.Start()
 			defer sharedHealthCheck.Stop()
-			p := plugin.New("test-key", c, nil, sharedHealthCheck)
+			p := plugin.New("test-key", c, nil, sharedHealthCheck, nil, nil)
 
 			ready, errc := make(chan struct{}), make(chan error)
 			s := server.New()
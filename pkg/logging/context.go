@@ -0,0 +1,51 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+// FieldRequestUID is the log field holding the UID kube-apiserver assigned to
+// a KMS v2 request
+const FieldRequestUID = "request-uid"
+
+type requestUIDKey struct{}
+
+// WithRequestUID returns a context carrying the UID of the request being served
+func WithRequestUID(ctx context.Context, uid string) context.Context {
+	if uid == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, requestUIDKey{}, uid)
+}
+
+// RequestUIDFromContext returns the request UID carried by ctx, if any
+func RequestUIDFromContext(ctx context.Context) string {
+	uid, _ := ctx.Value(requestUIDKey{}).(string)
+	return uid
+}
+
+// FromContext returns the logger of component, annotated with the request UID
+// carried by ctx so that every line logged while serving a request can be
+// joined to the kube-apiserver logs of that request.
+func FromContext(ctx context.Context, component string) *zap.Logger {
+	l := L(component)
+	if uid := RequestUIDFromContext(ctx); uid != "" {
+		l = l.With(zap.String(FieldRequestUID, uid))
+	}
+	return l
+}
@@ -0,0 +1,41 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestFromContext(t *testing.T) {
+	path, _ := newTestLoggers(t, Options{Level: zapcore.InfoLevel})
+
+	ctx := context.Background()
+	assert.Empty(t, RequestUIDFromContext(ctx))
+	assert.Equal(t, ctx, WithRequestUID(ctx, ""))
+	FromContext(ctx, ComponentPlugin).Info("without uid")
+
+	ctx = WithRequestUID(ctx, "uid-1")
+	assert.Equal(t, "uid-1", RequestUIDFromContext(ctx))
+	FromContext(ctx, ComponentPlugin).Info("with uid")
+
+	lines := readLines(t, path)
+	require.Len(t, lines, 2)
+	assert.NotContains(t, lines[0], FieldRequestUID)
+	assert.Equal(t, "uid-1", lines[1][FieldRequestUID])
+}
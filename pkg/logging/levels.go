@@ -0,0 +1,191 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Components that log through their own named logger
+const (
+	ComponentCloud  = "cloud"
+	ComponentPlugin = "plugin"
+	ComponentHealth = "health"
+	ComponentServer = "server"
+)
+
+// Components lists every component with its own logger
+var Components = []string{ComponentCloud, ComponentPlugin, ComponentHealth, ComponentServer}
+
+// Levels holds the level of the root logger and of each component. A
+// component follows the root level until its level is set explicitly.
+type Levels struct {
+	root       zap.AtomicLevel
+	components map[string]*componentLevel
+}
+
+type componentLevel struct {
+	level zap.AtomicLevel
+	set   atomic.Bool
+}
+
+// NewLevels returns *Levels at the given root level
+func NewLevels(root zapcore.Level) *Levels {
+	l := &Levels{
+		root:       zap.NewAtomicLevelAt(root),
+		components: make(map[string]*componentLevel, len(Components)),
+	}
+	for _, c := range Components {
+		l.components[c] = &componentLevel{level: zap.NewAtomicLevel()}
+	}
+	return l
+}
+
+// Level returns the effective level of component, or of the root logger if
+// component is empty.
+func (l *Levels) Level(component string) (zapcore.Level, error) {
+	if component == "" {
+		return l.root.Level(), nil
+	}
+	c, ok := l.components[component]
+	if !ok {
+		return 0, fmt.Errorf("unknown component %q", component)
+	}
+	if c.set.Load() {
+		return c.level.Level(), nil
+	}
+	return l.root.Level(), nil
+}
+
+// SetLevel sets the level of component, or of the root logger if component is
+// empty.
+func (l *Levels) SetLevel(component string, level zapcore.Level) error {
+	if component == "" {
+		l.root.SetLevel(level)
+		return nil
+	}
+	c, ok := l.components[component]
+	if !ok {
+		return fmt.Errorf("unknown component %q", component)
+	}
+	c.level.SetLevel(level)
+	c.set.Store(true)
+	return nil
+}
+
+// ResetLevel makes component follow the root level again
+func (l *Levels) ResetLevel(component string) error {
+	c, ok := l.components[component]
+	if !ok {
+		return fmt.Errorf("unknown component %q", component)
+	}
+	c.set.Store(false)
+	return nil
+}
+
+func (l *Levels) enabler(component string) zapcore.LevelEnabler {
+	if component == "" {
+		return l.root
+	}
+	c := l.components[component]
+	return zap.LevelEnablerFunc(func(level zapcore.Level) bool {
+		if c.set.Load() {
+			return c.level.Enabled(level)
+		}
+		return l.root.Enabled(level)
+	})
+}
+
+type levelsPayload struct {
+	Level      string            `json:"level"`
+	Components map[string]string `json:"components,omitempty"`
+}
+
+// ServeHTTP reports the current levels on GET. On PUT it sets the level of the
+// component named by the "component" query parameter, or of the root logger
+// if there is none, from a JSON body such as {"level":"debug"}. An empty level
+// makes the component follow the root level again.
+func (l *Levels) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var payload levelsPayload
+		if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+			http.Error(rw, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := l.set(req.URL.Query().Get("component"), payload.Level); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		rw.Header().Set("Allow", "GET, PUT")
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	payload := levelsPayload{Level: l.root.Level().String(), Components: map[string]string{}}
+	names := append([]string(nil), Components...)
+	sort.Strings(names)
+	for _, c := range names {
+		level, _ := l.Level(c)
+		payload.Components[c] = level.String()
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(rw).Encode(payload)
+}
+
+func (l *Levels) set(component, level string) error {
+	if component != "" && level == "" {
+		return l.ResetLevel(component)
+	}
+	var lvl zapcore.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return err
+	}
+	if err := l.SetLevel(component, lvl); err != nil {
+		return err
+	}
+	zap.L().Info("log level changed", zap.String("component", component), zap.Stringer("level", lvl))
+	return nil
+}
+
+// levelCore filters entries by a level enabler before handing them to a core
+// shared by every logger, so that each logger can have its own level.
+type levelCore struct {
+	zapcore.Core
+	enabler zapcore.LevelEnabler
+}
+
+func (c *levelCore) Enabled(level zapcore.Level) bool {
+	return c.enabler.Enabled(level)
+}
+
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCore{Core: c.Core.With(fields), enabler: c.enabler}
+}
+
+func (c *levelCore) Check(entry zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.enabler.Enabled(entry.Level) {
+		return ce
+	}
+	return c.Core.Check(entry, ce)
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+)
+
+func newTestLoggers(t *testing.T, opts Options) (string, *Levels) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "log")
+	opts.OutputPaths = []string{path}
+	root, levels, err := New(opts)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = root.Sync() })
+	return path, levels
+}
+
+func readLines(t *testing.T, path string) []map[string]any {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := []map[string]any{}
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		if line == "" {
+			continue
+		}
+		entry := map[string]any{}
+		require.NoError(t, json.Unmarshal([]byte(line), &entry), line)
+		lines = append(lines, entry)
+	}
+	return lines
+}
+
+func TestComponentLevels(t *testing.T) {
+	path, levels := newTestLoggers(t, Options{
+		Level:           zapcore.InfoLevel,
+		ComponentLevels: map[string]zapcore.Level{ComponentCloud: zapcore.DebugLevel},
+	})
+
+	L(ComponentCloud).Debug("cloud debug")
+	L(ComponentPlugin).Debug("plugin debug")
+	L(ComponentPlugin).Info("plugin info")
+
+	// components without an override follow the root level
+	require.NoError(t, levels.SetLevel("", zapcore.DebugLevel))
+	L(ComponentHealth).Debug("health debug")
+
+	// resetting an override follows the root level again
+	require.NoError(t, levels.SetLevel(ComponentServer, zapcore.ErrorLevel))
+	L(ComponentServer).Info("server info dropped")
+	require.NoError(t, levels.ResetLevel(ComponentServer))
+	L(ComponentServer).Info("server info")
+
+	messages := []string{}
+	for _, entry := range readLines(t, path) {
+		messages = append(messages, entry["logger"].(string)+": "+entry["message"].(string))
+	}
+	assert.Equal(t, []string{
+		"cloud: cloud debug",
+		"plugin: plugin info",
+		"health: health debug",
+		"server: server info",
+	}, messages)
+
+	assert.Error(t, levels.SetLevel("unknown", zapcore.DebugLevel))
+}
+
+func TestNew(t *testing.T) {
+	_, _, err := New(Options{Encoding: "xml"})
+	assert.Error(t, err)
+
+	_, _, err = New(Options{ComponentLevels: map[string]zapcore.Level{"unknown": zapcore.DebugLevel}})
+	assert.Error(t, err)
+
+	path, _ := newTestLoggers(t, Options{Level: zapcore.InfoLevel, Encoding: "console"})
+	L(ComponentPlugin).Info("console message")
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), "\tinfo\tplugin\t")
+	assert.Contains(t, string(b), "console message")
+}
+
+func TestLevelsHandler(t *testing.T) {
+	levels := NewLevels(zapcore.InfoLevel)
+
+	tt := []struct {
+		name       string
+		method     string
+		query      string
+		body       string
+		wantStatus int
+		wantLevel  string
+		wantCloud  string
+	}{
+		{name: "get", method: http.MethodGet, wantStatus: http.StatusOK, wantLevel: "info", wantCloud: "info"},
+		{name: "set root", method: http.MethodPut, body: `{"level":"warn"}`, wantStatus: http.StatusOK, wantLevel: "warn", wantCloud: "warn"},
+		{name: "set component", method: http.MethodPut, query: "?component=cloud", body: `{"level":"debug"}`, wantStatus: http.StatusOK, wantLevel: "warn", wantCloud: "debug"},
+		{name: "reset component", method: http.MethodPut, query: "?component=cloud", body: `{"level":""}`, wantStatus: http.StatusOK, wantLevel: "warn", wantCloud: "warn"},
+		{name: "unknown component", method: http.MethodPut, query: "?component=nope", body: `{"level":"debug"}`, wantStatus: http.StatusBadRequest},
+		{name: "invalid level", method: http.MethodPut, body: `{"level":"loud"}`, wantStatus: http.StatusBadRequest},
+		{name: "invalid body", method: http.MethodPut, body: `debug`, wantStatus: http.StatusBadRequest},
+		{name: "unsupported method", method: http.MethodPost, body: `{"level":"debug"}`, wantStatus: http.StatusMethodNotAllowed},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			levels.ServeHTTP(rec, httptest.NewRequest(tc.method, "/debug/loglevel"+tc.query, strings.NewReader(tc.body)))
+			require.Equal(t, tc.wantStatus, rec.Code, rec.Body.String())
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+			var payload levelsPayload
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &payload))
+			assert.Equal(t, tc.wantLevel, payload.Level)
+			assert.Equal(t, tc.wantCloud, payload.Components[ComponentCloud])
+			assert.Len(t, payload.Components, len(Components))
+		})
+	}
+}
@@ -0,0 +1,220 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Redacted replaces masked values in log entries
+const Redacted = "[REDACTED]"
+
+// RedactAllKeys in RedactionPolicy.EncryptionContextKeys masks the value of
+// every encryption context key
+const RedactAllKeys = "*"
+
+var (
+	// arnPattern matches arn:partition:service:region:account:resource
+	arnPattern = regexp.MustCompile(`arn:(aws[a-z-]*):([a-z0-9-]+):([a-z0-9-]*):([0-9]{12}|aws)?:[^\s"',;()\[\]{}]+`)
+	// tokenPattern splits text into words, a 12 digit word is an account ID
+	tokenPattern = regexp.MustCompile(`[0-9A-Za-z_-]+`)
+)
+
+// RedactionPolicy selects what is masked in log entries. Byte strings, which
+// may be plaintext or ciphertext, are masked regardless of the policy.
+type RedactionPolicy struct {
+	// EncryptionContextKeys are the encryption context keys whose values are
+	// masked in fields created by EncryptionContext, RedactAllKeys masks all
+	EncryptionContextKeys []string
+	// AccountIDs masks 12 digit AWS account IDs, including those in ARNs
+	AccountIDs bool
+	// ARNs masks the account and resource of ARNs, keeping the partition,
+	// service and region
+	ARNs bool
+}
+
+func (p RedactionPolicy) masksText() bool {
+	return p.AccountIDs || p.ARNs
+}
+
+// EncryptionContext returns a field holding an encryption context, whose
+// values are masked according to the RedactionPolicy of the logger
+func EncryptionContext(key string, encryptionCtx map[string]string) zap.Field {
+	return zap.Object(key, encryptionContext(encryptionCtx))
+}
+
+type encryptionContext map[string]string
+
+func (c encryptionContext) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		enc.AddString(k, c[k])
+	}
+	return nil
+}
+
+// NewRedactingCore returns a core masking the entries written to core
+// according to policy. core must write every entry it is given, e.g. one
+// returned by zapcore.NewCore: samplers and level filters wrap the returned
+// core instead.
+func NewRedactingCore(core zapcore.Core, policy RedactionPolicy) zapcore.Core {
+	r := &redactor{policy: policy, keys: map[string]bool{}}
+	for _, k := range policy.EncryptionContextKeys {
+		if k == RedactAllKeys {
+			r.allKeys = true
+		}
+		r.keys[k] = true
+	}
+	return &redactingCore{Core: core, r: r}
+}
+
+type redactingCore struct {
+	zapcore.Core
+	r *redactor
+}
+
+func (c *redactingCore) With(fields []zapcore.Field) zapcore.Core {
+	return &redactingCore{Core: c.Core.With(c.r.fields(fields)), r: c.r}
+}
+
+func (c *redactingCore) Check(entry zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return ce.AddCore(entry, c)
+	}
+	return ce
+}
+
+func (c *redactingCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	entry.Message = c.r.text(entry.Message)
+	return c.Core.Write(entry, c.r.fields(fields))
+}
+
+type redactor struct {
+	policy  RedactionPolicy
+	keys    map[string]bool
+	allKeys bool
+}
+
+func (r *redactor) fields(fields []zapcore.Field) []zapcore.Field {
+	out := make([]zapcore.Field, 0, len(fields))
+	for _, f := range fields {
+		out = append(out, r.field(f)...)
+	}
+	return out
+}
+
+func (r *redactor) field(f zapcore.Field) []zapcore.Field {
+	switch f.Type {
+	case zapcore.BinaryType, zapcore.ByteStringType:
+		b, _ := f.Interface.([]byte)
+		return []zapcore.Field{zap.String(f.Key, fmt.Sprintf("%s %d bytes", Redacted, len(b)))}
+	case zapcore.StringType:
+		if r.policy.masksText() {
+			f.String = r.text(f.String)
+		}
+		return []zapcore.Field{f}
+	case zapcore.ObjectMarshalerType:
+		if c, ok := f.Interface.(encryptionContext); ok {
+			return []zapcore.Field{zap.Object(f.Key, r.encryptionContext(c))}
+		}
+	}
+	if !r.policy.masksText() {
+		return []zapcore.Field{f}
+	}
+	switch f.Type {
+	case zapcore.ErrorType, zapcore.StringerType, zapcore.ArrayMarshalerType, zapcore.ObjectMarshalerType, zapcore.ReflectType:
+		// render the value to mask the text it contains, an error also adds its verbose form
+		enc := zapcore.NewMapObjectEncoder()
+		f.AddTo(enc)
+		keys := make([]string, 0, len(enc.Fields))
+		for k := range enc.Fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		out := make([]zapcore.Field, 0, len(keys))
+		for _, k := range keys {
+			out = append(out, zap.Any(k, r.value(enc.Fields[k])))
+		}
+		return out
+	}
+	return []zapcore.Field{f}
+}
+
+func (r *redactor) encryptionContext(c encryptionContext) encryptionContext {
+	out := make(encryptionContext, len(c))
+	for k, v := range c {
+		if r.allKeys || r.keys[k] {
+			out[k] = Redacted
+		} else {
+			out[k] = r.text(v)
+		}
+	}
+	return out
+}
+
+func (r *redactor) value(v any) any {
+	switch v := v.(type) {
+	case string:
+		return r.text(v)
+	case []byte:
+		return fmt.Sprintf("%s %d bytes", Redacted, len(v))
+	case []any:
+		out := make([]any, len(v))
+		for i := range v {
+			out[i] = r.value(v[i])
+		}
+		return out
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k := range v {
+			out[k] = r.value(v[k])
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// text masks the ARNs and account IDs in s according to the policy
+func (r *redactor) text(s string) string {
+	if !r.policy.masksText() {
+		return s
+	}
+	if r.policy.ARNs {
+		s = arnPattern.ReplaceAllString(s, "arn:$1:$2:$3:"+Redacted)
+	}
+	if r.policy.AccountIDs {
+		s = tokenPattern.ReplaceAllStringFunc(s, func(token string) string {
+			if len(token) != 12 {
+				return token
+			}
+			for _, c := range token {
+				if c < '0' || c > '9' {
+					return token
+				}
+			}
+			return Redacted
+		})
+	}
+	return s
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	testARN     = "arn:aws:kms:us-west-2:111122223333:key/1234abcd-12ab-34cd-56ef-123456789012"
+	testAccount = "111122223333"
+)
+
+func newRedactingLogger(policy RedactionPolicy) (*zap.Logger, *bytes.Buffer) {
+	var buf bytes.Buffer
+	encoder := zapcore.NewJSONEncoder(zapcore.EncoderConfig{MessageKey: "message"})
+	core := NewRedactingCore(zapcore.NewCore(encoder, zapcore.AddSync(&buf), zapcore.DebugLevel), policy)
+	return zap.New(core), &buf
+}
+
+func TestRedactText(t *testing.T) {
+	tt := []struct {
+		name   string
+		policy RedactionPolicy
+		input  string
+		want   string
+	}{
+		{
+			name:  "disabled",
+			input: "key " + testARN + " in account " + testAccount,
+			want:  "key " + testARN + " in account " + testAccount,
+		},
+		{
+			name:   "arns",
+			policy: RedactionPolicy{ARNs: true},
+			input:  "key " + testARN + ", account " + testAccount,
+			want:   "key arn:aws:kms:us-west-2:[REDACTED], account " + testAccount,
+		},
+		{
+			name:   "account ids",
+			policy: RedactionPolicy{AccountIDs: true},
+			input:  "key " + testARN + ", account " + testAccount,
+			// the 12 digit group of the key ID is part of a longer word
+			want: "key arn:aws:kms:us-west-2:[REDACTED]:key/1234abcd-12ab-34cd-56ef-123456789012, account [REDACTED]",
+		},
+		{
+			name:   "both",
+			policy: RedactionPolicy{ARNs: true, AccountIDs: true},
+			input:  `AccessDeniedException: User: arn:aws-us-gov:sts::111122223333:assumed-role/role/session is not authorized`,
+			want:   `AccessDeniedException: User: arn:aws-us-gov:sts::[REDACTED] is not authorized`,
+		},
+		{
+			name:   "other numbers",
+			policy: RedactionPolicy{AccountIDs: true},
+			input:  "retried 3 times after 1234567890123 ms, request 11112222333",
+			want:   "retried 3 times after 1234567890123 ms, request 11112222333",
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			r := NewRedactingCore(nil, tc.policy).(*redactingCore).r
+			assert.Equal(t, tc.want, r.text(tc.input))
+		})
+	}
+}
+
+func TestRedactingCore(t *testing.T) {
+	l, buf := newRedactingLogger(RedactionPolicy{
+		EncryptionContextKeys: []string{"tenant"},
+		AccountIDs:            true,
+		ARNs:                  true,
+	})
+
+	l.With(zap.String("key", testARN)).Info("using key "+testARN,
+		EncryptionContext("ctx", map[string]string{"tenant": "acme", "cluster": "prod/" + testAccount}),
+		zap.Error(fmt.Errorf("failed to encrypt %w", errors.New("access denied to "+testARN))),
+		zap.Strings("keys", []string{testARN, "alias/test"}),
+		zap.Stringer("stringer", stringer(testAccount)),
+		zap.Int("count", 1),
+	)
+
+	out := buf.String()
+	assert.NotContains(t, out, testAccount)
+	assert.NotContains(t, out, "acme")
+
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, map[string]any{
+		"message":  "using key arn:aws:kms:us-west-2:[REDACTED]",
+		"key":      "arn:aws:kms:us-west-2:[REDACTED]",
+		"ctx":      map[string]any{"tenant": Redacted, "cluster": "prod/[REDACTED]"},
+		"error":    "failed to encrypt access denied to arn:aws:kms:us-west-2:[REDACTED]",
+		"keys":     []any{"arn:aws:kms:us-west-2:[REDACTED]", "alias/test"},
+		"stringer": Redacted,
+		"count":    float64(1),
+	}, entry)
+}
+
+func TestRedactEncryptionContext(t *testing.T) {
+	encryptionCtx := map[string]string{"tenant": "acme", "cluster": "prod"}
+	tt := []struct {
+		keys []string
+		want map[string]any
+	}{
+		{keys: nil, want: map[string]any{"tenant": "acme", "cluster": "prod"}},
+		{keys: []string{"tenant"}, want: map[string]any{"tenant": Redacted, "cluster": "prod"}},
+		{keys: []string{RedactAllKeys}, want: map[string]any{"tenant": Redacted, "cluster": Redacted}},
+	}
+	for _, tc := range tt {
+		t.Run(strings.Join(tc.keys, ","), func(t *testing.T) {
+			l, buf := newRedactingLogger(RedactionPolicy{EncryptionContextKeys: tc.keys})
+			l.Info("", EncryptionContext("ctx", encryptionCtx))
+			var entry map[string]any
+			require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+			assert.Equal(t, tc.want, entry["ctx"])
+		})
+	}
+}
+
+// TestNeverLogBytes checks that byte strings, which may be plaintext or
+// ciphertext, are never written whatever the policy
+func TestNeverLogBytes(t *testing.T) {
+	secret := []byte("very secret plaintext")
+	for _, policy := range []RedactionPolicy{{}, {AccountIDs: true, ARNs: true}} {
+		l, buf := newRedactingLogger(policy)
+		l.With(zap.Binary("with", secret)).Info("bytes",
+			zap.Binary("binary", secret),
+			zap.ByteString("bytestring", secret),
+			zap.Any("any", secret),
+		)
+		out := buf.String()
+		assert.NotContains(t, out, string(secret))
+		assert.NotContains(t, out, "dmVyeSBzZWNyZXQgcGxhaW50ZXh0") // base64
+		assert.Equal(t, 4, strings.Count(out, fmt.Sprintf("%s %d bytes", Redacted, len(secret))), out)
+	}
+}
+
+func TestNewRedacts(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Redaction.ARNs = true
+	opts.SamplingThereafter = 0
+	path, _ := newTestLoggers(t, opts)
+
+	L(ComponentCloud).Info("configured", zap.String("key", testARN), EncryptionContext("ctx", map[string]string{"tenant": "acme"}))
+	lines := readLines(t, path)
+	require.Len(t, lines, 1)
+	assert.Equal(t, "arn:aws:kms:us-west-2:[REDACTED]", lines[0]["key"])
+	assert.Equal(t, map[string]any{"tenant": Redacted}, lines[0]["ctx"])
+}
+
+type stringer string
+
+func (s stringer) String() string {
+	return string(s)
+}
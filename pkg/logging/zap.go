@@ -1,10 +1,120 @@
 package logging
 
 import (
+	"fmt"
+	"sync"
+	"time"
+
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
+// Options configures the loggers returned by New
+type Options struct {
+	Level zapcore.Level
+	// Encoding is either "json" or "console"
+	Encoding         string
+	OutputPaths      []string
+	ErrorOutputPaths []string
+	// SamplingInitial and SamplingThereafter configure sampling of repeated
+	// entries per second, a SamplingThereafter of zero disables sampling
+	SamplingInitial    int
+	SamplingThereafter int
+	// ComponentLevels overrides the level of individual components
+	ComponentLevels map[string]zapcore.Level
+	// Redaction selects what is masked in every entry
+	Redaction RedactionPolicy
+}
+
+// DefaultOptions returns the Options matching NewStandardZapConfig
+func DefaultOptions() Options {
+	return Options{
+		Level:              zapcore.InfoLevel,
+		Encoding:           "json",
+		OutputPaths:        []string{"stdout"},
+		ErrorOutputPaths:   []string{"stderr"},
+		SamplingInitial:    100,
+		SamplingThereafter: 100,
+		Redaction:          RedactionPolicy{EncryptionContextKeys: []string{RedactAllKeys}},
+	}
+}
+
+var components sync.Map // component name -> *zap.Logger
+
+// New creates the root logger and a named logger for every component, all
+// writing to the same outputs with levels that can be changed through the
+// returned *Levels. Component loggers are returned by L from then on.
+//
+// This is intended to be used with zap.ReplaceGlobals() in an application's
+// main.go.
+func New(opts Options) (*zap.Logger, *Levels, error) {
+	encoderConfig := NewStandardZapConfig(opts.Level).EncoderConfig
+	var encoder zapcore.Encoder
+	switch opts.Encoding {
+	case "json", "":
+		encoder = zapcore.NewJSONEncoder(encoderConfig)
+	case "console":
+		encoder = zapcore.NewConsoleEncoder(encoderConfig)
+	default:
+		return nil, nil, fmt.Errorf("unsupported log encoding %q", opts.Encoding)
+	}
+
+	levels := NewLevels(opts.Level)
+	for c, level := range opts.ComponentLevels {
+		if err := levels.SetLevel(c, level); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	outputPaths, errorOutputPaths := opts.OutputPaths, opts.ErrorOutputPaths
+	if len(outputPaths) == 0 {
+		outputPaths = []string{"stdout"}
+	}
+	if len(errorOutputPaths) == 0 {
+		errorOutputPaths = []string{"stderr"}
+	}
+	sink, closeOut, err := zap.Open(outputPaths...)
+	if err != nil {
+		return nil, nil, err
+	}
+	errSink, _, err := zap.Open(errorOutputPaths...)
+	if err != nil {
+		closeOut()
+		return nil, nil, err
+	}
+
+	// levels are enforced per logger by levelCore, the shared core accepts every entry
+	core := NewRedactingCore(zapcore.NewCore(encoder, sink, zap.LevelEnablerFunc(func(zapcore.Level) bool { return true })), opts.Redaction)
+	if opts.SamplingThereafter > 0 {
+		core = zapcore.NewSamplerWithOptions(core, time.Second, opts.SamplingInitial, opts.SamplingThereafter)
+	}
+
+	build := func(component string) *zap.Logger {
+		l := zap.New(&levelCore{Core: core, enabler: levels.enabler(component)},
+			zap.ErrorOutput(errSink),
+			zap.AddCaller(),
+			zap.AddStacktrace(zapcore.ErrorLevel),
+		)
+		if component != "" {
+			l = l.Named(component)
+		}
+		return l
+	}
+	for _, c := range Components {
+		components.Store(c, build(c))
+	}
+	return build(""), levels, nil
+}
+
+// L returns the logger of component. Until New is called it is derived from
+// the global logger.
+func L(component string) *zap.Logger {
+	if l, ok := components.Load(component); ok {
+		return l.(*zap.Logger)
+	}
+	return zap.L().Named(component)
+}
+
 // NewStandardLogger creates a new zap.Logger based on common configuration
 //
 // This is intended to be used with zap.ReplaceGlobals() in an application's
@@ -0,0 +1,60 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"sigs.k8s.io/aws-encryption-provider/pkg/audit"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+	"sigs.k8s.io/aws-encryption-provider/pkg/server"
+)
+
+// newAuditEvent starts the audit event of an operation. The caller fills in
+// what KMS returned and passes the event to logAuditEvent once done.
+func newAuditEvent(ctx context.Context, keyID, operation, version, uid string, encryptionCtx map[string]string) *audit.Event {
+	e := &audit.Event{
+		Time:       time.Now(),
+		APIVersion: version,
+		Operation:  operation,
+		Source:     audit.SourceCaller,
+		RequestUID: uid,
+		KeyID:      keyID,
+	}
+	if usageSource(ctx) == UsageSourceHealthCheck {
+		e.Source = audit.SourceHealthCheck
+	}
+	for k := range encryptionCtx {
+		e.EncryptionContextKeys = append(e.EncryptionContextKeys, k)
+	}
+	sort.Strings(e.EncryptionContextKeys)
+	if creds, ok := server.PeerCredentialsFromContext(ctx); ok {
+		e.Peer = &audit.Peer{PID: creds.PID, UID: creds.UID, GID: creds.GID}
+	}
+	return e
+}
+
+// logAuditEvent records the outcome of the operation and writes the event
+func logAuditEvent(l *audit.Logger, e *audit.Event, err error) {
+	e.Latency = time.Since(e.Time)
+	e.Outcome = audit.OutcomeSuccess
+	if err != nil {
+		e.Outcome = audit.OutcomeFailure
+		e.ErrorType = kmsplugin.ParseError(err).String()
+		e.ErrorCode = kmsplugin.ParseErrorCode(err)
+	}
+	l.Log(*e)
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	pb "k8s.io/kms/apis/v2"
+	"sigs.k8s.io/aws-encryption-provider/pkg/audit"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+)
+
+func TestAuditV2(t *testing.T) {
+	zap.ReplaceGlobals(zap.NewExample())
+
+	var buf bytes.Buffer
+	auditLog := audit.NewWithCore(zapcore.NewCore(zapcore.NewJSONEncoder(zapcore.EncoderConfig{}), zapcore.AddSync(&buf), zapcore.InfoLevel))
+
+	c := &cloud.KMSMock{}
+	sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+	go sharedHealthCheck.Start()
+	defer sharedHealthCheck.Stop()
+	encryptionCtx := map[string]string{"cluster": "secret-cluster-name", "app": "secret-app-name"}
+	p := NewV2(key, c, encryptionCtx, sharedHealthCheck, newTestMetrics(t), auditLog)
+
+	c.SetEncryptResp(encryptedMessage, nil)
+	_, err := p.Encrypt(context.Background(), &pb.EncryptRequest{Plaintext: []byte(plainMessage), Uid: "encrypt-uid"})
+	require.NoError(t, err)
+	c.SetDecryptResp("", &kmstypes.DisabledException{Message: aws.String("test")})
+	_, err = p.Decrypt(context.Background(), &pb.DecryptRequest{Ciphertext: []byte(encryptedMessageV2), Uid: "decrypt-uid"})
+	require.Error(t, err)
+	_, err = p.Decrypt(context.Background(), &pb.DecryptRequest{Uid: "empty-uid"})
+	require.Error(t, err)
+
+	out := buf.String()
+	for _, secret := range []string{plainMessage, encryptedMessage, "secret-cluster-name", "secret-app-name"} {
+		assert.NotContains(t, out, secret)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	require.Len(t, lines, 3)
+	expected := []map[string]any{
+		{"operation": kmsplugin.OperationEncrypt, "requestUID": "encrypt-uid", "outcome": audit.OutcomeSuccess},
+		{"operation": kmsplugin.OperationDecrypt, "requestUID": "decrypt-uid", "outcome": audit.OutcomeFailure, "errorType": "user-induced", "errorCode": "DisabledException"},
+		{"operation": kmsplugin.OperationDecrypt, "requestUID": "empty-uid", "outcome": audit.OutcomeFailure, "errorType": "other", "errorCode": kmsplugin.ErrorCodeOther},
+	}
+	for i, line := range lines {
+		var got map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &got))
+		for k, v := range expected[i] {
+			assert.Equal(t, v, got[k], "event %d field %s", i, k)
+		}
+		assert.Equal(t, audit.SourceCaller, got["source"])
+		assert.Equal(t, GRPC_V2, got["apiVersion"])
+		assert.Equal(t, key, got["keyID"])
+		assert.Equal(t, []any{"app", "cluster"}, got["encryptionContextKeys"])
+		assert.Contains(t, got, "latencySeconds")
+	}
+}
+
+func TestAuditHealthCheck(t *testing.T) {
+	zap.ReplaceGlobals(zap.NewExample())
+
+	tt := []struct {
+		name       string
+		apiVersion string
+		health     func(c *cloud.KMSMock, sharedHealthCheck *SharedHealthCheck, auditLog *audit.Logger) error
+		operations []string
+	}{
+		{
+			name:       "v1",
+			apiVersion: GRPC_V1,
+			health: func(c *cloud.KMSMock, sharedHealthCheck *SharedHealthCheck, auditLog *audit.Logger) error {
+				return New(key, c, nil, sharedHealthCheck, newTestMetrics(t), auditLog).Health()
+			},
+			operations: []string{kmsplugin.OperationEncrypt},
+		},
+		{
+			name:       "v2",
+			apiVersion: GRPC_V2,
+			health: func(c *cloud.KMSMock, sharedHealthCheck *SharedHealthCheck, auditLog *audit.Logger) error {
+				return NewV2(key, c, nil, sharedHealthCheck, newTestMetrics(t), auditLog).Health()
+			},
+			operations: []string{kmsplugin.OperationEncrypt, kmsplugin.OperationDecrypt},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			auditLog := audit.NewWithCore(zapcore.NewCore(zapcore.NewJSONEncoder(zapcore.EncoderConfig{}), zapcore.AddSync(&buf), zapcore.InfoLevel))
+			c := &cloud.KMSMock{}
+			c.SetEncryptResp(encryptedMessage, nil)
+			c.SetDecryptResp(plainMessage, nil)
+			sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+			go sharedHealthCheck.Start()
+			defer sharedHealthCheck.Stop()
+
+			require.NoError(t, tc.health(c, sharedHealthCheck, auditLog))
+
+			// the operations of health checks are audited, but not as the
+			// operations of a caller
+			lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+			require.Len(t, lines, len(tc.operations))
+			for i, line := range lines {
+				var got map[string]any
+				require.NoError(t, json.Unmarshal([]byte(line), &got))
+				assert.Equal(t, tc.operations[i], got["operation"])
+				assert.Equal(t, tc.apiVersion, got["apiVersion"])
+				assert.Equal(t, audit.SourceHealthCheck, got["source"])
+				assert.Equal(t, audit.OutcomeSuccess, got["outcome"])
+			}
+		})
+	}
+}
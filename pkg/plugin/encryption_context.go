@@ -0,0 +1,88 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"encoding/csv"
+	"fmt"
+	"strings"
+)
+
+// ParseEncryptionContext parses an --encryption-context flag value, a comma
+// separated list of key=value pairs optionally enclosed in brackets
+func ParseEncryptionContext(val string) (map[string]string, error) {
+	pairs, err := encryptionContextPairs(val)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]string, len(pairs))
+	for _, kv := range pairs {
+		out[kv[0]] = kv[1]
+	}
+	return out, nil
+}
+
+func encryptionContextPairs(val string) ([][2]string, error) {
+	val = strings.Trim(val, "[]")
+	// An empty string would cause an empty map
+	if len(val) == 0 {
+		return nil, nil
+	}
+	r := csv.NewReader(strings.NewReader(val))
+	ss, err := r.Read()
+	if err != nil {
+		return nil, err
+	}
+	pairs := make([][2]string, 0, len(ss))
+	for _, pair := range ss {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("%s must be formatted as key=value", pair)
+		}
+		pairs = append(pairs, [2]string{kv[0], kv[1]})
+	}
+	return pairs, nil
+}
+
+// LintEncryptionContext checks an --encryption-context flag value, returning
+// the reasons KMS would reject it as errors, and what is likely a mistake as
+// warnings. Data can only be decrypted with the exact context it was
+// encrypted with, so a mistake is only noticed when decrypting.
+func LintEncryptionContext(val string) (errs []string, warnings []string) {
+	pairs, err := encryptionContextPairs(val)
+	if err != nil {
+		return []string{err.Error()}, nil
+	}
+	seen := map[string]bool{}
+	for _, kv := range pairs {
+		key, value := kv[0], kv[1]
+		switch {
+		case key == "":
+			errs = append(errs, fmt.Sprintf("key of %q is empty", key+"="+value))
+		case value == "":
+			errs = append(errs, fmt.Sprintf("value of key %q is empty", key))
+		}
+		if strings.HasPrefix(strings.ToLower(key), "aws:") {
+			errs = append(errs, fmt.Sprintf("key %q starts with aws:, which is reserved by AWS", key))
+		}
+		if key != strings.TrimSpace(key) || value != strings.TrimSpace(value) {
+			warnings = append(warnings, fmt.Sprintf("key %q or its value has leading or trailing spaces, which are part of the context", key))
+		}
+		if seen[key] {
+			warnings = append(warnings, fmt.Sprintf("key %q is set more than once, the last value is used", key))
+		}
+		seen[key] = true
+	}
+	return errs, warnings
+}
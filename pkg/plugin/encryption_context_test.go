@@ -0,0 +1,44 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLintEncryptionContext(t *testing.T) {
+	tt := []struct {
+		input    string
+		errs     []string
+		warnings []string
+	}{
+		{input: ""},
+		{input: "[cluster=prod,team=infra]"},
+		{input: "cluster", errs: []string{"cluster must be formatted as key=value"}},
+		{input: "=prod", errs: []string{`key of "=prod" is empty`}},
+		{input: "cluster=", errs: []string{`value of key "cluster" is empty`}},
+		{input: "aws:cluster=prod", errs: []string{`key "aws:cluster" starts with aws:, which is reserved by AWS`}},
+		{input: "cluster=prod, team=infra", warnings: []string{`key " team" or its value has leading or trailing spaces, which are part of the context`}},
+		{input: "cluster=prod,cluster=dev", warnings: []string{`key "cluster" is set more than once, the last value is used`}},
+	}
+	for _, tc := range tt {
+		t.Run(tc.input, func(t *testing.T) {
+			errs, warnings := LintEncryptionContext(tc.input)
+			assert.Equal(t, tc.errs, errs)
+			assert.Equal(t, tc.warnings, warnings)
+		})
+	}
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+	"sigs.k8s.io/aws-encryption-provider/pkg/logging"
+)
+
+const (
+	DefaultKeyStateCheckPeriod  = 5 * time.Minute
+	DefaultKeyStateCheckTimeout = 10 * time.Second
+)
+
+// KeyStateWatcher periodically describes the configured KMS keys and exports
+// their state, so that a disabled or scheduled-for-deletion key is noticed
+// before the apiserver starts failing to write Secrets.
+type KeyStateWatcher struct {
+	svc     cloud.AWSKMSv2
+	keyIDs  []string
+	period  time.Duration
+	metrics *Metrics
+
+	stopCloseOnce *sync.Once
+	stopc         chan struct{}
+	closed        chan struct{}
+}
+
+// NewKeyStateWatcher returns a new *KeyStateWatcher for the given keys. A nil
+// metrics uses DefaultMetrics.
+func NewKeyStateWatcher(keyIDs []string, svc cloud.AWSKMSv2, period time.Duration, metrics *Metrics) *KeyStateWatcher {
+	return &KeyStateWatcher{
+		svc:           svc,
+		keyIDs:        keyIDs,
+		period:        period,
+		metrics:       metricsOrDefault(metrics),
+		stopCloseOnce: new(sync.Once),
+		stopc:         make(chan struct{}),
+		closed:        make(chan struct{}),
+	}
+}
+
+// Start checks the key state immediately and then every period until Stop is called.
+func (w *KeyStateWatcher) Start() {
+	logging.L(logging.ComponentPlugin).Info("starting key state watcher", zap.String("period", w.period.String()), zap.Strings("keys", w.keyIDs))
+	ticker := time.NewTicker(w.period)
+	defer ticker.Stop()
+
+	w.checkAll()
+	for {
+		select {
+		case <-w.stopc:
+			logging.L(logging.ComponentPlugin).Warn("exiting key state watcher")
+			w.closed <- struct{}{}
+			return
+		case <-ticker.C:
+			w.checkAll()
+		}
+	}
+}
+
+func (w *KeyStateWatcher) Stop() {
+	w.stopCloseOnce.Do(func() {
+		close(w.stopc)
+		<-w.closed
+	})
+}
+
+func (w *KeyStateWatcher) checkAll() {
+	for _, keyID := range w.keyIDs {
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultKeyStateCheckTimeout)
+		w.check(ctx, keyID)
+		cancel()
+	}
+}
+
+// check describes a single key and records its state, returning the metadata
+// when DescribeKey succeeded.
+func (w *KeyStateWatcher) check(ctx context.Context, keyID string) *kmstypes.KeyMetadata {
+	ctx, attempts := cloud.WithAttemptCounter(ctx)
+	out, err := w.svc.DescribeKey(ctx, &kms.DescribeKeyInput{KeyId: aws.String(keyID)})
+	w.metrics.recordBillable(keyID, kmsplugin.OperationDescribeKey, UsageSourceKeyState, billableRequests(attempts))
+	if err != nil {
+		w.metrics.kmsKeyStateCheckFailureCounter.WithLabelValues(keyID).Inc()
+		logging.L(logging.ComponentPlugin).Warn("failed to describe key",
+			zap.String("key", keyID),
+			zap.String("error-type", kmsplugin.ParseError(err).String()),
+			zap.Error(err),
+		)
+		return nil
+	}
+	if out == nil || out.KeyMetadata == nil {
+		w.metrics.kmsKeyStateCheckFailureCounter.WithLabelValues(keyID).Inc()
+		logging.L(logging.ComponentPlugin).Warn("describe key returned no metadata", zap.String("key", keyID))
+		return nil
+	}
+	md := out.KeyMetadata
+
+	for _, state := range md.KeyState.Values() {
+		value := 0.0
+		if state == md.KeyState {
+			value = 1
+		}
+		w.metrics.kmsKeyStateMetric.WithLabelValues(keyID, string(state)).Set(value)
+	}
+
+	deletionTs := 0.0
+	if md.DeletionDate != nil {
+		deletionTs = float64(md.DeletionDate.Unix())
+	}
+	w.metrics.kmsKeyDeletionTimestampMetric.WithLabelValues(keyID).Set(deletionTs)
+
+	w.metrics.kmsKeyInfoMetric.DeletePartialMatch(prometheus.Labels{"key_arn": keyID})
+	w.metrics.kmsKeyInfoMetric.WithLabelValues(keyID, aws.ToString(md.Arn), string(md.KeyManager), string(md.KeySpec), string(md.KeyUsage)).Set(1)
+
+	w.checkRotation(ctx, keyID, md)
+	logKeyState(keyID, md)
+	return md
+}
+
+// checkRotation records the automatic rotation status. Rotation status is only
+// defined for symmetric keys and cannot be read once deletion is scheduled.
+func (w *KeyStateWatcher) checkRotation(ctx context.Context, keyID string, md *kmstypes.KeyMetadata) {
+	if md.KeySpec != kmstypes.KeySpecSymmetricDefault || md.KeyState == kmstypes.KeyStatePendingDeletion {
+		w.metrics.kmsKeyRotationEnabledMetric.DeleteLabelValues(keyID)
+		return
+	}
+	ctx, attempts := cloud.WithAttemptCounter(ctx)
+	out, err := w.svc.GetKeyRotationStatus(ctx, &kms.GetKeyRotationStatusInput{KeyId: aws.String(keyID)})
+	w.metrics.recordBillable(keyID, kmsplugin.OperationGetKeyRotationStatus, UsageSourceKeyState, billableRequests(attempts))
+	if err != nil || out == nil {
+		w.metrics.kmsKeyRotationEnabledMetric.DeleteLabelValues(keyID)
+		logging.L(logging.ComponentPlugin).Debug("failed to get key rotation status", zap.String("key", keyID), zap.Error(err))
+		return
+	}
+	enabled := 0.0
+	if out.KeyRotationEnabled {
+		enabled = 1
+	}
+	w.metrics.kmsKeyRotationEnabledMetric.WithLabelValues(keyID).Set(enabled)
+}
+
+func logKeyState(keyID string, md *kmstypes.KeyMetadata) {
+	switch md.KeyState {
+	case kmstypes.KeyStateEnabled:
+		logging.L(logging.ComponentPlugin).Debug("key is enabled", zap.String("key", keyID))
+	case kmstypes.KeyStatePendingDeletion, kmstypes.KeyStatePendingReplicaDeletion:
+		fields := []zap.Field{
+			zap.String("key", keyID),
+			zap.String("key-state", string(md.KeyState)),
+		}
+		if md.DeletionDate != nil {
+			fields = append(fields,
+				zap.Time("deletion-date", *md.DeletionDate),
+				zap.Duration("time-until-deletion", time.Until(*md.DeletionDate)),
+			)
+		}
+		logging.L(logging.ComponentPlugin).Warn("key is scheduled for deletion, encrypt and decrypt fail until the deletion is cancelled", fields...)
+	default:
+		logging.L(logging.ComponentPlugin).Warn("key is not enabled, encrypt and decrypt will fail",
+			zap.String("key", keyID),
+			zap.String("key-state", string(md.KeyState)),
+		)
+	}
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+)
+
+func TestKeyStateWatcher(t *testing.T) {
+	deletionDate := time.Now().Add(7 * 24 * time.Hour).Truncate(time.Second)
+
+	tt := []struct {
+		name            string
+		key             string
+		metadata        *kmstypes.KeyMetadata
+		describeErr     error
+		rotationEnabled bool
+		expectState     kmstypes.KeyState
+		expectDeletion  float64
+		expectRotation  float64
+		expectFailures  float64
+		expectWarning   string
+	}{
+		{
+			name: "enabled key with rotation",
+			key:  "key-state-enabled",
+			metadata: &kmstypes.KeyMetadata{
+				Arn:        aws.String("arn:aws:kms:us-west-2:111122223333:key/enabled"),
+				KeyState:   kmstypes.KeyStateEnabled,
+				KeyManager: kmstypes.KeyManagerTypeCustomer,
+				KeySpec:    kmstypes.KeySpecSymmetricDefault,
+				KeyUsage:   kmstypes.KeyUsageTypeEncryptDecrypt,
+			},
+			rotationEnabled: true,
+			expectState:     kmstypes.KeyStateEnabled,
+			expectRotation:  1,
+		},
+		{
+			name: "disabled key",
+			key:  "key-state-disabled",
+			metadata: &kmstypes.KeyMetadata{
+				KeyState: kmstypes.KeyStateDisabled,
+				KeySpec:  kmstypes.KeySpecSymmetricDefault,
+			},
+			expectState:   kmstypes.KeyStateDisabled,
+			expectWarning: "key is not enabled, encrypt and decrypt will fail",
+		},
+		{
+			name: "key pending deletion",
+			key:  "key-state-pending-deletion",
+			metadata: &kmstypes.KeyMetadata{
+				KeyState:     kmstypes.KeyStatePendingDeletion,
+				KeySpec:      kmstypes.KeySpecSymmetricDefault,
+				DeletionDate: &deletionDate,
+			},
+			expectState:    kmstypes.KeyStatePendingDeletion,
+			expectDeletion: float64(deletionDate.Unix()),
+			expectWarning:  "key is scheduled for deletion, encrypt and decrypt fail until the deletion is cancelled",
+		},
+		{
+			name:           "describe key failure",
+			key:            "key-state-failure",
+			describeErr:    &kmstypes.NotFoundException{Message: aws.String("test")},
+			expectFailures: 1,
+			expectWarning:  "failed to describe key",
+		},
+	}
+	for _, entry := range tt {
+		t.Run(entry.name, func(t *testing.T) {
+			core, logs := observer.New(zapcore.WarnLevel)
+			zap.ReplaceGlobals(zap.New(core))
+
+			c := &cloud.KMSMock{}
+			c.SetDescribeKeyResp(entry.metadata, entry.describeErr)
+			c.SetKeyRotationStatusResp(entry.rotationEnabled, nil)
+
+			metrics := newTestMetrics(t)
+			w := NewKeyStateWatcher([]string{entry.key}, c, DefaultKeyStateCheckPeriod, metrics)
+			md := w.check(context.Background(), entry.key)
+
+			assert.Equal(t, entry.expectFailures, testutil.ToFloat64(metrics.kmsKeyStateCheckFailureCounter.WithLabelValues(entry.key)))
+			if entry.describeErr != nil {
+				assert.Nil(t, md)
+			} else {
+				assert.Equal(t, 1.0, testutil.ToFloat64(metrics.kmsKeyStateMetric.WithLabelValues(entry.key, string(entry.expectState))))
+				assert.Equal(t, 0.0, testutil.ToFloat64(metrics.kmsKeyStateMetric.WithLabelValues(entry.key, string(kmstypes.KeyStateUnavailable))))
+				assert.Equal(t, entry.expectDeletion, testutil.ToFloat64(metrics.kmsKeyDeletionTimestampMetric.WithLabelValues(entry.key)))
+				if entry.expectState == kmstypes.KeyStateEnabled {
+					assert.Equal(t, entry.expectRotation, testutil.ToFloat64(metrics.kmsKeyRotationEnabledMetric.WithLabelValues(entry.key)))
+				}
+			}
+
+			if entry.expectWarning == "" {
+				assert.Equal(t, 0, logs.Len())
+			} else {
+				assert.Equal(t, 1, logs.FilterMessage(entry.expectWarning).Len())
+			}
+		})
+	}
+}
+
+func TestKeyStateWatcherStartStop(t *testing.T) {
+	zap.ReplaceGlobals(zap.NewExample())
+
+	c := &cloud.KMSMock{}
+	c.SetDescribeKeyResp(&kmstypes.KeyMetadata{KeyState: kmstypes.KeyStateEnabled}, nil)
+
+	w := NewKeyStateWatcher([]string{"key-state-start-stop"}, c, time.Hour, newTestMetrics(t))
+	done := make(chan struct{})
+	go func() {
+		w.Start()
+		close(done)
+	}()
+	w.Stop()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("key state watcher did not stop")
+	}
+}
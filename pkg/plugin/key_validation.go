@@ -0,0 +1,111 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+)
+
+const (
+	KeyValidationFail = "fail"
+	KeyValidationWarn = "warn"
+	KeyValidationNone = "none"
+)
+
+// KeyExpectations describes where the configured keys are expected to live.
+// Empty fields are not checked.
+type KeyExpectations struct {
+	Region  string
+	Account string
+}
+
+// KeyValidation is the result of checking that a key can be used by the plugin
+type KeyValidation struct {
+	KeyID    string
+	Metadata *kmstypes.KeyMetadata
+	// Err is set when the key could not be described at all
+	Err error
+	// Problems lists every reason the described key is unsuitable
+	Problems []string
+}
+
+// OK returns true if the key was described and is suitable for the plugin
+func (v KeyValidation) OK() bool {
+	return v.Err == nil && len(v.Problems) == 0
+}
+
+func (v KeyValidation) String() string {
+	switch {
+	case v.Err != nil:
+		return fmt.Sprintf("key %q: failed to describe key: %v", v.KeyID, v.Err)
+	case len(v.Problems) > 0:
+		return fmt.Sprintf("key %q: %s", v.KeyID, strings.Join(v.Problems, "; "))
+	default:
+		return fmt.Sprintf("key %q: ok", v.KeyID)
+	}
+}
+
+// ValidateKeys validates each key in order
+func ValidateKeys(ctx context.Context, svc cloud.AWSKMSv2, keyIDs []string, expect KeyExpectations) []KeyValidation {
+	results := make([]KeyValidation, 0, len(keyIDs))
+	for _, keyID := range keyIDs {
+		results = append(results, ValidateKey(ctx, svc, keyID, expect))
+	}
+	return results
+}
+
+// ValidateKey describes the key and checks that it is an enabled symmetric
+// encryption key in the expected region and account.
+func ValidateKey(ctx context.Context, svc cloud.AWSKMSv2, keyID string, expect KeyExpectations) KeyValidation {
+	v := KeyValidation{KeyID: keyID}
+
+	out, err := svc.DescribeKey(ctx, &kms.DescribeKeyInput{KeyId: aws.String(keyID)})
+	if err != nil {
+		v.Err = err
+		return v
+	}
+	if out == nil || out.KeyMetadata == nil {
+		v.Err = fmt.Errorf("no key metadata returned")
+		return v
+	}
+	md := out.KeyMetadata
+	v.Metadata = md
+
+	if md.KeySpec != kmstypes.KeySpecSymmetricDefault {
+		v.Problems = append(v.Problems, fmt.Sprintf("key spec is %s, expected %s", md.KeySpec, kmstypes.KeySpecSymmetricDefault))
+	}
+	if md.KeyUsage != kmstypes.KeyUsageTypeEncryptDecrypt {
+		v.Problems = append(v.Problems, fmt.Sprintf("key usage is %s, expected %s", md.KeyUsage, kmstypes.KeyUsageTypeEncryptDecrypt))
+	}
+	if !md.Enabled || md.KeyState != kmstypes.KeyStateEnabled {
+		v.Problems = append(v.Problems, fmt.Sprintf("key state is %s, expected %s", md.KeyState, kmstypes.KeyStateEnabled))
+	}
+	if expect.Region != "" && md.Arn != nil {
+		if parsed, err := arn.Parse(aws.ToString(md.Arn)); err == nil && parsed.Region != expect.Region {
+			v.Problems = append(v.Problems, fmt.Sprintf("key is in region %s, expected %s", parsed.Region, expect.Region))
+		}
+	}
+	if expect.Account != "" && md.AWSAccountId != nil && aws.ToString(md.AWSAccountId) != expect.Account {
+		v.Problems = append(v.Problems, fmt.Sprintf("key is owned by account %s, expected %s", aws.ToString(md.AWSAccountId), expect.Account))
+	}
+	return v
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/stretchr/testify/assert"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+)
+
+func validKeyMetadata() *kmstypes.KeyMetadata {
+	return &kmstypes.KeyMetadata{
+		Arn:          aws.String("arn:aws:kms:us-west-2:111122223333:key/1234abcd-12ab-34cd-56ef-1234567890ab"),
+		AWSAccountId: aws.String("111122223333"),
+		Enabled:      true,
+		KeyState:     kmstypes.KeyStateEnabled,
+		KeySpec:      kmstypes.KeySpecSymmetricDefault,
+		KeyUsage:     kmstypes.KeyUsageTypeEncryptDecrypt,
+	}
+}
+
+func TestValidateKey(t *testing.T) {
+	expect := KeyExpectations{Region: "us-west-2", Account: "111122223333"}
+
+	tt := []struct {
+		name           string
+		mutate         func(md *kmstypes.KeyMetadata)
+		describeErr    error
+		expect         KeyExpectations
+		expectOK       bool
+		expectProblems []string
+	}{
+		{
+			name:     "suitable key",
+			mutate:   func(md *kmstypes.KeyMetadata) {},
+			expect:   expect,
+			expectOK: true,
+		},
+		{
+			name: "asymmetric key",
+			mutate: func(md *kmstypes.KeyMetadata) {
+				md.KeySpec = kmstypes.KeySpecRsa2048
+			},
+			expect:         expect,
+			expectProblems: []string{"key spec is RSA_2048, expected SYMMETRIC_DEFAULT"},
+		},
+		{
+			name: "hmac key",
+			mutate: func(md *kmstypes.KeyMetadata) {
+				md.KeySpec = kmstypes.KeySpecHmac256
+				md.KeyUsage = kmstypes.KeyUsageTypeGenerateVerifyMac
+			},
+			expect: expect,
+			expectProblems: []string{
+				"key spec is HMAC_256, expected SYMMETRIC_DEFAULT",
+				"key usage is GENERATE_VERIFY_MAC, expected ENCRYPT_DECRYPT",
+			},
+		},
+		{
+			name: "disabled key",
+			mutate: func(md *kmstypes.KeyMetadata) {
+				md.Enabled = false
+				md.KeyState = kmstypes.KeyStateDisabled
+			},
+			expect:         expect,
+			expectProblems: []string{"key state is Disabled, expected Enabled"},
+		},
+		{
+			name:           "wrong region and account",
+			mutate:         func(md *kmstypes.KeyMetadata) {},
+			expect:         KeyExpectations{Region: "eu-west-1", Account: "444455556666"},
+			expectProblems: []string{"key is in region us-west-2, expected eu-west-1", "key is owned by account 111122223333, expected 444455556666"},
+		},
+		{
+			name:     "no expectations",
+			mutate:   func(md *kmstypes.KeyMetadata) {},
+			expectOK: true,
+		},
+		{
+			name:        "describe failure",
+			describeErr: &kmstypes.NotFoundException{Message: aws.String("test")},
+			expect:      expect,
+		},
+	}
+	for _, entry := range tt {
+		t.Run(entry.name, func(t *testing.T) {
+			c := &cloud.KMSMock{}
+			if entry.describeErr != nil {
+				c.SetDescribeKeyResp(nil, entry.describeErr)
+			} else {
+				md := validKeyMetadata()
+				entry.mutate(md)
+				c.SetDescribeKeyResp(md, nil)
+			}
+
+			v := ValidateKey(context.Background(), c, "test-key", entry.expect)
+			assert.Equal(t, entry.expectOK, v.OK(), v.String())
+			assert.Equal(t, entry.expectProblems, v.Problems)
+			if entry.describeErr != nil {
+				assert.ErrorIs(t, v.Err, entry.describeErr)
+				assert.Contains(t, v.String(), "failed to describe key")
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+	"sigs.k8s.io/aws-encryption-provider/pkg/server"
+)
+
+// LocalServer serves the v1 and v2 plugins of a key on a unix socket in a
+// temporary directory, for clients running in the same process such as
+// kmsctl and the tests of the clients
+type LocalServer struct {
+	// Addr is the path of the socket
+	Addr string
+
+	dir         string
+	server      *server.Server
+	healthCheck *SharedHealthCheck
+}
+
+// LocalServerOptions configures the plugins of a LocalServer
+type LocalServerOptions struct {
+	EncryptionContext map[string]string
+	// V1Only serves the v1 plugin alone, like releases before the v2 API
+	V1Only bool
+	// Interceptor is called on every call to the plugins if set
+	Interceptor grpc.UnaryServerInterceptor
+}
+
+// NewLocalServer registers the plugins of key backed by c, Start serves them
+func NewLocalServer(key string, c cloud.AWSKMSv2, opts LocalServerOptions) (*LocalServer, error) {
+	// unix socket paths are limited to about 100 bytes, keep it short
+	dir, err := os.MkdirTemp("", "kms")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create socket directory: %v", err)
+	}
+	// the metrics of each server are registered apart, several may run at once
+	metrics, err := NewMetrics(MetricsOpts{Registerer: prometheus.NewRegistry()})
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, err
+	}
+
+	var serverOpts []grpc.ServerOption
+	if opts.Interceptor != nil {
+		serverOpts = append(serverOpts, grpc.ChainUnaryInterceptor(opts.Interceptor))
+	}
+	s := &LocalServer{
+		Addr:        filepath.Join(dir, "kms.sock"),
+		dir:         dir,
+		server:      server.New(serverOpts...),
+		healthCheck: NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize),
+	}
+	go s.healthCheck.Start()
+	New(key, c, opts.EncryptionContext, s.healthCheck, metrics, nil).Register(s.server.Server)
+	if !opts.V1Only {
+		NewV2(key, c, opts.EncryptionContext, s.healthCheck, metrics, nil).Register(s.server.Server)
+	}
+	return s, nil
+}
+
+// Start listens on the socket and serves the plugins until Stop, calls may be
+// made once it returns
+func (s *LocalServer) Start() error {
+	l, err := server.Listen(s.Addr)
+	if err != nil {
+		return err
+	}
+	go func() { _ = s.server.Serve(l) }()
+	return nil
+}
+
+// Stop stops serving the plugins and removes the socket
+func (s *LocalServer) Stop() {
+	s.server.Stop()
+	s.healthCheck.Stop()
+	_ = os.RemoveAll(s.dir)
+}
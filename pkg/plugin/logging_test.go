@@ -0,0 +1,122 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	pb1 "k8s.io/kms/apis/v1beta1"
+	pb "k8s.io/kms/apis/v2"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+	"sigs.k8s.io/aws-encryption-provider/pkg/logging"
+)
+
+// TestLogsNeverContainSecrets runs successful and failed operations of both
+// plugins with debug logging and checks that plaintext and ciphertext never
+// reach the logs in any encoding, and encryption context values do not either
+// under the default redaction policy.
+func TestLogsNeverContainSecrets(t *testing.T) {
+	const (
+		plaintext  = "top-secret-plaintext"
+		ciphertext = "opaque-kms-ciphertext"
+		ctxValue   = "tenant-acme-corp"
+	)
+	secrets := func(s string) []string {
+		return []string{
+			s,
+			base64.StdEncoding.EncodeToString([]byte(s)),
+			hex.EncodeToString([]byte(s)),
+			fmt.Sprintf("%v", []byte(s)),
+		}
+	}
+
+	for _, redact := range []bool{false, true} {
+		t.Run(fmt.Sprintf("redaction=%v", redact), func(t *testing.T) {
+			var buf bytes.Buffer
+			core := zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.AddSync(&buf), zapcore.DebugLevel)
+			if redact {
+				core = logging.NewRedactingCore(core, logging.DefaultOptions().Redaction)
+			}
+			zap.ReplaceGlobals(zap.New(core))
+			defer zap.ReplaceGlobals(zap.NewExample())
+
+			c := &cloud.KMSMock{}
+			sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+			go sharedHealthCheck.Start()
+			defer sharedHealthCheck.Stop()
+			encryptionCtx := map[string]string{"tenant": ctxValue}
+			p1 := New(key, c, encryptionCtx, sharedHealthCheck, newTestMetrics(t), nil)
+			p2 := NewV2(key, c, encryptionCtx, sharedHealthCheck, newTestMetrics(t), nil)
+			ctx := context.Background()
+
+			c.SetEncryptResp(ciphertext, nil)
+			c.SetDecryptResp(plaintext, nil)
+			if _, err := p1.Encrypt(ctx, &pb1.EncryptRequest{Plain: []byte(plaintext)}); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if _, err := p1.Decrypt(ctx, &pb1.DecryptRequest{Cipher: []byte(kmsplugin.StorageVersion + ciphertext)}); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if _, err := p2.Encrypt(ctx, &pb.EncryptRequest{Plaintext: []byte(plaintext), Uid: "uid"}); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if _, err := p2.Decrypt(ctx, &pb.DecryptRequest{Ciphertext: []byte(string(kmsplugin.KMSStorageVersionV2) + ciphertext), Uid: "uid"}); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			c.SetEncryptResp("", &kmstypes.DisabledException{Message: aws.String("key disabled")})
+			c.SetDecryptResp("", &kmstypes.InvalidCiphertextException{Message: aws.String("invalid ciphertext")})
+			if _, err := p1.Encrypt(ctx, &pb1.EncryptRequest{Plain: []byte(plaintext)}); err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if _, err := p1.Decrypt(ctx, &pb1.DecryptRequest{Cipher: []byte(kmsplugin.StorageVersion + ciphertext)}); err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if _, err := p2.Encrypt(ctx, &pb.EncryptRequest{Plaintext: []byte(plaintext), Uid: "uid"}); err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if _, err := p2.Decrypt(ctx, &pb.DecryptRequest{Ciphertext: []byte(string(kmsplugin.KMSStorageVersionV2) + ciphertext), Uid: "uid"}); err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if _, err := p2.Decrypt(ctx, &pb.DecryptRequest{Ciphertext: []byte("9" + ciphertext), Uid: "uid"}); err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			_ = zap.L().Sync()
+
+			out := buf.String()
+			if !strings.Contains(out, "configuring encryption context") || !strings.Contains(out, "request to decrypt failed") {
+				t.Fatalf("expected debug and error logs, got %s", out)
+			}
+			for _, secret := range append(secrets(plaintext), secrets(ciphertext)...) {
+				if strings.Contains(out, secret) {
+					t.Fatalf("logs contain %q: %s", secret, out)
+				}
+			}
+			if redact && strings.Contains(out, ctxValue) {
+				t.Fatalf("logs contain encryption context value: %s", out)
+			}
+		})
+	}
+}
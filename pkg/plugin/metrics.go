@@ -1,21 +1,113 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
 package plugin
 
-import "github.com/prometheus/client_golang/prometheus"
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+	"sigs.k8s.io/aws-encryption-provider/pkg/logging"
+	"sigs.k8s.io/aws-encryption-provider/pkg/tracing"
+	"sigs.k8s.io/aws-encryption-provider/pkg/version"
+)
+
+// DefaultMetricsNamespace prefixes the name of every metric
+const DefaultMetricsNamespace = "aws_encryption_provider"
 
-func init() {
-	registerPrometheusMetrics()
+// MetricsOpts configures Metrics
+type MetricsOpts struct {
+	// Registerer the metrics are registered with, prometheus.DefaultRegisterer if nil
+	Registerer prometheus.Registerer
+	// Namespace prefixes the name of every metric, DefaultMetricsNamespace if empty
+	Namespace string
+	// ConstLabels are added to every metric
+	ConstLabels prometheus.Labels
 }
 
-func registerPrometheusMetrics() {
-	prometheus.MustRegister(kmsOperationCounter)
-	prometheus.MustRegister(kmsLatencyMetric)
+// Metrics holds the Prometheus metrics exported by plugins, health checks and
+// key state watchers. A single *Metrics is typically shared by every plugin in
+// the process.
+type Metrics struct {
+	kmsOperationCounter            *prometheus.CounterVec
+	kmsLatencyMetric               *prometheus.HistogramVec
+	kmsDurationMetric              *prometheus.HistogramVec
+	kmsErrorCounter                *prometheus.CounterVec
+	kmsInFlightMetric              *prometheus.GaugeVec
+	kmsAttemptsMetric              *prometheus.HistogramVec
+	kmsPlaintextSizeMetric         *prometheus.HistogramVec
+	kmsCiphertextSizeMetric        *prometheus.HistogramVec
+	kmsKeyStateMetric              *prometheus.GaugeVec
+	kmsKeyDeletionTimestampMetric  *prometheus.GaugeVec
+	kmsKeyInfoMetric               *prometheus.GaugeVec
+	kmsKeyRotationEnabledMetric    *prometheus.GaugeVec
+	kmsKeyStateCheckFailureCounter *prometheus.CounterVec
+	kmsDegradedMetric              *prometheus.GaugeVec
+	healthCheckCounter             *prometheus.CounterVec
+	healthCheckDurationMetric      *prometheus.HistogramVec
+	healthCheckStateMetric         *prometheus.GaugeVec
+	healthCheckLastSuccessMetric   *prometheus.GaugeVec
+	healthCheckLastFailureMetric   *prometheus.GaugeVec
+	buildInfoMetric                *prometheus.GaugeVec
+	kmsBillableRequestsCounter     *prometheus.CounterVec
+	usage                          *usage
 }
 
 var (
-	kmsOperationCounter = prometheus.NewCounterVec(
+	defaultMetrics     *Metrics
+	defaultMetricsOnce sync.Once
+)
+
+// DefaultMetrics returns the Metrics registered with the default Prometheus
+// registerer, registering them on first use. It is used when a nil *Metrics is
+// passed to New, NewV2 or NewKeyStateWatcher.
+func DefaultMetrics() *Metrics {
+	defaultMetricsOnce.Do(func() {
+		m, err := NewMetrics(MetricsOpts{})
+		if err != nil {
+			panic(err)
+		}
+		defaultMetrics = m
+	})
+	return defaultMetrics
+}
+
+// NewMetrics creates the plugin metrics and registers them with
+// opts.Registerer. It returns an error if any of them is already registered.
+func NewMetrics(opts MetricsOpts) (*Metrics, error) {
+	if opts.Registerer == nil {
+		opts.Registerer = prometheus.DefaultRegisterer
+	}
+	if opts.Namespace == "" {
+		opts.Namespace = DefaultMetricsNamespace
+	}
+
+	m := &Metrics{usage: newUsage(time.Now)}
+
+	m.kmsOperationCounter = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
-			Name: "aws_encryption_provider_kms_operations_total",
-			Help: "total aws encryption provider kms operations",
+			Namespace:   opts.Namespace,
+			ConstLabels: opts.ConstLabels,
+			Name:        "kms_operations_total",
+			Help:        "total aws encryption provider kms operations",
 		},
 		[]string{
 			"key_arn",
@@ -25,11 +117,13 @@ var (
 		},
 	)
 
-	kmsLatencyMetric = prometheus.NewHistogramVec(
+	m.kmsLatencyMetric = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
-			Name:    "aws_encryption_provider_kms_operation_latency_ms",
-			Help:    "Response latency in milliseconds for aws encryption provider kms operation ",
-			Buckets: prometheus.ExponentialBuckets(2, 2, 14),
+			Namespace:   opts.Namespace,
+			ConstLabels: opts.ConstLabels,
+			Name:        "kms_operation_latency_ms",
+			Help:        "Deprecated: use kms_operation_duration_seconds. Response latency in milliseconds for aws encryption provider kms operation ",
+			Buckets:     prometheus.ExponentialBuckets(2, 2, 14),
 		},
 		[]string{
 			"key_arn",
@@ -38,4 +132,424 @@ var (
 			"version",
 		},
 	)
-)
+
+	m.kmsDurationMetric = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace:   opts.Namespace,
+			ConstLabels: opts.ConstLabels,
+			Name:        "kms_operation_duration_seconds",
+			Help:        "Duration in seconds of KMS operations, including retries",
+			Buckets:     prometheus.ExponentialBuckets(0.002, 2, 14),
+		},
+		[]string{
+			"key_arn",
+			"status",
+			"operation",
+			"version",
+		},
+	)
+
+	m.kmsErrorCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   opts.Namespace,
+			ConstLabels: opts.ConstLabels,
+			Name:        "kms_operation_errors_total",
+			Help:        "total failed KMS operations by error type and AWS error code",
+		},
+		[]string{
+			"key_arn",
+			"operation",
+			"version",
+			"error_type",
+			"error_code",
+		},
+	)
+
+	m.kmsInFlightMetric = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   opts.Namespace,
+			ConstLabels: opts.ConstLabels,
+			Name:        "kms_operations_in_flight",
+			Help:        "Number of KMS operations in progress",
+		},
+		[]string{
+			"key_arn",
+			"operation",
+			"version",
+		},
+	)
+
+	m.kmsAttemptsMetric = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace:   opts.Namespace,
+			ConstLabels: opts.ConstLabels,
+			Name:        "kms_operation_attempts",
+			Help:        "Number of attempts made by the AWS SDK for a KMS operation, 1 when the operation was not retried",
+			Buckets:     prometheus.LinearBuckets(1, 1, 5),
+		},
+		[]string{
+			"key_arn",
+			"status",
+			"operation",
+			"version",
+		},
+	)
+
+	m.kmsPlaintextSizeMetric = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace:   opts.Namespace,
+			ConstLabels: opts.ConstLabels,
+			Name:        "kms_plaintext_size_bytes",
+			Help:        "Size in bytes of plaintexts encrypted or decrypted by KMS",
+			Buckets:     prometheus.ExponentialBuckets(16, 2, 10),
+		},
+		[]string{
+			"key_arn",
+			"operation",
+			"version",
+		},
+	)
+
+	m.kmsCiphertextSizeMetric = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace:   opts.Namespace,
+			ConstLabels: opts.ConstLabels,
+			Name:        "kms_ciphertext_size_bytes",
+			Help:        "Size in bytes of ciphertexts returned or decrypted by KMS",
+			Buckets:     prometheus.ExponentialBuckets(16, 2, 10),
+		},
+		[]string{
+			"key_arn",
+			"operation",
+			"version",
+		},
+	)
+
+	m.kmsKeyStateMetric = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   opts.Namespace,
+			ConstLabels: opts.ConstLabels,
+			Name:        "kms_key_state",
+			Help:        "KMS key state as reported by DescribeKey, 1 for the current state and 0 otherwise",
+		},
+		[]string{
+			"key_arn",
+			"state",
+		},
+	)
+
+	m.kmsKeyDeletionTimestampMetric = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   opts.Namespace,
+			ConstLabels: opts.ConstLabels,
+			Name:        "kms_key_deletion_timestamp_seconds",
+			Help:        "Unix time at which the KMS key is scheduled to be deleted, 0 if no deletion is scheduled",
+		},
+		[]string{
+			"key_arn",
+		},
+	)
+
+	m.kmsKeyInfoMetric = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   opts.Namespace,
+			ConstLabels: opts.ConstLabels,
+			Name:        "kms_key_info",
+			Help:        "KMS key metadata as reported by DescribeKey",
+		},
+		[]string{
+			"key_arn",
+			"arn",
+			"key_manager",
+			"key_spec",
+			"key_usage",
+		},
+	)
+
+	m.kmsKeyRotationEnabledMetric = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   opts.Namespace,
+			ConstLabels: opts.ConstLabels,
+			Name:        "kms_key_rotation_enabled",
+			Help:        "1 if automatic rotation is enabled for the KMS key, 0 otherwise",
+		},
+		[]string{
+			"key_arn",
+		},
+	)
+
+	m.kmsKeyStateCheckFailureCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   opts.Namespace,
+			ConstLabels: opts.ConstLabels,
+			Name:        "kms_key_state_check_failures_total",
+			Help:        "total failed attempts to describe the KMS key state",
+		},
+		[]string{
+			"key_arn",
+		},
+	)
+
+	m.kmsDegradedMetric = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   opts.Namespace,
+			ConstLabels: opts.ConstLabels,
+			Name:        "kms_degraded",
+			Help:        "1 if the last status check for the key failed for the given reason, 0 otherwise",
+		},
+		[]string{
+			"key_arn",
+			"reason",
+		},
+	)
+
+	m.healthCheckCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   opts.Namespace,
+			ConstLabels: opts.ConstLabels,
+			Name:        "health_checks_total",
+			Help:        "total health checks, by whether they were answered from the cached result or by calling KMS",
+		},
+		[]string{
+			"key_arn",
+			"version",
+			"source",
+			"result",
+			"error_type",
+		},
+	)
+
+	m.healthCheckDurationMetric = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace:   opts.Namespace,
+			ConstLabels: opts.ConstLabels,
+			Name:        "health_check_duration_seconds",
+			Help:        "Duration in seconds of health checks",
+			Buckets:     prometheus.ExponentialBuckets(0.001, 2, 14),
+		},
+		[]string{
+			"key_arn",
+			"version",
+			"source",
+		},
+	)
+
+	m.healthCheckStateMetric = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   opts.Namespace,
+			ConstLabels: opts.ConstLabels,
+			Name:        "health_check_healthy",
+			Help:        "Current health of the key, 0 after a failed KMS call or health check and 1 after a successful health check",
+		},
+		[]string{
+			"key_arn",
+		},
+	)
+
+	m.healthCheckLastSuccessMetric = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   opts.Namespace,
+			ConstLabels: opts.ConstLabels,
+			Name:        "health_check_last_success_timestamp_seconds",
+			Help:        "Unix time of the last successful health check for the key",
+		},
+		[]string{
+			"key_arn",
+		},
+	)
+
+	m.healthCheckLastFailureMetric = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   opts.Namespace,
+			ConstLabels: opts.ConstLabels,
+			Name:        "health_check_last_failure_timestamp_seconds",
+			Help:        "Unix time of the last failed KMS call made with the key",
+		},
+		[]string{
+			"key_arn",
+		},
+	)
+
+	m.kmsBillableRequestsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   opts.Namespace,
+			ConstLabels: opts.ConstLabels,
+			Name:        "kms_billable_requests_total",
+			Help:        "total requests sent to the KMS API, including retries, by whether they served a caller, a health check or the key state watcher",
+		},
+		[]string{
+			"key_arn",
+			"operation",
+			"source",
+		},
+	)
+
+	m.buildInfoMetric = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   opts.Namespace,
+			ConstLabels: opts.ConstLabels,
+			Name:        "build_info",
+			Help:        "Always 1, labeled with the version, commit and Go version of the build and the supported KMS API versions",
+		},
+		[]string{
+			"version",
+			"commit",
+			"go_version",
+			"api_versions",
+		},
+	)
+	info := version.Get()
+	m.buildInfoMetric.WithLabelValues(info.Version, info.Commit, info.GoVersion, strings.Join(info.APIVersions, ",")).Set(1)
+
+	for _, c := range []prometheus.Collector{
+		m.kmsOperationCounter,
+		m.kmsLatencyMetric,
+		m.kmsDurationMetric,
+		m.kmsErrorCounter,
+		m.kmsInFlightMetric,
+		m.kmsAttemptsMetric,
+		m.kmsPlaintextSizeMetric,
+		m.kmsCiphertextSizeMetric,
+		m.kmsKeyStateMetric,
+		m.kmsKeyDeletionTimestampMetric,
+		m.kmsKeyInfoMetric,
+		m.kmsKeyRotationEnabledMetric,
+		m.kmsKeyStateCheckFailureCounter,
+		m.kmsDegradedMetric,
+		m.healthCheckCounter,
+		m.healthCheckDurationMetric,
+		m.healthCheckStateMetric,
+		m.healthCheckLastSuccessMetric,
+		m.healthCheckLastFailureMetric,
+		m.buildInfoMetric,
+		m.kmsBillableRequestsCounter,
+	} {
+		if err := opts.Registerer.Register(c); err != nil {
+			return nil, fmt.Errorf("failed to register metrics: %w", err)
+		}
+	}
+	return m, nil
+}
+
+func metricsOrDefault(m *Metrics) *Metrics {
+	if m == nil {
+		return DefaultMetrics()
+	}
+	return m
+}
+
+// kmsOperation tracks an Encrypt or Decrypt call made to KMS
+type kmsOperation struct {
+	m         *Metrics
+	keyID     string
+	operation string
+	version   string
+	source    string
+	startTime time.Time
+	attempts  *atomic.Int32
+	exemplar  prometheus.Labels
+}
+
+// startKMSOperation marks a KMS call as in flight. The returned context must be
+// passed to the KMS client so that SDK attempts are counted.
+func (m *Metrics) startKMSOperation(ctx context.Context, keyID, operation, version string) (context.Context, *kmsOperation) {
+	ctx, attempts := cloud.WithAttemptCounter(ctx)
+	m.kmsInFlightMetric.WithLabelValues(keyID, operation, version).Inc()
+	return ctx, &kmsOperation{
+		m:         m,
+		keyID:     keyID,
+		operation: operation,
+		version:   version,
+		source:    usageSource(ctx),
+		startTime: time.Now(),
+		attempts:  attempts,
+		exemplar:  exemplar(ctx),
+	}
+}
+
+// exemplar returns the request UID and trace ID carried by ctx as exemplar
+// labels, so that a slow KMS call seen in a histogram can be traced back to
+// its request. It returns nil if there are none or they exceed the size allowed
+// for an exemplar.
+func exemplar(ctx context.Context) prometheus.Labels {
+	labels := prometheus.Labels{}
+	if uid := logging.RequestUIDFromContext(ctx); uid != "" {
+		labels["request_uid"] = uid
+	}
+	if traceID := tracing.TraceID(ctx); traceID != "" {
+		labels["trace_id"] = traceID
+	}
+	runes := 0
+	for k, v := range labels {
+		runes += utf8.RuneCountInString(k) + utf8.RuneCountInString(v)
+	}
+	if len(labels) == 0 || runes > prometheus.ExemplarMaxRunes {
+		return nil
+	}
+	return labels
+}
+
+// observe records v in o, with the exemplar if there is one
+func observe(o prometheus.Observer, v float64, exemplar prometheus.Labels) {
+	if eo, ok := o.(prometheus.ExemplarObserver); ok && exemplar != nil {
+		eo.ObserveWithExemplar(v, exemplar)
+		return
+	}
+	o.Observe(v)
+}
+
+// done records the outcome of the call, err being the error returned by KMS
+func (o *kmsOperation) done(err error) {
+	m := o.m
+	m.kmsInFlightMetric.WithLabelValues(o.keyID, o.operation, o.version).Dec()
+
+	errorType := kmsplugin.ParseError(err).String()
+	status := kmsplugin.GetStatusLabel(err, errorType)
+	m.kmsOperationCounter.WithLabelValues(o.keyID, status, o.operation, o.version).Inc()
+	observe(m.kmsLatencyMetric.WithLabelValues(o.keyID, status, o.operation, o.version), kmsplugin.GetMillisecondsSince(o.startTime), o.exemplar)
+	observe(m.kmsDurationMetric.WithLabelValues(o.keyID, status, o.operation, o.version), time.Since(o.startTime).Seconds(), o.exemplar)
+	// clients that do not go through the SDK middleware stack (e.g. mocks) make no attempts
+	if attempts := o.attempts.Load(); attempts > 0 {
+		m.kmsAttemptsMetric.WithLabelValues(o.keyID, status, o.operation, o.version).Observe(float64(attempts))
+	}
+	if err != nil {
+		m.kmsErrorCounter.WithLabelValues(o.keyID, o.operation, o.version, errorType, kmsplugin.ParseErrorCode(err)).Inc()
+	}
+	m.recordBillable(o.keyID, o.operation, o.source, billableRequests(o.attempts))
+}
+
+// recordBillable counts n requests sent to KMS
+func (m *Metrics) recordBillable(keyID, operation, source string, n int) {
+	m.kmsBillableRequestsCounter.WithLabelValues(keyID, operation, source).Add(float64(n))
+	m.usage.add(keyID, operation, source, n)
+}
+
+// observeSizes records the plaintext and ciphertext sizes of a successful call
+func (o *kmsOperation) observeSizes(plaintext, ciphertext int) {
+	o.m.kmsPlaintextSizeMetric.WithLabelValues(o.keyID, o.operation, o.version).Observe(float64(plaintext))
+	o.m.kmsCiphertextSizeMetric.WithLabelValues(o.keyID, o.operation, o.version).Observe(float64(ciphertext))
+}
+
+// recordHealth records the outcome of a KMS call made with the key as its
+// current health, along with the time of the last success or failure.
+func (m *Metrics) recordHealth(keyID string, err error) {
+	now := float64(time.Now().Unix())
+	if err != nil {
+		m.healthCheckStateMetric.WithLabelValues(keyID).Set(0)
+		m.healthCheckLastFailureMetric.WithLabelValues(keyID).Set(now)
+		return
+	}
+	m.healthCheckStateMetric.WithLabelValues(keyID).Set(1)
+	m.healthCheckLastSuccessMetric.WithLabelValues(keyID).Set(now)
+}
+
+// observeHealthCheck records a Health call, whether it was answered from the
+// cached outcome or by calling KMS.
+func (m *Metrics) observeHealthCheck(keyID, version, source string, err error, startTime time.Time) {
+	result := kmsplugin.StatusSuccess
+	if err != nil {
+		result = kmsplugin.StatusFailure
+	}
+	m.healthCheckCounter.WithLabelValues(keyID, version, source, result, kmsplugin.ParseError(err).String()).Inc()
+	m.healthCheckDurationMetric.WithLabelValues(keyID, version, source).Observe(time.Since(startTime).Seconds())
+}
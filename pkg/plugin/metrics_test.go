@@ -10,19 +10,33 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"go.uber.org/zap"
 	pb "k8s.io/kms/apis/v1beta1"
+	pb2 "k8s.io/kms/apis/v2"
 	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
 	"sigs.k8s.io/aws-encryption-provider/pkg/server"
 )
 
+// newTestMetrics returns Metrics registered with a registry private to the test
+func newTestMetrics(t *testing.T) *Metrics {
+	t.Helper()
+	m, err := NewMetrics(MetricsOpts{Registerer: prometheus.NewRegistry()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
 // TestMetrics tests /metrics handler.
 func TestMetrics(t *testing.T) {
 	zap.ReplaceGlobals(zap.NewExample())
@@ -53,7 +67,12 @@ func TestMetrics(t *testing.T) {
 			sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
 			go sharedHealthCheck.Start()
 			defer sharedHealthCheck.Stop()
-			p := New(entry.key, c, nil, sharedHealthCheck)
+			reg := prometheus.NewRegistry()
+			metrics, err := NewMetrics(MetricsOpts{Registerer: reg})
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := New(entry.key, c, nil, sharedHealthCheck, metrics, nil)
 
 			ready, errc := make(chan struct{}), make(chan error)
 			s := server.New()
@@ -78,14 +97,14 @@ func TestMetrics(t *testing.T) {
 			}
 
 			mux := http.NewServeMux()
-			mux.Handle("/metrics", promhttp.Handler())
+			mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
 
 			ts := httptest.NewServer(mux)
 			defer ts.Close()
 
 			u := ts.URL + "/metrics"
 
-			_, err := p.Encrypt(context.Background(), &pb.EncryptRequest{Plain: []byte("hello")})
+			_, err = p.Encrypt(context.Background(), &pb.EncryptRequest{Plain: []byte("hello")})
 			if err != nil {
 				if entry.encryptErr == nil {
 					t.Fatal(err)
@@ -110,3 +129,99 @@ func TestMetrics(t *testing.T) {
 		})
 	}
 }
+
+func TestNewMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := NewMetrics(MetricsOpts{
+		Registerer:  reg,
+		Namespace:   "kms_plugin",
+		ConstLabels: prometheus.Labels{"cluster": "test"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, op := m.startKMSOperation(context.Background(), "test-key", "encrypt", GRPC_V1)
+	op.done(nil)
+
+	expected := `
+# HELP kms_plugin_kms_operations_total total aws encryption provider kms operations
+# TYPE kms_plugin_kms_operations_total counter
+kms_plugin_kms_operations_total{cluster="test",key_arn="test-key",operation="encrypt",status="success",version="v1"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "kms_plugin_kms_operations_total"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected = fmt.Sprintf(`
+# HELP kms_plugin_build_info Always 1, labeled with the version, commit and Go version of the build and the supported KMS API versions
+# TYPE kms_plugin_build_info gauge
+kms_plugin_build_info{api_versions="v1beta1,v2beta1",cluster="test",commit="UNKNOWN",go_version="%s",version="UNKNOWN"} 1
+`, runtime.Version())
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "kms_plugin_build_info"); err != nil {
+		t.Fatal(err)
+	}
+
+	// registering the same metrics twice fails instead of panicking
+	if _, err := NewMetrics(MetricsOpts{Registerer: reg, Namespace: "kms_plugin", ConstLabels: prometheus.Labels{"cluster": "test"}}); err == nil {
+		t.Fatal("expected duplicate registration error, got nil")
+	}
+	// a different namespace can share the registry
+	if _, err := NewMetrics(MetricsOpts{Registerer: reg}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if DefaultMetrics() != DefaultMetrics() {
+		t.Fatal("expected DefaultMetrics to be registered once")
+	}
+}
+
+func TestKMSOperationMetrics(t *testing.T) {
+	zap.ReplaceGlobals(zap.NewExample())
+
+	c := &cloud.KMSMock{}
+	sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+	go sharedHealthCheck.Start()
+	defer sharedHealthCheck.Stop()
+	metrics := newTestMetrics(t)
+	p := NewV2("operation-metrics", c, nil, sharedHealthCheck, metrics, nil)
+
+	c.SetEncryptResp("ciphertext", nil)
+	if _, err := p.Encrypt(context.Background(), &pb2.EncryptRequest{Plaintext: []byte("hello")}); err != nil {
+		t.Fatal(err)
+	}
+	c.SetEncryptResp("", &kmstypes.DisabledException{Message: aws.String("test")})
+	if _, err := p.Encrypt(context.Background(), &pb2.EncryptRequest{Plaintext: []byte("hello")}); err == nil {
+		t.Fatal("expected encrypt error, got nil")
+	}
+
+	if v := testutil.ToFloat64(metrics.kmsInFlightMetric.WithLabelValues("operation-metrics", "encrypt", GRPC_V2)); v != 0 {
+		t.Fatalf("expected no operation in flight, got %v", v)
+	}
+	if v := testutil.ToFloat64(metrics.kmsErrorCounter.WithLabelValues("operation-metrics", "encrypt", GRPC_V2, "user-induced", "DisabledException")); v != 1 {
+		t.Fatalf("expected 1 DisabledException error, got %v", v)
+	}
+	if n := testutil.CollectAndCount(metrics.kmsDurationMetric); n != 2 {
+		t.Fatalf("expected duration for success and failure, got %d series", n)
+	}
+
+	expected := `
+# HELP aws_encryption_provider_kms_plaintext_size_bytes Size in bytes of plaintexts encrypted or decrypted by KMS
+# TYPE aws_encryption_provider_kms_plaintext_size_bytes histogram
+aws_encryption_provider_kms_plaintext_size_bytes_bucket{key_arn="operation-metrics",operation="encrypt",version="v2",le="16"} 1
+aws_encryption_provider_kms_plaintext_size_bytes_bucket{key_arn="operation-metrics",operation="encrypt",version="v2",le="32"} 1
+aws_encryption_provider_kms_plaintext_size_bytes_bucket{key_arn="operation-metrics",operation="encrypt",version="v2",le="64"} 1
+aws_encryption_provider_kms_plaintext_size_bytes_bucket{key_arn="operation-metrics",operation="encrypt",version="v2",le="128"} 1
+aws_encryption_provider_kms_plaintext_size_bytes_bucket{key_arn="operation-metrics",operation="encrypt",version="v2",le="256"} 1
+aws_encryption_provider_kms_plaintext_size_bytes_bucket{key_arn="operation-metrics",operation="encrypt",version="v2",le="512"} 1
+aws_encryption_provider_kms_plaintext_size_bytes_bucket{key_arn="operation-metrics",operation="encrypt",version="v2",le="1024"} 1
+aws_encryption_provider_kms_plaintext_size_bytes_bucket{key_arn="operation-metrics",operation="encrypt",version="v2",le="2048"} 1
+aws_encryption_provider_kms_plaintext_size_bytes_bucket{key_arn="operation-metrics",operation="encrypt",version="v2",le="4096"} 1
+aws_encryption_provider_kms_plaintext_size_bytes_bucket{key_arn="operation-metrics",operation="encrypt",version="v2",le="8192"} 1
+aws_encryption_provider_kms_plaintext_size_bytes_bucket{key_arn="operation-metrics",operation="encrypt",version="v2",le="+Inf"} 1
+aws_encryption_provider_kms_plaintext_size_bytes_sum{key_arn="operation-metrics",operation="encrypt",version="v2"} 5
+aws_encryption_provider_kms_plaintext_size_bytes_count{key_arn="operation-metrics",operation="encrypt",version="v2"} 1
+`
+	if err := testutil.CollectAndCompare(metrics.kmsPlaintextSizeMetric, strings.NewReader(expected)); err != nil {
+		t.Fatal(err)
+	}
+}
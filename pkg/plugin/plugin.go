@@ -24,8 +24,11 @@ import (
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	pb "k8s.io/kms/apis/v1beta1"
+	"sigs.k8s.io/aws-encryption-provider/pkg/audit"
 	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
 	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+	"sigs.k8s.io/aws-encryption-provider/pkg/logging"
+	"sigs.k8s.io/aws-encryption-provider/pkg/tracing"
 	"sigs.k8s.io/aws-encryption-provider/pkg/version"
 )
 
@@ -42,15 +45,19 @@ type V1Plugin struct {
 	keyID         string
 	encryptionCtx map[string]string
 	healthCheck   *SharedHealthCheck
+	metrics       *Metrics
+	auditLog      *audit.Logger
 }
 
-// New returns a new *V1Plugin
-func New(key string, svc cloud.AWSKMSv2, encryptionCtx map[string]string, healthCheck *SharedHealthCheck) *V1Plugin {
+// New returns a new *V1Plugin. A nil metrics uses DefaultMetrics.
+func New(key string, svc cloud.AWSKMSv2, encryptionCtx map[string]string, healthCheck *SharedHealthCheck, metrics *Metrics, auditLog *audit.Logger) *V1Plugin {
 	return newPlugin(
 		key,
 		svc,
 		encryptionCtx,
 		healthCheck,
+		metrics,
+		auditLog,
 	)
 }
 
@@ -59,11 +66,15 @@ func newPlugin(
 	svc cloud.AWSKMSv2,
 	encryptionCtx map[string]string,
 	sharedHealthCheck *SharedHealthCheck,
+	metrics *Metrics,
+	auditLog *audit.Logger,
 ) *V1Plugin {
 	p := &V1Plugin{
 		svc:         svc,
 		keyID:       key,
 		healthCheck: sharedHealthCheck,
+		metrics:     metricsOrDefault(metrics),
+		auditLog:    auditLog,
 	}
 	if len(encryptionCtx) > 0 {
 		p.encryptionCtx = make(map[string]string)
@@ -90,21 +101,25 @@ func newPlugin(
 //  2. there was no health check done for the last "healthCheckPeriod"
 //     (only use the cached error if the error is from recent API call)
 func (p *V1Plugin) Health() error {
+	startTime := time.Now()
 	recent, err := p.healthCheck.isRecentlyChecked()
 	if !recent {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		ctx, cancel := context.WithTimeout(withHealthCheck(context.Background()), 5*time.Second)
 		defer cancel()
 		_, err = p.Encrypt(ctx, &pb.EncryptRequest{Plain: []byte("foo")})
 		p.healthCheck.RecordErr(err)
+		p.metrics.recordHealth(p.keyID, err)
+		p.metrics.observeHealthCheck(p.keyID, GRPC_V1, healthCheckSourceLive, err, startTime)
 		if err != nil {
-			zap.L().Warn("health check failed", zap.Error(err))
+			logging.L(logging.ComponentHealth).Warn("health check failed", zap.Error(err))
 		}
 		return err
 	}
+	p.metrics.observeHealthCheck(p.keyID, GRPC_V1, healthCheckSourceCached, err, startTime)
 	if err != nil {
-		zap.L().Warn("health check failed", zap.Error(err))
+		logging.L(logging.ComponentHealth).Warn("health check failed", zap.Error(err))
 	} else {
-		zap.L().Debug("health check success")
+		logging.L(logging.ComponentHealth).Debug("health check success")
 	}
 	return err
 }
@@ -137,36 +152,38 @@ func (p *V1Plugin) Version(ctx context.Context, request *pb.VersionRequest) (*pb
 // Encrypt executes the encryption operation using AWS KMS
 //
 
-func (p *V1Plugin) Encrypt(ctx context.Context, request *pb.EncryptRequest) (*pb.EncryptResponse, error) {
-	zap.L().Debug("starting encrypt operation")
+func (p *V1Plugin) Encrypt(ctx context.Context, request *pb.EncryptRequest) (resp *pb.EncryptResponse, err error) {
+	ctx, span := tracing.Start(ctx, "V1Plugin/Encrypt", tracing.AttributeKeyID.String(p.keyID), tracing.AttributeAPIVersion.String(GRPC_V1))
+	defer func() { tracing.End(span, err) }()
+	auditEvent := newAuditEvent(ctx, p.keyID, kmsplugin.OperationEncrypt, GRPC_V1, "", p.encryptionCtx)
+	defer func() { logAuditEvent(p.auditLog, auditEvent, err) }()
 
-	startTime := time.Now()
+	logging.L(logging.ComponentPlugin).Debug("starting encrypt operation")
 	input := &kms.EncryptInput{
 		Plaintext: request.Plain,
 		KeyId:     aws.String(p.keyID),
 	}
 	if len(p.encryptionCtx) > 0 {
-		zap.L().Debug("configuring encryption context", zap.String("ctx", fmt.Sprintf("%v", p.encryptionCtx)))
+		logging.L(logging.ComponentPlugin).Debug("configuring encryption context", logging.EncryptionContext("ctx", p.encryptionCtx))
 		input.EncryptionContext = p.encryptionCtx
 	}
 
+	ctx, kmsOp := p.metrics.startKMSOperation(ctx, p.keyID, kmsplugin.OperationEncrypt, GRPC_V1)
 	result, err := p.svc.Encrypt(ctx, input)
+	kmsOp.done(err)
 	if err != nil {
-		select {
-		case p.healthCheck.healthCheckErrc <- err:
-		default:
-		}
+		auditEvent.KMSRequestID = cloud.ErrorRequestID(err)
+		p.healthCheck.reportErr(err)
+		p.metrics.recordHealth(p.keyID, err)
 		errorType := kmsplugin.ParseError(err).String()
-		zap.L().Error("request to encrypt failed", zap.String("error-type", errorType), zap.Error(err))
-		failLabel := kmsplugin.GetStatusLabel(err, errorType)
-		kmsLatencyMetric.WithLabelValues(p.keyID, failLabel, kmsplugin.OperationEncrypt, GRPC_V1).Observe(kmsplugin.GetMillisecondsSince(startTime))
-		kmsOperationCounter.WithLabelValues(p.keyID, failLabel, kmsplugin.OperationEncrypt, GRPC_V1).Inc()
+		logging.L(logging.ComponentPlugin).Error("request to encrypt failed", zap.String("error-type", errorType), zap.Error(err))
 		return nil, fmt.Errorf("failed to encrypt %w", err)
 	}
 
-	zap.L().Debug("encrypt operation successful")
-	kmsLatencyMetric.WithLabelValues(p.keyID, kmsplugin.StatusSuccess, kmsplugin.OperationEncrypt, GRPC_V1).Observe(kmsplugin.GetMillisecondsSince(startTime))
-	kmsOperationCounter.WithLabelValues(p.keyID, kmsplugin.StatusSuccess, kmsplugin.OperationEncrypt, GRPC_V1).Inc()
+	logging.L(logging.ComponentPlugin).Debug("encrypt operation successful")
+	auditEvent.KeyARN = aws.ToString(result.KeyId)
+	auditEvent.KMSRequestID = cloud.RequestID(result.ResultMetadata)
+	kmsOp.observeSizes(len(input.Plaintext), len(result.CiphertextBlob))
 
 	return &pb.EncryptResponse{Cipher: append([]byte(kmsplugin.StorageVersion), result.CiphertextBlob...)}, nil
 }
@@ -174,10 +191,13 @@ func (p *V1Plugin) Encrypt(ctx context.Context, request *pb.EncryptRequest) (*pb
 // Decrypt executes the decrypt operation using AWS KMS
 //
 
-func (p *V1Plugin) Decrypt(ctx context.Context, request *pb.DecryptRequest) (*pb.DecryptResponse, error) {
-	zap.L().Debug("starting decrypt operation")
+func (p *V1Plugin) Decrypt(ctx context.Context, request *pb.DecryptRequest) (resp *pb.DecryptResponse, err error) {
+	ctx, span := tracing.Start(ctx, "V1Plugin/Decrypt", tracing.AttributeKeyID.String(p.keyID), tracing.AttributeAPIVersion.String(GRPC_V1))
+	defer func() { tracing.End(span, err) }()
+	auditEvent := newAuditEvent(ctx, p.keyID, kmsplugin.OperationDecrypt, GRPC_V1, "", p.encryptionCtx)
+	defer func() { logAuditEvent(p.auditLog, auditEvent, err) }()
 
-	startTime := time.Now()
+	logging.L(logging.ComponentPlugin).Debug("starting decrypt operation")
 
 	if len(request.Cipher) == 0 {
 		return nil, errors.New("invalid empty ciphertext")
@@ -189,41 +209,43 @@ func (p *V1Plugin) Decrypt(ctx context.Context, request *pb.DecryptRequest) (*pb
 		CiphertextBlob: request.Cipher,
 	}
 	if len(p.encryptionCtx) > 0 {
-		zap.L().Debug("configuring encryption context", zap.String("ctx", fmt.Sprintf("%v", p.encryptionCtx)))
+		logging.L(logging.ComponentPlugin).Debug("configuring encryption context", logging.EncryptionContext("ctx", p.encryptionCtx))
 		input.EncryptionContext = p.encryptionCtx
 	}
 
+	ctx, kmsOp := p.metrics.startKMSOperation(ctx, p.keyID, kmsplugin.OperationDecrypt, GRPC_V1)
 	result, err := p.svc.Decrypt(ctx, input)
+	kmsOp.done(err)
 	if err != nil {
+		auditEvent.KMSRequestID = cloud.ErrorRequestID(err)
 		errorType := kmsplugin.ParseError(err).String()
 		if errorType != kmsplugin.KMSErrorTypeCorruption.String() {
-			select {
-			case p.healthCheck.healthCheckErrc <- err:
-			default:
-			}
+			p.healthCheck.reportErr(err)
+			p.metrics.recordHealth(p.keyID, err)
 		}
-		zap.L().Error("request to decrypt failed", zap.String("error-type", errorType), zap.Error(err))
-		failLabel := kmsplugin.GetStatusLabel(err, errorType)
-		kmsLatencyMetric.WithLabelValues(p.keyID, failLabel, kmsplugin.OperationDecrypt, GRPC_V1).Observe(kmsplugin.GetMillisecondsSince(startTime))
-		kmsOperationCounter.WithLabelValues(p.keyID, failLabel, kmsplugin.OperationDecrypt, GRPC_V1).Inc()
+		logging.L(logging.ComponentPlugin).Error("request to decrypt failed", zap.String("error-type", errorType), zap.Error(err))
 		return nil, fmt.Errorf("failed to decrypt %w", err)
 	}
 
-	zap.L().Debug("decrypt operation successful")
-	kmsLatencyMetric.WithLabelValues(p.keyID, kmsplugin.StatusSuccess, kmsplugin.OperationDecrypt, GRPC_V1).Observe(kmsplugin.GetMillisecondsSince(startTime))
-	kmsOperationCounter.WithLabelValues(p.keyID, kmsplugin.StatusSuccess, kmsplugin.OperationDecrypt, GRPC_V1).Inc()
+	logging.L(logging.ComponentPlugin).Debug("decrypt operation successful")
+	auditEvent.KeyARN = aws.ToString(result.KeyId)
+	auditEvent.KMSRequestID = cloud.RequestID(result.ResultMetadata)
+	kmsOp.observeSizes(len(result.Plaintext), len(input.CiphertextBlob))
 
 	return &pb.DecryptResponse{Plain: result.Plaintext}, nil
 }
 
 // Register registers the V1Plugin with the grpc server
 func (p *V1Plugin) Register(s *grpc.Server) {
-	zap.L().Info("registering the kmsplugin plugin with grpc server")
+	logging.L(logging.ComponentPlugin).Info("registering the kmsplugin plugin with grpc server")
 	pb.RegisterKeyManagementServiceServer(s, p)
 }
 
 // WaitForReady uses a given client to wait until the given duration for the
 // server to become ready
+//
+// Deprecated: use the client package, whose calls wait for the plugin to be
+// ready with either KMS API version.
 func WaitForReady(client pb.KeyManagementServiceClient, duration time.Duration) error {
 	ctx, cancel := context.WithTimeout(context.Background(), duration)
 	defer cancel()
@@ -237,6 +259,8 @@ func WaitForReady(client pb.KeyManagementServiceClient, duration time.Duration)
 }
 
 // NewClient returns a KeyManagementServiceClient for a given grpc connection
+//
+// Deprecated: use client.New, which also covers the v2 API.
 func NewClient(conn *grpc.ClientConn) pb.KeyManagementServiceClient {
 	return pb.NewKeyManagementServiceClient(conn)
 }
@@ -25,6 +25,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/aws"
 	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
 	smithy "github.com/aws/smithy-go"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"go.uber.org/zap"
 	pb "k8s.io/kms/apis/v1beta1"
 	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
@@ -201,7 +202,7 @@ func TestEncrypt(t *testing.T) {
 			c.SetEncryptResp(tc.output, tc.err)
 			sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
 			go sharedHealthCheck.Start()
-			p := New(key, c, nil, sharedHealthCheck)
+			p := New(key, c, nil, sharedHealthCheck, newTestMetrics(t), nil)
 			defer func() {
 				sharedHealthCheck.Stop()
 			}()
@@ -279,7 +280,7 @@ func TestDecrypt(t *testing.T) {
 			c.SetDecryptResp(tc.output, tc.err)
 			sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
 			go sharedHealthCheck.Start()
-			p := New(key, c, tc.ctx, sharedHealthCheck)
+			p := New(key, c, tc.ctx, sharedHealthCheck, newTestMetrics(t), nil)
 			defer func() {
 				sharedHealthCheck.Stop()
 			}()
@@ -322,7 +323,7 @@ func TestHealth(t *testing.T) {
 		c := &cloud.KMSMock{}
 		sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
 		go sharedHealthCheck.Start()
-		p := New(key, c, nil, sharedHealthCheck)
+		p := New(key, c, nil, sharedHealthCheck, newTestMetrics(t), nil)
 		defer func() {
 			sharedHealthCheck.Stop()
 		}()
@@ -366,7 +367,7 @@ func TestHealthManyRequests(t *testing.T) {
 	c := &cloud.KMSMock{}
 	sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
 	go sharedHealthCheck.Start()
-	p := newPlugin(key, c, nil, sharedHealthCheck)
+	p := newPlugin(key, c, nil, sharedHealthCheck, newTestMetrics(t), nil)
 	defer func() {
 		sharedHealthCheck.Stop()
 	}()
@@ -404,7 +405,7 @@ func TestHealthTimeout(t *testing.T) {
 	go sharedHealthCheck.Start()
 	defer sharedHealthCheck.Stop()
 
-	p := New(key, c, nil, sharedHealthCheck)
+	p := New(key, c, nil, sharedHealthCheck, newTestMetrics(t), nil)
 
 	err := p.Health()
 
@@ -426,7 +427,7 @@ func TestDecryptEmptyCipher(t *testing.T) {
 	go sharedHealthCheck.Start()
 	defer sharedHealthCheck.Stop()
 
-	p := New(key, c, nil, sharedHealthCheck)
+	p := New(key, c, nil, sharedHealthCheck, newTestMetrics(t), nil)
 
 	dReq := &pb.DecryptRequest{Cipher: []byte{}}
 	_, err := p.Decrypt(ctx, dReq)
@@ -449,7 +450,7 @@ func TestDecryptNilCipher(t *testing.T) {
 	go sharedHealthCheck.Start()
 	defer sharedHealthCheck.Stop()
 
-	p := New(key, c, nil, sharedHealthCheck)
+	p := New(key, c, nil, sharedHealthCheck, newTestMetrics(t), nil)
 
 	dReq := &pb.DecryptRequest{Cipher: nil}
 	_, err := p.Decrypt(ctx, dReq)
@@ -461,3 +462,55 @@ func TestDecryptNilCipher(t *testing.T) {
 		t.Fatalf("expected 'invalid empty ciphertext' error, got: %v", err)
 	}
 }
+
+func TestHealthMetrics(t *testing.T) {
+	zap.ReplaceGlobals(zap.NewExample())
+
+	healthKey := "health-metrics-key"
+	c := &cloud.KMSMock{}
+	c.SetEncryptResp("foo", nil)
+	sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+	go sharedHealthCheck.Start()
+	defer sharedHealthCheck.Stop()
+	metrics := newTestMetrics(t)
+	p := New(healthKey, c, nil, sharedHealthCheck, metrics, nil)
+
+	// the first check calls KMS, the second one is answered from cache
+	if err := p.Health(); err != nil {
+		t.Fatalf("unexpected error from Health %v", err)
+	}
+	if err := p.Health(); err != nil {
+		t.Fatalf("unexpected error from Health %v", err)
+	}
+	for _, source := range []string{healthCheckSourceLive, healthCheckSourceCached} {
+		if v := testutil.ToFloat64(metrics.healthCheckCounter.WithLabelValues(healthKey, GRPC_V1, source, kmsplugin.StatusSuccess, "")); v != 1 {
+			t.Fatalf("expected 1 %s health check, got %v", source, v)
+		}
+	}
+	if v := testutil.ToFloat64(metrics.healthCheckStateMetric.WithLabelValues(healthKey)); v != 1 {
+		t.Fatalf("expected key to be healthy, got %v", v)
+	}
+	if v := testutil.ToFloat64(metrics.healthCheckLastSuccessMetric.WithLabelValues(healthKey)); v == 0 {
+		t.Fatal("expected last success timestamp to be set")
+	}
+
+	// a failed request marks the key unhealthy, and the next check is answered from cache
+	disabledErr := &kmstypes.DisabledException{Message: aws.String("test")}
+	c.SetEncryptResp("", disabledErr)
+	if _, err := p.Encrypt(context.Background(), &pb.EncryptRequest{Plain: []byte("foo")}); err == nil {
+		t.Fatal("expected encrypt error, got nil")
+	}
+	sharedHealthCheck.RecordErr(disabledErr)
+	if err := p.Health(); err == nil {
+		t.Fatal("expected cached health error, got nil")
+	}
+	if v := testutil.ToFloat64(metrics.healthCheckCounter.WithLabelValues(healthKey, GRPC_V1, healthCheckSourceCached, kmsplugin.StatusFailure, kmsplugin.KMSErrorTypeUserInduced.String())); v != 1 {
+		t.Fatalf("expected 1 failed cached health check, got %v", v)
+	}
+	if v := testutil.ToFloat64(metrics.healthCheckStateMetric.WithLabelValues(healthKey)); v != 0 {
+		t.Fatalf("expected key to be unhealthy, got %v", v)
+	}
+	if v := testutil.ToFloat64(metrics.healthCheckLastFailureMetric.WithLabelValues(healthKey)); v == 0 {
+		t.Fatal("expected last failure timestamp to be set")
+	}
+}
@@ -17,6 +17,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -24,14 +26,21 @@ import (
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	pb "k8s.io/kms/apis/v2"
+	"sigs.k8s.io/aws-encryption-provider/pkg/audit"
 	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
 	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+	"sigs.k8s.io/aws-encryption-provider/pkg/logging"
+	"sigs.k8s.io/aws-encryption-provider/pkg/tracing"
+	"sigs.k8s.io/aws-encryption-provider/pkg/version"
 )
 
 var _ pb.KeyManagementServiceServer = &V2Plugin{}
 
 const (
 	GRPC_V2 = "v2"
+
+	statusHealthzOK  = "ok"
+	statusHealthzErr = "err"
 )
 
 // Plugin implements the KeyManagementServiceServer
@@ -41,15 +50,30 @@ type V2Plugin struct {
 	keyID         string
 	encryptionCtx map[string]string
 	healthCheck   *SharedHealthCheck
+	metrics       *Metrics
+	auditLog      *audit.Logger
+
+	statusMu         sync.RWMutex
+	status           *v2Status
+	statusRefreshing atomic.Bool
+}
+
+// v2Status is the result of the last status check served by Status
+type v2Status struct {
+	healthz string
+	reason  string
+	ts      time.Time
 }
 
-// New returns a new *V2Plugin
-func NewV2(key string, svc cloud.AWSKMSv2, encryptionCtx map[string]string, healthCheck *SharedHealthCheck) *V2Plugin {
+// NewV2 returns a new *V2Plugin. A nil metrics uses DefaultMetrics.
+func NewV2(key string, svc cloud.AWSKMSv2, encryptionCtx map[string]string, healthCheck *SharedHealthCheck, metrics *Metrics, auditLog *audit.Logger) *V2Plugin {
 	return newPluginV2(
 		key,
 		svc,
 		encryptionCtx,
 		healthCheck,
+		metrics,
+		auditLog,
 	)
 }
 
@@ -58,11 +82,15 @@ func newPluginV2(
 	svc cloud.AWSKMSv2,
 	encryptionCtx map[string]string,
 	healthCheck *SharedHealthCheck,
+	metrics *Metrics,
+	auditLog *audit.Logger,
 ) *V2Plugin {
 	p := &V2Plugin{
 		svc:         svc,
 		keyID:       key,
 		healthCheck: healthCheck,
+		metrics:     metricsOrDefault(metrics),
+		auditLog:    auditLog,
 	}
 	if len(encryptionCtx) > 0 {
 		p.encryptionCtx = make(map[string]string)
@@ -89,27 +117,33 @@ func newPluginV2(
 //  2. there was no health check done for the last "healthCheckPeriod"
 //     (only use the cached error if the error is from recent API call)
 func (p *V2Plugin) Health() error {
+	startTime := time.Now()
 	recent, err := p.healthCheck.isRecentlyChecked()
 	if !recent {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		ctx, cancel := context.WithTimeout(withHealthCheck(context.Background()), 5*time.Second)
 		defer cancel()
 		encResult, err := p.Encrypt(ctx, &pb.EncryptRequest{Plaintext: []byte("foo")})
 		p.healthCheck.RecordErr(err)
+		p.metrics.recordHealth(p.keyID, err)
 		if err != nil {
-			zap.L().Warn("health check failed at encryption", zap.Error(err))
+			p.metrics.observeHealthCheck(p.keyID, GRPC_V2, healthCheckSourceLive, err, startTime)
+			logging.L(logging.ComponentHealth).Warn("health check failed at encryption", zap.Error(err))
 			return err
 		}
 		_, err = p.Decrypt(ctx, &pb.DecryptRequest{Ciphertext: encResult.Ciphertext})
 		p.healthCheck.RecordErr(err)
+		p.metrics.recordHealth(p.keyID, err)
+		p.metrics.observeHealthCheck(p.keyID, GRPC_V2, healthCheckSourceLive, err, startTime)
 		if err != nil {
-			zap.L().Warn("health check failed at decryption", zap.Error(err))
+			logging.L(logging.ComponentHealth).Warn("health check failed at decryption", zap.Error(err))
 		}
 		return err
 	}
+	p.metrics.observeHealthCheck(p.keyID, GRPC_V2, healthCheckSourceCached, err, startTime)
 	if err != nil {
-		zap.L().Warn("cached health check failed", zap.Error(err))
+		logging.L(logging.ComponentHealth).Warn("cached health check failed", zap.Error(err))
 	} else {
-		zap.L().Debug("health check success")
+		logging.L(logging.ComponentHealth).Debug("health check success")
 	}
 	return err
 }
@@ -127,50 +161,110 @@ func (p *V2Plugin) Live() error {
 	return nil
 }
 
-// Status returns the V2Plugin server status
-func (p *V2Plugin) Status(ctx context.Context, request *pb.StatusRequest) (*pb.StatusResponse, error) {
-	status := "ok"
-	if p.Health() != nil {
-		status = "err"
+// Status returns the V2Plugin server status.
+//
+// kube-apiserver polls Status frequently, so the result of the last check is
+// served from cache and refreshed in the background once it is older than the
+// health check period. Only the very first call waits for a check.
+//
+// Throttling is reported as "ok", as it is transient and marking the provider
+// unhealthy would only make the apiserver retry harder.
+func (p *V2Plugin) Status(ctx context.Context, request *pb.StatusRequest) (resp *pb.StatusResponse, err error) {
+	_, span := tracing.Start(ctx, "V2Plugin/Status", tracing.AttributeKeyID.String(p.keyID), tracing.AttributeAPIVersion.String(GRPC_V2))
+	defer func() { tracing.End(span, err) }()
+
+	p.statusMu.RLock()
+	st := p.status
+	p.statusMu.RUnlock()
+
+	switch {
+	case st == nil:
+		st = p.refreshStatus()
+	case time.Since(st.ts) >= p.healthCheck.healthCheckPeriod:
+		if p.statusRefreshing.CompareAndSwap(false, true) {
+			go func() {
+				defer p.statusRefreshing.Store(false)
+				p.refreshStatus()
+			}()
+		}
 	}
+
 	return &pb.StatusResponse{
-		Version: "v2beta1",
-		Healthz: status,
+		Version: version.APIVersionV2,
+		Healthz: st.healthz,
 		KeyId:   p.keyID,
 	}, nil
 }
 
+// refreshStatus runs a health check and caches the outcome for Status
+func (p *V2Plugin) refreshStatus() *v2Status {
+	err := p.Health()
+	st := &v2Status{
+		healthz: statusHealthzOK,
+		reason:  kmsplugin.ParseDegradedReason(err),
+		ts:      time.Now(),
+	}
+	if err != nil {
+		if st.reason != kmsplugin.DegradedReasonThrottled {
+			st.healthz = statusHealthzErr
+		}
+		logging.L(logging.ComponentHealth).Warn("kms plugin status degraded",
+			zap.String("key", p.keyID),
+			zap.String("reason", st.reason),
+			zap.String("healthz", st.healthz),
+			zap.Error(err),
+		)
+	}
+	for _, reason := range kmsplugin.DegradedReasons {
+		value := 0.0
+		if reason == st.reason {
+			value = 1
+		}
+		p.metrics.kmsDegradedMetric.WithLabelValues(p.keyID, reason).Set(value)
+	}
+
+	p.statusMu.Lock()
+	p.status = st
+	p.statusMu.Unlock()
+	return st
+}
+
 // Encrypt executes the encryption operation using AWS KMS
-func (p *V2Plugin) Encrypt(ctx context.Context, request *pb.EncryptRequest) (*pb.EncryptResponse, error) {
-	zap.L().Debug("starting encrypt operation")
+func (p *V2Plugin) Encrypt(ctx context.Context, request *pb.EncryptRequest) (resp *pb.EncryptResponse, err error) {
+	ctx, span := tracing.Start(ctx, "V2Plugin/Encrypt", tracing.AttributeKeyID.String(p.keyID), tracing.AttributeAPIVersion.String(GRPC_V2), tracing.AttributeRequestUID.String(request.Uid))
+	defer func() { tracing.End(span, err) }()
+	auditEvent := newAuditEvent(ctx, p.keyID, kmsplugin.OperationEncrypt, GRPC_V2, request.Uid, p.encryptionCtx)
+	defer func() { logAuditEvent(p.auditLog, auditEvent, err) }()
+	defer func() { err = requestError(request.Uid, err) }()
+	ctx = logging.WithRequestUID(ctx, request.Uid)
+	log := logging.FromContext(ctx, logging.ComponentPlugin)
 
-	startTime := time.Now()
+	log.Debug("starting encrypt operation")
 	input := &kms.EncryptInput{
 		Plaintext: request.Plaintext,
 		KeyId:     aws.String(p.keyID),
 	}
 	if len(p.encryptionCtx) > 0 {
-		zap.L().Debug("configuring encryption context", zap.String("ctx", fmt.Sprintf("%v", p.encryptionCtx)))
+		log.Debug("configuring encryption context", logging.EncryptionContext("ctx", p.encryptionCtx))
 		input.EncryptionContext = p.encryptionCtx
 	}
 
+	ctx, kmsOp := p.metrics.startKMSOperation(ctx, p.keyID, kmsplugin.OperationEncrypt, GRPC_V2)
 	result, err := p.svc.Encrypt(ctx, input)
+	kmsOp.done(err)
 	if err != nil {
-		select {
-		case p.healthCheck.healthCheckErrc <- err:
-		default:
-		}
+		auditEvent.KMSRequestID = cloud.ErrorRequestID(err)
+		p.healthCheck.reportErr(err)
+		p.metrics.recordHealth(p.keyID, err)
 		errorType := kmsplugin.ParseError(err).String()
-		zap.L().Error("request to encrypt failed", zap.String("error-type", errorType), zap.Error(err))
-		failLabel := kmsplugin.GetStatusLabel(err, errorType)
-		kmsLatencyMetric.WithLabelValues(p.keyID, failLabel, kmsplugin.OperationEncrypt, GRPC_V2).Observe(kmsplugin.GetMillisecondsSince(startTime))
-		kmsOperationCounter.WithLabelValues(p.keyID, failLabel, kmsplugin.OperationEncrypt, GRPC_V2).Inc()
+		log.Error("request to encrypt failed", zap.String("error-type", errorType), zap.String("kms-request-id", auditEvent.KMSRequestID), zap.Error(err))
 		return nil, fmt.Errorf("failed to encrypt %w", err)
 	}
 
-	zap.L().Debug("encrypt operation successful")
-	kmsLatencyMetric.WithLabelValues(p.keyID, kmsplugin.StatusSuccess, kmsplugin.OperationEncrypt, GRPC_V2).Observe(kmsplugin.GetMillisecondsSince(startTime))
-	kmsOperationCounter.WithLabelValues(p.keyID, kmsplugin.StatusSuccess, kmsplugin.OperationEncrypt, GRPC_V2).Inc()
+	auditEvent.KeyARN = aws.ToString(result.KeyId)
+	auditEvent.KMSRequestID = cloud.RequestID(result.ResultMetadata)
+	log.Debug("encrypt operation successful", zap.String("kms-request-id", auditEvent.KMSRequestID))
+	kmsOp.observeSizes(len(input.Plaintext), len(result.CiphertextBlob))
 	return &pb.EncryptResponse{
 		Ciphertext: append([]byte(kmsplugin.KMSStorageVersionV2), result.CiphertextBlob...),
 		KeyId:      p.keyID,
@@ -178,10 +272,16 @@ func (p *V2Plugin) Encrypt(ctx context.Context, request *pb.EncryptRequest) (*pb
 }
 
 // Decrypt executes the decrypt operation using AWS KMS
-func (p *V2Plugin) Decrypt(ctx context.Context, request *pb.DecryptRequest) (*pb.DecryptResponse, error) {
-	zap.L().Debug("starting decrypt operation")
+func (p *V2Plugin) Decrypt(ctx context.Context, request *pb.DecryptRequest) (resp *pb.DecryptResponse, err error) {
+	ctx, span := tracing.Start(ctx, "V2Plugin/Decrypt", tracing.AttributeKeyID.String(p.keyID), tracing.AttributeAPIVersion.String(GRPC_V2), tracing.AttributeRequestUID.String(request.Uid))
+	defer func() { tracing.End(span, err) }()
+	auditEvent := newAuditEvent(ctx, p.keyID, kmsplugin.OperationDecrypt, GRPC_V2, request.Uid, p.encryptionCtx)
+	defer func() { logAuditEvent(p.auditLog, auditEvent, err) }()
+	defer func() { err = requestError(request.Uid, err) }()
+	ctx = logging.WithRequestUID(ctx, request.Uid)
+	log := logging.FromContext(ctx, logging.ComponentPlugin)
 
-	startTime := time.Now()
+	log.Debug("starting decrypt operation")
 
 	if len(request.Ciphertext) == 0 {
 		return nil, errors.New("invalid empty ciphertext")
@@ -198,34 +298,42 @@ func (p *V2Plugin) Decrypt(ctx context.Context, request *pb.DecryptRequest) (*pb
 		CiphertextBlob: request.Ciphertext,
 	}
 	if len(p.encryptionCtx) > 0 {
-		zap.L().Debug("configuring encryption context", zap.String("ctx", fmt.Sprintf("%v", p.encryptionCtx)))
+		log.Debug("configuring encryption context", logging.EncryptionContext("ctx", p.encryptionCtx))
 		input.EncryptionContext = p.encryptionCtx
 	}
 
+	ctx, kmsOp := p.metrics.startKMSOperation(ctx, p.keyID, kmsplugin.OperationDecrypt, GRPC_V2)
 	result, err := p.svc.Decrypt(ctx, input)
+	kmsOp.done(err)
 	if err != nil {
+		auditEvent.KMSRequestID = cloud.ErrorRequestID(err)
 		errorType := kmsplugin.ParseError(err).String()
 		if errorType != kmsplugin.KMSErrorTypeCorruption.String() {
-			select {
-			case p.healthCheck.healthCheckErrc <- err:
-			default:
-			}
+			p.healthCheck.reportErr(err)
+			p.metrics.recordHealth(p.keyID, err)
 		}
-		zap.L().Error("request to decrypt failed", zap.String("error-type", errorType), zap.Error(err))
-		failLabel := kmsplugin.GetStatusLabel(err, errorType)
-		kmsLatencyMetric.WithLabelValues(p.keyID, failLabel, kmsplugin.OperationDecrypt, GRPC_V2).Observe(kmsplugin.GetMillisecondsSince(startTime))
-		kmsOperationCounter.WithLabelValues(p.keyID, failLabel, kmsplugin.OperationDecrypt, GRPC_V2).Inc()
+		log.Error("request to decrypt failed", zap.String("error-type", errorType), zap.String("kms-request-id", auditEvent.KMSRequestID), zap.Error(err))
 		return nil, fmt.Errorf("failed to decrypt %w", err)
 	}
 
-	zap.L().Debug("decrypt operation successful")
-	kmsLatencyMetric.WithLabelValues(p.keyID, kmsplugin.StatusSuccess, kmsplugin.OperationDecrypt, GRPC_V2).Observe(kmsplugin.GetMillisecondsSince(startTime))
-	kmsOperationCounter.WithLabelValues(p.keyID, kmsplugin.StatusSuccess, kmsplugin.OperationDecrypt, GRPC_V2).Inc()
+	auditEvent.KeyARN = aws.ToString(result.KeyId)
+	auditEvent.KMSRequestID = cloud.RequestID(result.ResultMetadata)
+	log.Debug("decrypt operation successful", zap.String("kms-request-id", auditEvent.KMSRequestID))
+	kmsOp.observeSizes(len(result.Plaintext), len(input.CiphertextBlob))
 	return &pb.DecryptResponse{Plaintext: result.Plaintext}, nil
 }
 
+// requestError prefixes err with the request UID, so that an error reported by
+// kube-apiserver can be joined to the plugin logs of the request
+func requestError(uid string, err error) error {
+	if err == nil || uid == "" {
+		return err
+	}
+	return fmt.Errorf("request uid %s: %w", uid, err)
+}
+
 // Register registers the V2Plugin with the grpc server
 func (p *V2Plugin) Register(s *grpc.Server) {
-	zap.L().Info("registering the kmsplugin plugin with grpc server")
+	logging.L(logging.ComponentPlugin).Info("registering the kmsplugin plugin with grpc server")
 	pb.RegisterKeyManagementServiceServer(s, p)
 }
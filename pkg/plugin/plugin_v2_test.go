@@ -25,10 +25,22 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/kms"
 	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
 	"github.com/aws/smithy-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
 	pb "k8s.io/kms/apis/v2"
 	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
 	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+	"sigs.k8s.io/aws-encryption-provider/pkg/logging"
+	"sigs.k8s.io/aws-encryption-provider/pkg/tracing"
+	"sigs.k8s.io/aws-encryption-provider/pkg/version"
 )
 
 func TestEncryptV2(t *testing.T) {
@@ -256,7 +268,7 @@ func TestEncryptV2(t *testing.T) {
 				c.SetDecryptResp(tc.input, tc.err)
 				sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
 				go sharedHealthCheck.Start()
-				p := NewV2(key, c, nil, sharedHealthCheck)
+				p := NewV2(key, c, nil, sharedHealthCheck, newTestMetrics(t), nil)
 				defer func() {
 					sharedHealthCheck.Stop()
 				}()
@@ -316,7 +328,7 @@ func TestDecryptV2(t *testing.T) {
 			c.SetDecryptResp(tc.output, tc.err)
 			sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
 			go sharedHealthCheck.Start()
-			p := NewV2(key, c, tc.ctx, sharedHealthCheck)
+			p := NewV2(key, c, tc.ctx, sharedHealthCheck, newTestMetrics(t), nil)
 			defer func() {
 				sharedHealthCheck.Stop()
 			}()
@@ -369,7 +381,7 @@ func TestHealthV2(t *testing.T) {
 		c := &cloud.KMSMock{}
 		sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
 		go sharedHealthCheck.Start()
-		p := NewV2(key, c, nil, sharedHealthCheck)
+		p := NewV2(key, c, nil, sharedHealthCheck, newTestMetrics(t), nil)
 		defer func() {
 			sharedHealthCheck.Stop()
 		}()
@@ -419,7 +431,7 @@ func TestHealthManyRequestsV2(t *testing.T) {
 	c := &cloud.KMSMock{}
 	sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
 	go sharedHealthCheck.Start()
-	p := newPluginV2(key, c, nil, sharedHealthCheck)
+	p := newPluginV2(key, c, nil, sharedHealthCheck, newTestMetrics(t), nil)
 	defer func() {
 		sharedHealthCheck.Stop()
 	}()
@@ -456,7 +468,7 @@ func TestHealthTimeoutV2(t *testing.T) {
 	go sharedHealthCheck.Start()
 	defer sharedHealthCheck.Stop()
 
-	p := NewV2(key, c, nil, sharedHealthCheck)
+	p := NewV2(key, c, nil, sharedHealthCheck, newTestMetrics(t), nil)
 
 	err := p.Health()
 
@@ -480,7 +492,7 @@ func TestHealthDecryptTimeoutV2(t *testing.T) {
 	go sharedHealthCheck.Start()
 	defer sharedHealthCheck.Stop()
 
-	p := NewV2(key, c, nil, sharedHealthCheck)
+	p := NewV2(key, c, nil, sharedHealthCheck, newTestMetrics(t), nil)
 
 	err := p.Health()
 
@@ -502,7 +514,7 @@ func TestDecryptEmptyCiphertextV2(t *testing.T) {
 	go sharedHealthCheck.Start()
 	defer sharedHealthCheck.Stop()
 
-	p := NewV2(key, c, nil, sharedHealthCheck)
+	p := NewV2(key, c, nil, sharedHealthCheck, newTestMetrics(t), nil)
 
 	dReq := &pb.DecryptRequest{Ciphertext: []byte{}}
 	_, err := p.Decrypt(ctx, dReq)
@@ -525,7 +537,7 @@ func TestDecryptNilCiphertextV2(t *testing.T) {
 	go sharedHealthCheck.Start()
 	defer sharedHealthCheck.Stop()
 
-	p := NewV2(key, c, nil, sharedHealthCheck)
+	p := NewV2(key, c, nil, sharedHealthCheck, newTestMetrics(t), nil)
 
 	dReq := &pb.DecryptRequest{Ciphertext: nil}
 	_, err := p.Decrypt(ctx, dReq)
@@ -537,3 +549,258 @@ func TestDecryptNilCiphertextV2(t *testing.T) {
 		t.Fatalf("expected 'invalid empty ciphertext' error, got: %v", err)
 	}
 }
+
+func TestStatusV2(t *testing.T) {
+	zap.ReplaceGlobals(zap.NewExample())
+
+	tt := []struct {
+		name          string
+		key           string
+		encryptErr    error
+		expectHealthz string
+		expectReason  string
+	}{
+		{
+			name:          "healthy",
+			key:           "status-healthy",
+			encryptErr:    nil,
+			expectHealthz: "ok",
+			expectReason:  kmsplugin.DegradedReasonNone,
+		},
+		{
+			name:          "throttled is reported ok",
+			key:           "status-throttled",
+			encryptErr:    &kmstypes.LimitExceededException{Message: aws.String("test")},
+			expectHealthz: "ok",
+			expectReason:  kmsplugin.DegradedReasonThrottled,
+		},
+		{
+			name:          "disabled key",
+			key:           "status-disabled",
+			encryptErr:    &kmstypes.DisabledException{Message: aws.String("test")},
+			expectHealthz: "err",
+			expectReason:  kmsplugin.DegradedReasonKeyDisabled,
+		},
+		{
+			name:          "expired credentials",
+			key:           "status-expired",
+			encryptErr:    &smithy.GenericAPIError{Code: "ExpiredTokenException", Message: "test"},
+			expectHealthz: "err",
+			expectReason:  kmsplugin.DegradedReasonCredentialsExpired,
+		},
+	}
+	for _, entry := range tt {
+		t.Run(entry.name, func(t *testing.T) {
+			c := &cloud.KMSMock{}
+			c.SetEncryptResp("foo", entry.encryptErr)
+			c.SetDecryptResp("foo", nil)
+			sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+			go sharedHealthCheck.Start()
+			defer sharedHealthCheck.Stop()
+			metrics := newTestMetrics(t)
+			p := NewV2(entry.key, c, nil, sharedHealthCheck, metrics, nil)
+
+			res, err := p.Status(context.Background(), &pb.StatusRequest{})
+			if err != nil {
+				t.Fatalf("unexpected error from Status %v", err)
+			}
+			if res.Healthz != entry.expectHealthz {
+				t.Fatalf("expected healthz %q, got %q", entry.expectHealthz, res.Healthz)
+			}
+			if res.KeyId != entry.key {
+				t.Fatalf("expected key id %q, got %q", entry.key, res.KeyId)
+			}
+			if res.Version != version.APIVersionV2 {
+				t.Fatalf("expected version %q, got %q", version.APIVersionV2, res.Version)
+			}
+			for _, reason := range kmsplugin.DegradedReasons {
+				expected := 0.0
+				if reason == entry.expectReason {
+					expected = 1
+				}
+				if v := testutil.ToFloat64(metrics.kmsDegradedMetric.WithLabelValues(entry.key, reason)); v != expected {
+					t.Fatalf("expected degraded metric %q to be %v, got %v", reason, expected, v)
+				}
+			}
+		})
+	}
+}
+
+func TestStatusCachedV2(t *testing.T) {
+	zap.ReplaceGlobals(zap.NewExample())
+
+	c := &cloud.KMSMock{}
+	c.SetEncryptResp("foo", nil)
+	c.SetDecryptResp("foo", nil)
+	sharedHealthCheck := NewSharedHealthCheck(200*time.Millisecond, DefaultErrcBufSize)
+	go sharedHealthCheck.Start()
+	defer sharedHealthCheck.Stop()
+	p := NewV2("status-cached", c, nil, sharedHealthCheck, newTestMetrics(t), nil)
+
+	res, err := p.Status(context.Background(), &pb.StatusRequest{})
+	if err != nil || res.Healthz != "ok" {
+		t.Fatalf("expected healthy status, got %v, %v", res, err)
+	}
+
+	// the failure is not visible until the cached status expires
+	c.SetEncryptResp("", &kmstypes.DisabledException{Message: aws.String("test")})
+	sharedHealthCheck.RecordErr(&kmstypes.DisabledException{Message: aws.String("test")})
+	res, err = p.Status(context.Background(), &pb.StatusRequest{})
+	if err != nil || res.Healthz != "ok" {
+		t.Fatalf("expected cached healthy status, got %v, %v", res, err)
+	}
+
+	// once expired, the stale status is served while it is refreshed in the background
+	time.Sleep(250 * time.Millisecond)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		res, err = p.Status(context.Background(), &pb.StatusRequest{})
+		if err != nil {
+			t.Fatalf("unexpected error from Status %v", err)
+		}
+		if res.Healthz == "err" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("status was not refreshed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTracingV2(t *testing.T) {
+	zap.ReplaceGlobals(zap.NewExample())
+
+	exporter := tracetest.NewInMemoryExporter()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	defer otel.SetTracerProvider(prev)
+
+	c := &cloud.KMSMock{}
+	sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+	go sharedHealthCheck.Start()
+	defer sharedHealthCheck.Stop()
+	p := NewV2(key, c, nil, sharedHealthCheck, newTestMetrics(t), nil)
+
+	c.SetEncryptResp(encryptedMessage, nil)
+	_, err := p.Encrypt(context.Background(), &pb.EncryptRequest{Plaintext: []byte(plainMessage), Uid: "encrypt-uid"})
+	if err != nil {
+		t.Fatalf("unexpected encrypt error: %v", err)
+	}
+	c.SetDecryptResp("", &kmstypes.KMSInvalidStateException{Message: aws.String("test")})
+	_, err = p.Decrypt(context.Background(), &pb.DecryptRequest{Ciphertext: []byte(encryptedMessageV2), Uid: "decrypt-uid"})
+	if err == nil {
+		t.Fatal("expected decrypt error")
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	for i, expect := range []struct {
+		name   string
+		uid    string
+		status codes.Code
+	}{
+		{name: "V2Plugin/Encrypt", uid: "encrypt-uid", status: codes.Unset},
+		{name: "V2Plugin/Decrypt", uid: "decrypt-uid", status: codes.Error},
+	} {
+		span := spans[i]
+		if span.Name != expect.name {
+			t.Errorf("expected span %q, got %q", expect.name, span.Name)
+		}
+		if span.Status.Code != expect.status {
+			t.Errorf("span %s: expected status %v, got %v", span.Name, expect.status, span.Status.Code)
+		}
+		attrs := attribute.NewSet(span.Attributes...)
+		for k, v := range map[attribute.Key]string{
+			tracing.AttributeKeyID:      key,
+			tracing.AttributeAPIVersion: GRPC_V2,
+			tracing.AttributeRequestUID: expect.uid,
+		} {
+			if got, _ := attrs.Value(k); got.AsString() != v {
+				t.Errorf("span %s: expected %s=%q, got %q", span.Name, k, v, got.AsString())
+			}
+		}
+	}
+	decryptAttrs := attribute.NewSet(spans[1].Attributes...)
+	if got, _ := decryptAttrs.Value(tracing.AttributeErrorType); got.AsString() != kmsplugin.KMSErrorTypeUserInduced.String() {
+		t.Errorf("expected decrypt error type %q, got %q", kmsplugin.KMSErrorTypeUserInduced, got.AsString())
+	}
+}
+
+func TestRequestUIDV2(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	zap.ReplaceGlobals(zap.New(core))
+	defer zap.ReplaceGlobals(zap.NewExample())
+
+	reg := prometheus.NewRegistry()
+	metrics, err := NewMetrics(MetricsOpts{Registerer: reg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &cloud.KMSMock{}
+	sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+	go sharedHealthCheck.Start()
+	defer sharedHealthCheck.Stop()
+	p := NewV2(key, c, nil, sharedHealthCheck, metrics, nil)
+
+	c.SetEncryptResp(encryptedMessage, nil)
+	if _, err := p.Encrypt(context.Background(), &pb.EncryptRequest{Plaintext: []byte(plainMessage), Uid: "encrypt-uid"}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	c.SetDecryptResp("", &kmstypes.DisabledException{Message: aws.String("test")})
+	_, err = p.Decrypt(context.Background(), &pb.DecryptRequest{Ciphertext: []byte(encryptedMessageV2), Uid: "decrypt-uid"})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if !strings.HasPrefix(err.Error(), "request uid decrypt-uid: ") {
+		t.Fatalf("expected error to carry the request uid, got %v", err)
+	}
+	var disabled *kmstypes.DisabledException
+	if !errors.As(err, &disabled) {
+		t.Fatalf("expected error to wrap the KMS error, got %v", err)
+	}
+
+	uids := map[string]int{}
+	for _, entry := range logs.Filter(func(e observer.LoggedEntry) bool { return e.LoggerName == logging.ComponentPlugin }).All() {
+		uid, ok := entry.ContextMap()[logging.FieldRequestUID].(string)
+		if !ok {
+			t.Fatalf("log %q has no request uid", entry.Message)
+		}
+		uids[uid]++
+	}
+	if uids["encrypt-uid"] == 0 || uids["decrypt-uid"] == 0 {
+		t.Fatalf("expected logs for both requests, got %v", uids)
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exemplars := map[string]string{}
+	for _, family := range families {
+		if family.GetName() != DefaultMetricsNamespace+"_kms_operation_duration_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			operation := ""
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "operation" {
+					operation = label.GetValue()
+				}
+			}
+			for _, bucket := range metric.GetHistogram().GetBucket() {
+				for _, label := range bucket.GetExemplar().GetLabel() {
+					if label.GetName() == "request_uid" {
+						exemplars[operation] = label.GetValue()
+					}
+				}
+			}
+		}
+	}
+	expected := map[string]string{kmsplugin.OperationEncrypt: "encrypt-uid", kmsplugin.OperationDecrypt: "decrypt-uid"}
+	if !reflect.DeepEqual(exemplars, expected) {
+		t.Fatalf("expected exemplars %v, got %v", expected, exemplars)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"go.uber.org/zap"
+	"sigs.k8s.io/aws-encryption-provider/pkg/logging"
 )
 
 // TODO: make configurable
@@ -13,6 +14,11 @@ const (
 	DefaultErrcBufSize       = 100
 )
 
+const (
+	healthCheckSourceLive   = "live"
+	healthCheckSourceCached = "cached"
+)
+
 type SharedHealthCheck struct {
 	lastMu  sync.RWMutex
 	lastErr error
@@ -40,11 +46,11 @@ func NewSharedHealthCheck(
 }
 
 func (p *SharedHealthCheck) Start() {
-	zap.L().Info("starting health check routine", zap.String("period", p.healthCheckPeriod.String()))
+	logging.L(logging.ComponentHealth).Info("starting health check routine", zap.String("period", p.healthCheckPeriod.String()))
 	for {
 		select {
 		case <-p.healthCheckStopc:
-			zap.L().Warn("exiting health check routine")
+			logging.L(logging.ComponentHealth).Warn("exiting health check routine")
 			p.healthCheckClosed <- struct{}{}
 			return
 		case err := <-p.healthCheckErrc:
@@ -68,6 +74,16 @@ func (p *SharedHealthCheck) isRecentlyChecked() (bool, error) {
 	return !never && latest, err
 }
 
+// reportErr hands a failed KMS call to the health check routine without blocking
+func (p *SharedHealthCheck) reportErr(err error) {
+	select {
+	case p.healthCheckErrc <- err:
+	default:
+	}
+}
+
+// RecordErr records the outcome of a KMS call, which is shared by all keys for
+// caching purposes.
 func (p *SharedHealthCheck) RecordErr(err error) {
 	p.lastMu.Lock()
 	p.lastErr, p.lastTs = err, time.Now()
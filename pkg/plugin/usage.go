@@ -0,0 +1,188 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Sources of billable KMS requests
+const (
+	// UsageSourceCaller are requests made to serve kube-apiserver
+	UsageSourceCaller = "caller"
+	// UsageSourceHealthCheck are requests made by health checks
+	UsageSourceHealthCheck = "health_check"
+	// UsageSourceKeyState are requests made by the KeyStateWatcher
+	UsageSourceKeyState = "key_state"
+)
+
+// usageWindowMinutes is the longest window usage is summarized over
+const usageWindowMinutes = 24 * 60
+
+type healthCheckKey struct{}
+
+// withHealthCheck marks ctx as belonging to a health check, so that the KMS
+// requests made with it are accounted to health checks
+func withHealthCheck(ctx context.Context) context.Context {
+	return context.WithValue(ctx, healthCheckKey{}, true)
+}
+
+// usageSource returns the source KMS requests made with ctx are accounted to
+func usageSource(ctx context.Context) string {
+	if hc, _ := ctx.Value(healthCheckKey{}).(bool); hc {
+		return UsageSourceHealthCheck
+	}
+	return UsageSourceCaller
+}
+
+// billableRequests returns the number of requests sent to KMS for an
+// operation, one per SDK attempt
+func billableRequests(attempts *atomic.Int32) int {
+	// clients that do not go through the SDK middleware stack (e.g. mocks) make no attempts
+	if n := int(attempts.Load()); n > 0 {
+		return n
+	}
+	return 1
+}
+
+type usageKey struct {
+	keyID     string
+	operation string
+	source    string
+}
+
+// usageWindow counts requests per minute over the last usageWindowMinutes,
+// each slot remembering the minute it counts so stale slots are ignored
+type usageWindow struct {
+	minutes [usageWindowMinutes]int64
+	counts  [usageWindowMinutes]uint64
+}
+
+func (w *usageWindow) add(minute int64, n uint64) {
+	i := minute % usageWindowMinutes
+	if w.minutes[i] != minute {
+		w.minutes[i], w.counts[i] = minute, 0
+	}
+	w.counts[i] += n
+}
+
+// sum returns the count over the span minutes ending with minute now
+func (w *usageWindow) sum(now, span int64) uint64 {
+	var total uint64
+	for m := now - span + 1; m <= now; m++ {
+		if i := m % usageWindowMinutes; w.minutes[i] == m {
+			total += w.counts[i]
+		}
+	}
+	return total
+}
+
+// usage keeps rolling totals of billable KMS requests
+type usage struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	since   time.Time
+	windows map[usageKey]*usageWindow
+}
+
+func newUsage(now func() time.Time) *usage {
+	return &usage{now: now, since: now(), windows: map[usageKey]*usageWindow{}}
+}
+
+func (u *usage) add(keyID, operation, source string, n int) {
+	k := usageKey{keyID: keyID, operation: operation, source: source}
+	minute := u.now().Unix() / 60
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	w, ok := u.windows[k]
+	if !ok {
+		w = &usageWindow{}
+		u.windows[k] = w
+	}
+	w.add(minute, uint64(n))
+}
+
+// UsageSummary is served on /debug/usage
+type UsageSummary struct {
+	Time time.Time `json:"time"`
+	// Since is when counting started, windows reaching further back are partial
+	Since time.Time `json:"since"`
+	// Total sums requests by source
+	Total map[string]UsageTotals `json:"total"`
+	Keys  []KeyUsage             `json:"keys"`
+}
+
+// UsageTotals are billable KMS requests over the last hour and day
+type UsageTotals struct {
+	LastHour    uint64 `json:"lastHour"`
+	Last24Hours uint64 `json:"last24Hours"`
+}
+
+// KeyUsage are the requests made with a key for one operation and source
+type KeyUsage struct {
+	KeyID     string `json:"keyID"`
+	Operation string `json:"operation"`
+	Source    string `json:"source"`
+	UsageTotals
+}
+
+func (u *usage) summary() UsageSummary {
+	now := u.now()
+	minute := now.Unix() / 60
+	s := UsageSummary{Time: now, Since: u.since, Total: map[string]UsageTotals{}, Keys: []KeyUsage{}}
+
+	u.mu.Lock()
+	for k, w := range u.windows {
+		totals := UsageTotals{LastHour: w.sum(minute, 60), Last24Hours: w.sum(minute, usageWindowMinutes)}
+		if totals.Last24Hours == 0 {
+			continue
+		}
+		s.Keys = append(s.Keys, KeyUsage{KeyID: k.keyID, Operation: k.operation, Source: k.source, UsageTotals: totals})
+		total := s.Total[k.source]
+		total.LastHour += totals.LastHour
+		total.Last24Hours += totals.Last24Hours
+		s.Total[k.source] = total
+	}
+	u.mu.Unlock()
+
+	sort.Slice(s.Keys, func(i, j int) bool {
+		a, b := s.Keys[i], s.Keys[j]
+		if a.KeyID != b.KeyID {
+			return a.KeyID < b.KeyID
+		}
+		if a.Operation != b.Operation {
+			return a.Operation < b.Operation
+		}
+		return a.Source < b.Source
+	})
+	return s
+}
+
+// UsageHandler serves a summary of the billable KMS requests made over the
+// last hour and day, by key, operation and source
+func (m *Metrics) UsageHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(rw)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(m.usage.summary())
+	})
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	pb "k8s.io/kms/apis/v2"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+)
+
+func TestUsageWindows(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	now := start
+	u := newUsage(func() time.Time { return now })
+
+	u.add("key-a", kmsplugin.OperationEncrypt, UsageSourceCaller, 2)
+	now = start.Add(45 * time.Minute)
+	u.add("key-a", kmsplugin.OperationEncrypt, UsageSourceCaller, 3)
+	u.add("key-a", kmsplugin.OperationEncrypt, UsageSourceHealthCheck, 1)
+	now = start.Add(90 * time.Minute)
+	u.add("key-b", kmsplugin.OperationDecrypt, UsageSourceCaller, 4)
+
+	s := u.summary()
+	assert.Equal(t, start, s.Since)
+	assert.Equal(t, []KeyUsage{
+		{KeyID: "key-a", Operation: kmsplugin.OperationEncrypt, Source: UsageSourceCaller, UsageTotals: UsageTotals{LastHour: 3, Last24Hours: 5}},
+		{KeyID: "key-a", Operation: kmsplugin.OperationEncrypt, Source: UsageSourceHealthCheck, UsageTotals: UsageTotals{LastHour: 1, Last24Hours: 1}},
+		{KeyID: "key-b", Operation: kmsplugin.OperationDecrypt, Source: UsageSourceCaller, UsageTotals: UsageTotals{LastHour: 4, Last24Hours: 4}},
+	}, s.Keys)
+	assert.Equal(t, map[string]UsageTotals{
+		UsageSourceCaller:      {LastHour: 7, Last24Hours: 9},
+		UsageSourceHealthCheck: {LastHour: 1, Last24Hours: 1},
+	}, s.Total)
+
+	// a day later, counts from the same minute of the previous day are ignored
+	now = start.Add(24*time.Hour + 50*time.Minute)
+	u.add("key-a", kmsplugin.OperationEncrypt, UsageSourceCaller, 10)
+	s = u.summary()
+	assert.Equal(t, map[string]UsageTotals{
+		UsageSourceCaller: {LastHour: 10, Last24Hours: 14},
+	}, s.Total)
+
+	// keys without requests in the last day are omitted
+	now = start.Add(72 * time.Hour)
+	s = u.summary()
+	assert.Empty(t, s.Keys)
+	assert.Empty(t, s.Total)
+}
+
+func TestBillableRequests(t *testing.T) {
+	zap.ReplaceGlobals(zap.NewExample())
+
+	c := &cloud.KMSMock{}
+	c.SetEncryptResp(encryptedMessage, nil)
+	c.SetDecryptResp(plainMessage, nil)
+	c.SetDescribeKeyResp(&kmstypes.KeyMetadata{
+		Arn:      aws.String("arn:aws:kms:us-west-2:111122223333:key/usage"),
+		KeyState: kmstypes.KeyStateEnabled,
+		KeySpec:  kmstypes.KeySpecSymmetricDefault,
+	}, nil)
+	sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+	go sharedHealthCheck.Start()
+	defer sharedHealthCheck.Stop()
+	metrics := newTestMetrics(t)
+	p := NewV2("usage", c, nil, sharedHealthCheck, metrics, nil)
+
+	_, err := p.Encrypt(context.Background(), &pb.EncryptRequest{Plaintext: []byte(plainMessage)})
+	require.NoError(t, err)
+	_, err = p.Decrypt(context.Background(), &pb.DecryptRequest{Ciphertext: []byte(encryptedMessageV2)})
+	require.NoError(t, err)
+	_, err = p.Decrypt(context.Background(), &pb.DecryptRequest{Ciphertext: []byte(encryptedMessageV2)})
+	require.NoError(t, err)
+	// a live health check encrypts and decrypts, the following one is cached
+	require.NoError(t, p.Health())
+	require.NoError(t, p.Health())
+	NewKeyStateWatcher([]string{"usage"}, c, time.Hour, metrics).checkAll()
+
+	expected := map[[2]string]float64{
+		{kmsplugin.OperationEncrypt, UsageSourceCaller}:                   1,
+		{kmsplugin.OperationDecrypt, UsageSourceCaller}:                   2,
+		{kmsplugin.OperationEncrypt, UsageSourceHealthCheck}:              1,
+		{kmsplugin.OperationDecrypt, UsageSourceHealthCheck}:              1,
+		{kmsplugin.OperationDescribeKey, UsageSourceKeyState}:             1,
+		{kmsplugin.OperationGetKeyRotationStatus, UsageSourceKeyState}:    1,
+		{kmsplugin.OperationDescribeKey, UsageSourceCaller}:               0,
+		{kmsplugin.OperationGetKeyRotationStatus, UsageSourceHealthCheck}: 0,
+	}
+	for labels, want := range expected {
+		got := testutil.ToFloat64(metrics.kmsBillableRequestsCounter.WithLabelValues("usage", labels[0], labels[1]))
+		assert.Equal(t, want, got, "operation %s source %s", labels[0], labels[1])
+	}
+
+	rec := httptest.NewRecorder()
+	metrics.UsageHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/usage", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var s UsageSummary
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &s))
+	assert.Equal(t, map[string]UsageTotals{
+		UsageSourceCaller:      {LastHour: 3, Last24Hours: 3},
+		UsageSourceHealthCheck: {LastHour: 2, Last24Hours: 2},
+		UsageSourceKeyState:    {LastHour: 2, Last24Hours: 2},
+	}, s.Total)
+	assert.Len(t, s.Keys, 6)
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"net"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/peer"
+)
+
+// PeerCredentials identifies the process on the other end of a unix socket
+type PeerCredentials struct {
+	credentials.CommonAuthInfo
+	PID int32
+	UID uint32
+	GID uint32
+}
+
+// AuthType implements credentials.AuthInfo
+func (PeerCredentials) AuthType() string {
+	return "peercred"
+}
+
+// PeerCredentialsFromContext returns the credentials of the process that sent
+// the request, if the platform supports reading them.
+func PeerCredentialsFromContext(ctx context.Context) (PeerCredentials, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return PeerCredentials{}, false
+	}
+	creds, ok := p.AuthInfo.(PeerCredentials)
+	return creds, ok
+}
+
+// peerCredentials are insecure transport credentials, as the plugin only
+// listens on a unix socket, that record the credentials of the connecting
+// process.
+type peerCredentials struct {
+	credentials.TransportCredentials
+}
+
+func newPeerCredentials() credentials.TransportCredentials {
+	return &peerCredentials{TransportCredentials: insecure.NewCredentials()}
+}
+
+func (c *peerCredentials) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	conn, info, err := c.TransportCredentials.ServerHandshake(conn)
+	if err != nil {
+		return nil, nil, err
+	}
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return conn, info, nil
+	}
+	creds, err := readPeerCredentials(unixConn)
+	if err != nil {
+		// the request is still served, it just cannot be attributed to a process
+		return conn, info, nil
+	}
+	creds.SecurityLevel = credentials.NoSecurity
+	return conn, creds, nil
+}
+
+func (c *peerCredentials) Clone() credentials.TransportCredentials {
+	return &peerCredentials{TransportCredentials: c.TransportCredentials.Clone()}
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"sigs.k8s.io/aws-encryption-provider/pkg/connection"
+)
+
+// peerHealthServer records the peer credentials of the last Check call
+type peerHealthServer struct {
+	grpc_health_v1.UnimplementedHealthServer
+	creds PeerCredentials
+	ok    bool
+}
+
+func (s *peerHealthServer) Check(ctx context.Context, _ *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+	s.creds, s.ok = PeerCredentialsFromContext(ctx)
+	return &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING}, nil
+}
+
+func TestPeerCredentials(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials are only read on linux")
+	}
+	zap.ReplaceGlobals(zap.NewExample())
+
+	addr := filepath.Join(t.TempDir(), "peercred.sock")
+	s := New()
+	hs := &peerHealthServer{}
+	grpc_health_v1.RegisterHealthServer(s.Server, hs)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.ListenAndServe(addr)
+	}()
+	defer func() {
+		s.Stop()
+		if err := <-errc; err != nil {
+			t.Errorf("unexpected server error %v", err)
+		}
+	}()
+
+	conn, err := connection.New(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close() //nolint:errcheck
+	client := grpc_health_v1.NewHealthClient(conn)
+	if _, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{}, grpc.WaitForReady(true)); err != nil {
+		t.Fatal(err)
+	}
+
+	if !hs.ok {
+		t.Fatal("expected peer credentials in the request context")
+	}
+	if hs.creds.PID != int32(os.Getpid()) || hs.creds.UID != uint32(os.Getuid()) || hs.creds.GID != uint32(os.Getgid()) {
+		t.Fatalf("expected credentials of this process, got %+v", hs.creds)
+	}
+}
@@ -20,38 +20,54 @@ import (
 
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"sigs.k8s.io/aws-encryption-provider/pkg/logging"
+	"sigs.k8s.io/aws-encryption-provider/pkg/tracing"
 )
 
 type Server struct {
 	*grpc.Server
 }
 
-func New() *Server {
+// New returns a server checking the credentials of its peers and tracing its
+// calls, opts are added to the options of the grpc server
+func New(opts ...grpc.ServerOption) *Server {
 	return &Server{
-		grpc.NewServer(),
+		grpc.NewServer(append([]grpc.ServerOption{
+			grpc.Creds(newPeerCredentials()),
+			grpc.ChainUnaryInterceptor(tracing.UnaryServerInterceptor()),
+		}, opts...)...),
 	}
 }
 
 func (s *Server) ListenAndServe(addr string) error {
+	l, err := Listen(addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Listen listens on the unix socket addr, replacing the socket file left by a
+// previous server
+func Listen(addr string) (net.Listener, error) {
 	// Server should remove the socket file prior to binding it in case the socket isn't cleaned up gracefully.
 	// This can happen if the application is killed by SIGKILL or SIGSTOP, i.e. kill -9 or docker kill by default.
 	if _, err := os.Stat(addr); err != nil {
 		if !os.IsNotExist(err) {
-			return fmt.Errorf("failed to os.Stat socket: %v", err)
+			return nil, fmt.Errorf("failed to os.Stat socket: %v", err)
 		}
 	} else {
 		// the socket file exists, it should be removed
-		zap.L().Info("Removing existing socket", zap.String("address", addr))
+		logging.L(logging.ComponentServer).Info("Removing existing socket", zap.String("address", addr))
 		if err = os.Remove(addr); err != nil {
 			if !os.IsNotExist(err) {
-				return fmt.Errorf("failed to os.Remove existing socket: %v", err)
+				return nil, fmt.Errorf("failed to os.Remove existing socket: %v", err)
 			}
 		}
 	}
 	l, err := net.Listen("unix", addr)
 	if err != nil {
-		return fmt.Errorf("failed to create listener: %v", err)
+		return nil, fmt.Errorf("failed to create listener: %v", err)
 	}
-
-	return s.Serve(l)
+	return l, nil
 }
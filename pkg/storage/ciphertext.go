@@ -0,0 +1,87 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+)
+
+// Formats of a KMS ciphertext blob
+const (
+	// BlobFormatSymmetric is the format of ciphertexts of symmetric KMS keys
+	BlobFormatSymmetric = "symmetric"
+	BlobFormatUnknown   = "unknown"
+)
+
+// symmetricHeader starts the ciphertexts of symmetric KMS keys, "AQICAH" in
+// base64. The rest of the format is not documented.
+var symmetricHeader = []byte{0x01, 0x02, 0x02, 0x00, 0x78}
+
+var (
+	keyARNPattern = regexp.MustCompile(`arn:aws[a-z-]*:kms:[a-z0-9-]+:[0-9]{12}:key/(?:mrk-[0-9a-f]{32}|[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12})`)
+	keyIDPattern  = regexp.MustCompile(`mrk-[0-9a-f]{32}|[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`)
+)
+
+// PluginCiphertext describes a ciphertext returned by the plugin, its
+// storage version followed by the KMS ciphertext blob
+type PluginCiphertext struct {
+	StorageVersion string         `json:"storageVersion"`
+	KMSCiphertext  CiphertextBlob `json:"kmsCiphertext"`
+}
+
+// CiphertextBlob is what can be told of a KMS ciphertext blob without KMS.
+// The key is only found if the blob holds its ARN or ID in clear.
+type CiphertextBlob struct {
+	Length int    `json:"length"`
+	Header string `json:"header"`
+	Format string `json:"format"`
+	KeyARN string `json:"keyARN,omitempty"`
+	KeyID  string `json:"keyID,omitempty"`
+}
+
+// InspectPluginCiphertext checks the storage version of a plugin ciphertext
+// and describes its KMS ciphertext blob
+func InspectPluginCiphertext(b []byte) (PluginCiphertext, error) {
+	if len(b) == 0 {
+		return PluginCiphertext{}, fmt.Errorf("plugin ciphertext is empty")
+	}
+	c := PluginCiphertext{StorageVersion: string(b[:1]), KMSCiphertext: InspectBlob(b[1:])}
+	// the v1 and v2 plugins share the storage version
+	if c.StorageVersion != kmsplugin.StorageVersion && c.StorageVersion != string(kmsplugin.KMSStorageVersionV2) {
+		c.StorageVersion = fmt.Sprintf("%#02x", b[0])
+		return c, fmt.Errorf("unknown storage version %s, expected %q", c.StorageVersion, kmsplugin.StorageVersion)
+	}
+	return c, nil
+}
+
+// InspectBlob describes a KMS ciphertext blob
+func InspectBlob(b []byte) CiphertextBlob {
+	header := b[:min(len(b), len(symmetricHeader))]
+	blob := CiphertextBlob{Length: len(b), Header: hex.EncodeToString(header), Format: BlobFormatUnknown}
+	if bytes.Equal(header, symmetricHeader) {
+		blob.Format = BlobFormatSymmetric
+	}
+	if arn := keyARNPattern.Find(b); arn != nil {
+		blob.KeyARN = string(arn)
+		blob.KeyID = string(keyIDPattern.Find(arn))
+	} else if id := keyIDPattern.Find(b); id != nil {
+		blob.KeyID = string(id)
+	}
+	return blob
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// keyBucket is the bucket of the etcd database holding the revisions of keys
+var keyBucket = []byte("key")
+
+// Layout of the keys of keyBucket: the main and sub revisions separated by
+// '_', followed by 't' for a deletion
+const (
+	revisionSize       = 8 + 1 + 8
+	markedRevisionSize = revisionSize + 1
+	markTombstone      = 't'
+)
+
+// Field numbers of the KeyValue message of etcd
+const (
+	fieldKVKey         = 1
+	fieldKVModRevision = 3
+	fieldKVValue       = 5
+)
+
+// KeyValue is a key of an etcd snapshot at its latest revision
+type KeyValue struct {
+	Key         []byte
+	ModRevision int64
+	Value       []byte
+}
+
+// ReadSnapshot reads the database of an etcd snapshot, or of a stopped etcd
+// member, and returns the keys starting with prefix at their latest revision,
+// sorted by key. Deleted keys are left out.
+func ReadSnapshot(path string, prefix []byte) ([]KeyValue, error) {
+	db, err := bolt.Open(path, 0400, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open etcd snapshot: %w", err)
+	}
+	defer db.Close() //nolint:errcheck
+
+	latest := map[string]KeyValue{}
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(keyBucket)
+		if b == nil {
+			return errors.New("etcd snapshot has no key bucket")
+		}
+		// revisions are iterated in increasing order, the last one of a key wins
+		return b.ForEach(func(rev, v []byte) error {
+			kv, err := unmarshalKeyValue(v)
+			if err != nil {
+				return fmt.Errorf("revision %x: %w", rev, err)
+			}
+			if !bytes.HasPrefix(kv.Key, prefix) {
+				return nil
+			}
+			if len(rev) == markedRevisionSize && rev[revisionSize] == markTombstone {
+				delete(latest, string(kv.Key))
+				return nil
+			}
+			latest[string(kv.Key)] = kv
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	kvs := make([]KeyValue, 0, len(latest))
+	for _, kv := range latest {
+		kvs = append(kvs, kv)
+	}
+	sort.Slice(kvs, func(i, j int) bool { return bytes.Compare(kvs[i].Key, kvs[j].Key) < 0 })
+	return kvs, nil
+}
+
+// unmarshalKeyValue decodes the KeyValue message of etcd, keeping the key,
+// value and modification revision
+func unmarshalKeyValue(b []byte) (KeyValue, error) {
+	var kv KeyValue
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return KeyValue{}, fmt.Errorf("invalid KeyValue: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+		switch {
+		case (num == fieldKVKey || num == fieldKVValue) && typ == protowire.BytesType:
+			var v []byte
+			v, n = protowire.ConsumeBytes(b)
+			if num == fieldKVKey {
+				kv.Key = v
+			} else {
+				kv.Value = v
+			}
+		case num == fieldKVModRevision && typ == protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(b)
+			kv.ModRevision = int64(v)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return KeyValue{}, fmt.Errorf("invalid KeyValue field %d: %v", num, protowire.ParseError(n))
+		}
+		b = b[n:]
+	}
+	// bbolt values are only valid during the transaction
+	kv.Key = bytes.Clone(kv.Key)
+	kv.Value = bytes.Clone(kv.Value)
+	return kv, nil
+}
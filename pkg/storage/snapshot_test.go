@@ -0,0 +1,102 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"encoding/binary"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// snapshotRevision is a revision of a key written to a test snapshot
+type snapshotRevision struct {
+	key     string
+	value   string
+	deleted bool
+}
+
+// writeSnapshot writes revisions, numbered from 1, to an etcd database
+func writeSnapshot(t *testing.T, revisions []snapshotRevision) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "snapshot.db")
+	db, err := bolt.Open(path, 0600, nil)
+	require.NoError(t, err)
+	defer db.Close() //nolint:errcheck
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket(keyBucket)
+		if err != nil {
+			return err
+		}
+		// etcd keeps other buckets, such as its metadata
+		if _, err := tx.CreateBucket([]byte("meta")); err != nil {
+			return err
+		}
+		for i, r := range revisions {
+			rev := binary.BigEndian.AppendUint64(nil, uint64(i+1))
+			rev = append(rev, '_')
+			rev = binary.BigEndian.AppendUint64(rev, 0)
+			if r.deleted {
+				rev = append(rev, markTombstone)
+			}
+			var kv []byte
+			kv = protowire.AppendTag(kv, fieldKVKey, protowire.BytesType)
+			kv = protowire.AppendString(kv, r.key)
+			kv = protowire.AppendTag(kv, 2, protowire.VarintType)
+			kv = protowire.AppendVarint(kv, 1)
+			kv = protowire.AppendTag(kv, fieldKVModRevision, protowire.VarintType)
+			kv = protowire.AppendVarint(kv, uint64(i+1))
+			if !r.deleted {
+				kv = protowire.AppendTag(kv, fieldKVValue, protowire.BytesType)
+				kv = protowire.AppendString(kv, r.value)
+			}
+			if err := b.Put(rev, kv); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	return path
+}
+
+func TestReadSnapshot(t *testing.T) {
+	path := writeSnapshot(t, []snapshotRevision{
+		{key: "/registry/secrets/default/b", value: "b1"},
+		{key: "/registry/secrets/default/a", value: "a1"},
+		{key: "/registry/secrets/default/b", value: "b2"},
+		{key: "/registry/secrets/default/c", value: "c1"},
+		{key: "/registry/secrets/default/c", deleted: true},
+		{key: "/registry/configmaps/default/d", value: "d1"},
+		{key: "compact_rev_key", value: "4"},
+	})
+
+	kvs, err := ReadSnapshot(path, []byte("/registry/secrets/"))
+	require.NoError(t, err)
+	assert.Equal(t, []KeyValue{
+		{Key: []byte("/registry/secrets/default/a"), ModRevision: 2, Value: []byte("a1")},
+		{Key: []byte("/registry/secrets/default/b"), ModRevision: 3, Value: []byte("b2")},
+	}, kvs)
+
+	kvs, err = ReadSnapshot(path, nil)
+	require.NoError(t, err)
+	assert.Len(t, kvs, 4)
+
+	_, err = ReadSnapshot(filepath.Join(t.TempDir(), "missing.db"), nil)
+	assert.ErrorContains(t, err, "failed to open etcd snapshot")
+}
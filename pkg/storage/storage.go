@@ -0,0 +1,270 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package storage parses the values kube-apiserver stores in etcd when
+// encrypting with a KMS plugin, without calling the plugin or KMS, and
+// decrypts their data once the plugin decrypted their key.
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Prefixes kube-apiserver writes before the values it encrypts with a KMS
+// plugin, followed by the name of the provider and a colon
+const (
+	PrefixKMSv1 = "k8s:enc:kms:v1:"
+	PrefixKMSv2 = "k8s:enc:kms:v2:"
+)
+
+// API versions of a Value
+const (
+	// APIVersionNone is a plugin ciphertext without kube-apiserver envelope
+	APIVersionNone = ""
+	APIVersionV1   = "v1"
+	APIVersionV2   = "v2"
+)
+
+// Value is a value stored by kube-apiserver, or a bare plugin ciphertext
+type Value struct {
+	APIVersion string
+	// Provider is the name of the KMS provider in the EncryptionConfiguration
+	Provider string
+	// PluginCiphertext is what the plugin returned when encrypting the data
+	// encryption key (v1), or the DEK source (v2)
+	PluginCiphertext []byte
+	// EncryptedData is the resource encrypted with the data encryption key
+	EncryptedData []byte
+	// Object is the envelope of v2 values
+	Object *EncryptedObject
+}
+
+// ParseValue parses a value stored by kube-apiserver, treating data without
+// a KMS prefix as a bare plugin ciphertext
+func ParseValue(data []byte) (*Value, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte(PrefixKMSv1)):
+		provider, rest, err := cutProvider(data[len(PrefixKMSv1):])
+		if err != nil {
+			return nil, err
+		}
+		// the encrypted DEK is prefixed by its length on 2 bytes
+		if len(rest) < 2 {
+			return nil, errors.New("v1 value is too short to hold the encrypted DEK length")
+		}
+		n := int(binary.BigEndian.Uint16(rest))
+		if len(rest) < 2+n {
+			return nil, fmt.Errorf("v1 value holds %d bytes, shorter than its %d bytes encrypted DEK", len(rest)-2, n)
+		}
+		return &Value{
+			APIVersion:       APIVersionV1,
+			Provider:         provider,
+			PluginCiphertext: rest[2 : 2+n],
+			EncryptedData:    rest[2+n:],
+		}, nil
+
+	case bytes.HasPrefix(data, []byte(PrefixKMSv2)):
+		provider, rest, err := cutProvider(data[len(PrefixKMSv2):])
+		if err != nil {
+			return nil, err
+		}
+		o, err := UnmarshalEncryptedObject(rest)
+		if err != nil {
+			return nil, err
+		}
+		return &Value{
+			APIVersion:       APIVersionV2,
+			Provider:         provider,
+			PluginCiphertext: o.EncryptedDEKSource,
+			EncryptedData:    o.EncryptedData,
+			Object:           o,
+		}, nil
+	}
+	return &Value{APIVersion: APIVersionNone, PluginCiphertext: data}, nil
+}
+
+func cutProvider(data []byte) (string, []byte, error) {
+	provider, rest, ok := bytes.Cut(data, []byte(":"))
+	if !ok {
+		return "", nil, errors.New("value has no provider name after its prefix")
+	}
+	return string(provider), rest, nil
+}
+
+// EncryptedDEKSourceType is how the DEK of a v2 value derives from its source
+type EncryptedDEKSourceType int32
+
+const (
+	// AESGCMKey means the DEK source is the DEK
+	AESGCMKey EncryptedDEKSourceType = 0
+	// HKDFSHA256XNonceAESGCMSeed means the DEK derives from the source seed
+	HKDFSHA256XNonceAESGCMSeed EncryptedDEKSourceType = 1
+)
+
+func (t EncryptedDEKSourceType) String() string {
+	switch t {
+	case AESGCMKey:
+		return "AES_GCM_KEY"
+	case HKDFSHA256XNonceAESGCMSeed:
+		return "HKDF_SHA256_XNONCE_AES_GCM_SEED"
+	}
+	return fmt.Sprintf("UNKNOWN(%d)", int32(t))
+}
+
+// EncryptedObject is the envelope kube-apiserver stores KMS v2 values in,
+// the EncryptedObject protobuf message of k8s.io/apiserver
+type EncryptedObject struct {
+	EncryptedData          []byte
+	KeyID                  string
+	EncryptedDEKSource     []byte
+	Annotations            map[string][]byte
+	EncryptedDEKSourceType EncryptedDEKSourceType
+}
+
+// Field numbers of EncryptedObject
+const (
+	fieldEncryptedData          = 1
+	fieldKeyID                  = 2
+	fieldEncryptedDEKSource     = 3
+	fieldAnnotations            = 4
+	fieldEncryptedDEKSourceType = 5
+
+	fieldMapKey   = 1
+	fieldMapValue = 2
+)
+
+// UnmarshalEncryptedObject decodes an EncryptedObject message, skipping
+// unknown fields
+func UnmarshalEncryptedObject(b []byte) (*EncryptedObject, error) {
+	o := &EncryptedObject{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, fmt.Errorf("invalid EncryptedObject: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+
+		switch {
+		case num == fieldEncryptedDEKSourceType && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return nil, fmt.Errorf("invalid EncryptedObject field %d: %v", num, protowire.ParseError(n))
+			}
+			o.EncryptedDEKSourceType = EncryptedDEKSourceType(v)
+			b = b[n:]
+		case num >= fieldEncryptedData && num <= fieldAnnotations && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return nil, fmt.Errorf("invalid EncryptedObject field %d: %v", num, protowire.ParseError(n))
+			}
+			b = b[n:]
+			switch num {
+			case fieldEncryptedData:
+				o.EncryptedData = v
+			case fieldKeyID:
+				o.KeyID = string(v)
+			case fieldEncryptedDEKSource:
+				o.EncryptedDEKSource = v
+			case fieldAnnotations:
+				key, value, err := unmarshalMapEntry(v)
+				if err != nil {
+					return nil, err
+				}
+				if o.Annotations == nil {
+					o.Annotations = map[string][]byte{}
+				}
+				o.Annotations[key] = value
+			}
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return nil, fmt.Errorf("invalid EncryptedObject field %d: %v", num, protowire.ParseError(n))
+			}
+			b = b[n:]
+		}
+	}
+	return o, nil
+}
+
+func unmarshalMapEntry(b []byte) (string, []byte, error) {
+	var (
+		key   string
+		value []byte
+	)
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return "", nil, fmt.Errorf("invalid annotation: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+		if typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		} else {
+			var v []byte
+			v, n = protowire.ConsumeBytes(b)
+			switch num {
+			case fieldMapKey:
+				key = string(v)
+			case fieldMapValue:
+				value = v
+			}
+		}
+		if n < 0 {
+			return "", nil, fmt.Errorf("invalid annotation: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+	}
+	return key, value, nil
+}
+
+// Marshal encodes o as kube-apiserver does, annotations sorted by key
+func (o *EncryptedObject) Marshal() []byte {
+	var b []byte
+	if len(o.EncryptedData) > 0 {
+		b = protowire.AppendTag(b, fieldEncryptedData, protowire.BytesType)
+		b = protowire.AppendBytes(b, o.EncryptedData)
+	}
+	if o.KeyID != "" {
+		b = protowire.AppendTag(b, fieldKeyID, protowire.BytesType)
+		b = protowire.AppendString(b, o.KeyID)
+	}
+	if len(o.EncryptedDEKSource) > 0 {
+		b = protowire.AppendTag(b, fieldEncryptedDEKSource, protowire.BytesType)
+		b = protowire.AppendBytes(b, o.EncryptedDEKSource)
+	}
+	keys := make([]string, 0, len(o.Annotations))
+	for k := range o.Annotations {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		var entry []byte
+		entry = protowire.AppendTag(entry, fieldMapKey, protowire.BytesType)
+		entry = protowire.AppendString(entry, k)
+		entry = protowire.AppendTag(entry, fieldMapValue, protowire.BytesType)
+		entry = protowire.AppendBytes(entry, o.Annotations[k])
+		b = protowire.AppendTag(b, fieldAnnotations, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+	if o.EncryptedDEKSourceType != 0 {
+		b = protowire.AppendTag(b, fieldEncryptedDEKSourceType, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(o.EncryptedDEKSourceType))
+	}
+	return b
+}
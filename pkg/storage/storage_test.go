@@ -0,0 +1,119 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const testARN = "arn:aws:kms:us-west-2:111122223333:key/1234abcd-12ab-34cd-56ef-1234567890ab"
+
+func TestParseValue(t *testing.T) {
+	object := &EncryptedObject{
+		EncryptedData:          []byte("encrypted secret"),
+		KeyID:                  testARN,
+		EncryptedDEKSource:     []byte("1kms-blob"),
+		Annotations:            map[string][]byte{"b.example.com": []byte("2"), "a.example.com": []byte("1")},
+		EncryptedDEKSourceType: HKDFSHA256XNonceAESGCMSeed,
+	}
+
+	tt := []struct {
+		name  string
+		input []byte
+		want  *Value
+		err   string
+	}{
+		{
+			name:  "bare",
+			input: []byte("1kms-blob"),
+			want:  &Value{PluginCiphertext: []byte("1kms-blob")},
+		},
+		{
+			name:  "v1",
+			input: append([]byte(PrefixKMSv1+"aws:\x00\x091kms-blob"), "encrypted secret"...),
+			want: &Value{
+				APIVersion:       APIVersionV1,
+				Provider:         "aws",
+				PluginCiphertext: []byte("1kms-blob"),
+				EncryptedData:    []byte("encrypted secret"),
+			},
+		},
+		{
+			name:  "v2",
+			input: append([]byte(PrefixKMSv2+"aws-encryption-provider:"), object.Marshal()...),
+			want: &Value{
+				APIVersion:       APIVersionV2,
+				Provider:         "aws-encryption-provider",
+				PluginCiphertext: object.EncryptedDEKSource,
+				EncryptedData:    object.EncryptedData,
+				Object:           object,
+			},
+		},
+		{name: "v1 without provider", input: []byte(PrefixKMSv1 + "aws"), err: "no provider name"},
+		{name: "v1 without DEK length", input: []byte(PrefixKMSv1 + "aws:\x00"), err: "too short"},
+		{name: "v1 truncated DEK", input: []byte(PrefixKMSv1 + "aws:\x00\x09short"), err: "holds 5 bytes, shorter than its 9 bytes"},
+		{name: "v2 truncated", input: append([]byte(PrefixKMSv2+"aws:"), object.Marshal()[:10]...), err: "invalid EncryptedObject"},
+		{name: "v2 trailing newline", input: append([]byte(PrefixKMSv2+"aws:"), append(object.Marshal(), '\n')...), err: "invalid EncryptedObject"},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			v, err := ParseValue(tc.input)
+			if tc.err != "" {
+				require.ErrorContains(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, v)
+		})
+	}
+}
+
+func TestUnmarshalEncryptedObjectSkipsUnknownFields(t *testing.T) {
+	b := (&EncryptedObject{KeyID: testARN}).Marshal()
+	b = protowire.AppendTag(b, 42, protowire.VarintType)
+	b = protowire.AppendVarint(b, 7)
+	b = protowire.AppendTag(b, 43, protowire.BytesType)
+	b = protowire.AppendBytes(b, []byte("future"))
+
+	o, err := UnmarshalEncryptedObject(b)
+	require.NoError(t, err)
+	assert.Equal(t, &EncryptedObject{KeyID: testARN}, o)
+}
+
+func TestInspectPluginCiphertext(t *testing.T) {
+	blob := append([]byte{0x01, 0x02, 0x02, 0x00, 0x78}, "opaque "+testARN+" opaque"...)
+	c, err := InspectPluginCiphertext(append([]byte("1"), blob...))
+	require.NoError(t, err)
+	assert.Equal(t, PluginCiphertext{
+		StorageVersion: "1",
+		KMSCiphertext: CiphertextBlob{
+			Length: len(blob),
+			Header: "0102020078",
+			Format: BlobFormatSymmetric,
+			KeyARN: testARN,
+			KeyID:  "1234abcd-12ab-34cd-56ef-1234567890ab",
+		},
+	}, c)
+
+	c, err = InspectPluginCiphertext([]byte("9\x01\x02key mrk-0123456789abcdef0123456789abcdef"))
+	require.ErrorContains(t, err, `unknown storage version 0x39, expected "1"`)
+	assert.Equal(t, CiphertextBlob{Length: 42, Header: "01026b6579", Format: BlobFormatUnknown, KeyID: "mrk-0123456789abcdef0123456789abcdef"}, c.KMSCiphertext)
+
+	_, err = InspectPluginCiphertext(nil)
+	require.ErrorContains(t, err, "empty")
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package storagetest encrypts data like the transformers of kube-apiserver,
+// to build the stored values tests decrypt
+package storagetest
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// infoSize is the size of the random info the extended nonce transformer
+// derives each key with
+const infoSize = 32
+
+// SealGCM encrypts like the AES-GCM transformer of kube-apiserver
+func SealGCM(t testing.TB, key, plaintext, etcdKey []byte) []byte {
+	t.Helper()
+	block, err := aes.NewCipher(key)
+	require.NoError(t, err)
+	gcm, err := cipher.NewGCM(block)
+	require.NoError(t, err)
+	nonce := make([]byte, gcm.NonceSize())
+	_, _ = rand.Read(nonce)
+	return gcm.Seal(nonce, nonce, plaintext, etcdKey)
+}
+
+// SealCBC encrypts like the AES-CBC transformer of kube-apiserver
+func SealCBC(t testing.TB, key, plaintext []byte) []byte {
+	t.Helper()
+	block, err := aes.NewCipher(key)
+	require.NoError(t, err)
+	n := aes.BlockSize - len(plaintext)%aes.BlockSize
+	padded := append(bytes.Clone(plaintext), bytes.Repeat([]byte{byte(n)}, n)...)
+	out := make([]byte, aes.BlockSize+len(padded))
+	_, _ = rand.Read(out[:aes.BlockSize])
+	cipher.NewCBCEncrypter(block, out[:aes.BlockSize]).CryptBlocks(out[aes.BlockSize:], padded)
+	return out
+}
+
+// SealHKDF encrypts like the extended nonce AES-GCM transformer of
+// kube-apiserver, with a key derived from seed
+func SealHKDF(t testing.TB, seed, plaintext, etcdKey []byte) []byte {
+	t.Helper()
+	info := make([]byte, infoSize)
+	_, _ = rand.Read(info)
+	key, err := hkdf.Expand(sha256.New, seed, string(info), 32)
+	require.NoError(t, err)
+	return append(info, SealGCM(t, key, plaintext, etcdKey)...)
+}
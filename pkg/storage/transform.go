@@ -0,0 +1,111 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// infoSize is the size of the random info kube-apiserver derives a key from
+// its seed with, and prefixes HKDFSHA256XNonceAESGCMSeed data with
+const infoSize = 32
+
+// DecryptData decrypts the EncryptedData of v with the key the plugin
+// decrypted from its PluginCiphertext: the DEK of v1 values and of v2 values
+// of AESGCMKey source type, or the seed of HKDFSHA256XNonceAESGCMSeed ones.
+// The data is authenticated with the etcd key the value is stored at, as
+// kube-apiserver does.
+func (v *Value) DecryptData(key, etcdKey []byte) ([]byte, error) {
+	switch v.APIVersion {
+	case APIVersionV1:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DEK: %v", err)
+		}
+		// kube-apiserver writes with AES-GCM since 1.25, and AES-CBC before
+		plaintext, gcmErr := openGCM(block, v.EncryptedData, etcdKey)
+		if gcmErr == nil {
+			return plaintext, nil
+		}
+		plaintext, cbcErr := decryptCBC(block, v.EncryptedData)
+		if cbcErr != nil {
+			return nil, fmt.Errorf("failed to decrypt with AES-GCM: %v, or AES-CBC: %v", gcmErr, cbcErr)
+		}
+		return plaintext, nil
+
+	case APIVersionV2:
+		switch t := v.Object.EncryptedDEKSourceType; t {
+		case AESGCMKey:
+			block, err := aes.NewCipher(key)
+			if err != nil {
+				return nil, fmt.Errorf("invalid DEK: %v", err)
+			}
+			return openGCM(block, v.EncryptedData, etcdKey)
+		case HKDFSHA256XNonceAESGCMSeed:
+			if len(key) < infoSize {
+				return nil, fmt.Errorf("seed of %d bytes is shorter than %d bytes", len(key), infoSize)
+			}
+			if len(v.EncryptedData) < infoSize {
+				return nil, errors.New("encrypted data is too short to hold the key derivation info")
+			}
+			info := v.EncryptedData[:infoSize]
+			derived, err := hkdf.Expand(sha256.New, key, string(info), 32)
+			if err != nil {
+				return nil, fmt.Errorf("failed to derive the DEK: %v", err)
+			}
+			block, err := aes.NewCipher(derived)
+			if err != nil {
+				return nil, fmt.Errorf("invalid derived DEK: %v", err)
+			}
+			return openGCM(block, v.EncryptedData[infoSize:], etcdKey)
+		default:
+			return nil, fmt.Errorf("unsupported DEK source type %s", t)
+		}
+	}
+	return nil, errors.New("value is not encrypted by a KMS provider")
+}
+
+// openGCM decrypts data made of a nonce followed by the AES-GCM ciphertext
+func openGCM(block cipher.Block, data, additionalData []byte) ([]byte, error) {
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("encrypted data is too short to hold the nonce")
+	}
+	return gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], additionalData)
+}
+
+// decryptCBC decrypts data made of an IV followed by the PKCS#7 padded
+// AES-CBC ciphertext
+func decryptCBC(block cipher.Block, data []byte) ([]byte, error) {
+	if len(data) < 2*aes.BlockSize || len(data)%aes.BlockSize != 0 {
+		return nil, errors.New("encrypted data is not a whole number of blocks")
+	}
+	plaintext := make([]byte, len(data)-aes.BlockSize)
+	cipher.NewCBCDecrypter(block, data[:aes.BlockSize]).CryptBlocks(plaintext, data[aes.BlockSize:])
+
+	n := int(plaintext[len(plaintext)-1])
+	if n == 0 || n > aes.BlockSize || !bytes.Equal(plaintext[len(plaintext)-n:], bytes.Repeat([]byte{byte(n)}, n)) {
+		return nil, errors.New("invalid padding")
+	}
+	return plaintext[:len(plaintext)-n], nil
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/aws-encryption-provider/pkg/storage/storagetest"
+)
+
+func TestDecryptData(t *testing.T) {
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	etcdKey := []byte("/registry/secrets/default/secret1")
+	plaintext := []byte("k8s\x00secret")
+
+	v2 := func(sourceType EncryptedDEKSourceType, data []byte) *Value {
+		return &Value{APIVersion: APIVersionV2, EncryptedData: data, Object: &EncryptedObject{EncryptedData: data, EncryptedDEKSourceType: sourceType}}
+	}
+	tt := []struct {
+		name  string
+		value *Value
+		key   []byte
+		err   string
+	}{
+		{name: "v1 gcm", value: &Value{APIVersion: APIVersionV1, EncryptedData: storagetest.SealGCM(t, key, plaintext, etcdKey)}},
+		{name: "v1 cbc", value: &Value{APIVersion: APIVersionV1, EncryptedData: storagetest.SealCBC(t, key, plaintext)}},
+		{name: "v2 aes gcm key", value: v2(AESGCMKey, storagetest.SealGCM(t, key, plaintext, etcdKey))},
+		{name: "v2 hkdf seed", value: v2(HKDFSHA256XNonceAESGCMSeed, storagetest.SealHKDF(t, key, plaintext, etcdKey))},
+		{name: "v1 other etcd key", value: &Value{APIVersion: APIVersionV1, EncryptedData: storagetest.SealGCM(t, key, plaintext, []byte("/registry/secrets/default/other"))}, err: "or AES-CBC"},
+		{name: "v2 other etcd key", value: v2(HKDFSHA256XNonceAESGCMSeed, storagetest.SealHKDF(t, key, plaintext, []byte("/registry/other"))), err: "message authentication failed"},
+		{name: "v2 short seed", value: v2(HKDFSHA256XNonceAESGCMSeed, storagetest.SealHKDF(t, key, plaintext, etcdKey)), key: key[:16], err: "seed of 16 bytes"},
+		{name: "v2 unknown source type", value: v2(7, nil), err: "unsupported DEK source type UNKNOWN(7)"},
+		{name: "invalid key", value: &Value{APIVersion: APIVersionV1}, key: []byte("short"), err: "invalid DEK"},
+		{name: "bare", value: &Value{}, err: "not encrypted by a KMS provider"},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			k := key
+			if tc.key != nil {
+				k = tc.key
+			}
+			got, err := tc.value.DecryptData(k, etcdKey)
+			if tc.err != "" {
+				assert.ErrorContains(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, plaintext, got)
+		})
+	}
+}
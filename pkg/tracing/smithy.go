@@ -0,0 +1,143 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	smithytracing "github.com/aws/smithy-go/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SDKTracerProvider returns a tracer provider for the AWS SDK that creates
+// spans with the global OpenTelemetry tracer provider. The SDK starts a span
+// for each operation, retry attempt, credential lookup, request signing and
+// HTTP round trip.
+func SDKTracerProvider() smithytracing.TracerProvider {
+	return &sdkTracerProvider{}
+}
+
+type sdkTracerProvider struct{}
+
+func (p *sdkTracerProvider) Tracer(scope string, _ ...smithytracing.TracerOption) smithytracing.Tracer {
+	// the global provider is resolved per tracer so that Setup may run after the client is created
+	return &sdkTracer{tracer: otel.Tracer(scope)}
+}
+
+type sdkTracer struct {
+	tracer trace.Tracer
+}
+
+func (t *sdkTracer) StartSpan(ctx context.Context, name string, opts ...smithytracing.SpanOption) (context.Context, smithytracing.Span) {
+	var o smithytracing.SpanOptions
+	for _, fn := range opts {
+		fn(&o)
+	}
+	ctx, span := t.tracer.Start(ctx, name,
+		trace.WithSpanKind(spanKind(o.Kind)),
+		trace.WithAttributes(attributes(o.Properties.Values())...),
+	)
+	return ctx, &sdkSpan{name: name, span: span}
+}
+
+type sdkSpan struct {
+	name string
+	span trace.Span
+}
+
+func (s *sdkSpan) Name() string {
+	return s.name
+}
+
+func (s *sdkSpan) Context() smithytracing.SpanContext {
+	sc := s.span.SpanContext()
+	return smithytracing.SpanContext{
+		TraceID:  sc.TraceID().String(),
+		SpanID:   sc.SpanID().String(),
+		IsRemote: sc.IsRemote(),
+	}
+}
+
+func (s *sdkSpan) AddEvent(name string, opts ...smithytracing.EventOption) {
+	var o smithytracing.EventOptions
+	for _, fn := range opts {
+		fn(&o)
+	}
+	s.span.AddEvent(name, trace.WithAttributes(attributes(o.Properties.Values())...))
+}
+
+func (s *sdkSpan) SetStatus(status smithytracing.SpanStatus) {
+	switch status {
+	case smithytracing.SpanStatusOK:
+		s.span.SetStatus(codes.Ok, "")
+	case smithytracing.SpanStatusError:
+		s.span.SetStatus(codes.Error, "")
+	}
+}
+
+func (s *sdkSpan) SetProperty(k, v any) {
+	s.span.SetAttributes(attribute.KeyValue{Key: attribute.Key(fmt.Sprint(k)), Value: value(v)})
+}
+
+func (s *sdkSpan) End() {
+	s.span.End()
+}
+
+func spanKind(kind smithytracing.SpanKind) trace.SpanKind {
+	switch kind {
+	case smithytracing.SpanKindClient:
+		return trace.SpanKindClient
+	case smithytracing.SpanKindServer:
+		return trace.SpanKindServer
+	case smithytracing.SpanKindProducer:
+		return trace.SpanKindProducer
+	case smithytracing.SpanKindConsumer:
+		return trace.SpanKindConsumer
+	default:
+		return trace.SpanKindInternal
+	}
+}
+
+func attributes(props map[any]any) []attribute.KeyValue {
+	attrs := make([]attribute.KeyValue, 0, len(props))
+	for k, v := range props {
+		attrs = append(attrs, attribute.KeyValue{Key: attribute.Key(fmt.Sprint(k)), Value: value(v)})
+	}
+	return attrs
+}
+
+func value(v any) attribute.Value {
+	switch v := v.(type) {
+	case string:
+		return attribute.StringValue(v)
+	case bool:
+		return attribute.BoolValue(v)
+	case int:
+		return attribute.IntValue(v)
+	case int32:
+		return attribute.Int64Value(int64(v))
+	case int64:
+		return attribute.Int64Value(v)
+	case float64:
+		return attribute.Float64Value(v)
+	case []string:
+		return attribute.StringSliceValue(v)
+	default:
+		return attribute.StringValue(fmt.Sprint(v))
+	}
+}
@@ -13,10 +13,55 @@ limitations under the License.
 
 package version
 
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strings"
+)
+
 var (
 	APIVersion string = "v1beta1"
-	Runtime    string = "AWSKMS"
-	Version    string = "UNKNOWN"
-	Commit     string = "UNKNOWN"
-	Date       string = "UNKNOWN"
+	// APIVersionV2 is the KMS v2 API version reported by Status
+	APIVersionV2 string = "v2beta1"
+	Runtime      string = "AWSKMS"
+	Version      string = "UNKNOWN"
+	Commit       string = "UNKNOWN"
+	Date         string = "UNKNOWN"
 )
+
+// Info describes the running build
+type Info struct {
+	Version     string   `json:"version"`
+	Commit      string   `json:"commit"`
+	Date        string   `json:"date"`
+	GoVersion   string   `json:"goVersion"`
+	Runtime     string   `json:"runtime"`
+	APIVersions []string `json:"apiVersions"`
+}
+
+// Get returns the Info of the running build
+func Get() Info {
+	return Info{
+		Version:     Version,
+		Commit:      Commit,
+		Date:        Date,
+		GoVersion:   runtime.Version(),
+		Runtime:     Runtime,
+		APIVersions: []string{APIVersion, APIVersionV2},
+	}
+}
+
+func (i Info) String() string {
+	return fmt.Sprintf("aws-encryption-provider %s (commit %s, built %s, %s, KMS API %s)",
+		i.Version, i.Commit, i.Date, i.GoVersion, strings.Join(i.APIVersions, ", "))
+}
+
+// Handler serves the Info of the running build as JSON
+func Handler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(rw).Encode(Get())
+	})
+}
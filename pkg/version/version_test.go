@@ -0,0 +1,48 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package version
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/version", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var info Info
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &info))
+	assert.Equal(t, Info{
+		Version:     Version,
+		Commit:      Commit,
+		Date:        Date,
+		GoVersion:   runtime.Version(),
+		Runtime:     Runtime,
+		APIVersions: []string{"v1beta1", "v2beta1"},
+	}, info)
+}
+
+func TestString(t *testing.T) {
+	info := Info{Version: "v1.2.3", Commit: "abc123", Date: "2024-01-02", GoVersion: "go1.22", APIVersions: []string{"v1beta1", "v2beta1"}}
+	assert.Equal(t, "aws-encryption-provider v1.2.3 (commit abc123, built 2024-01-02, go1.22, KMS API v1beta1, v2beta1)", info.String())
+}
@@ -41,7 +41,7 @@ func setup(t *testing.T) (string, *server.Server, *cloud.KMSMock, pb.KeyManageme
 	sharedHealthCheck := plugin.NewSharedHealthCheck(plugin.DefaultHealthCheckPeriod, plugin.DefaultErrcBufSize)
 	go sharedHealthCheck.Start()
 	defer sharedHealthCheck.Stop()
-	p := plugin.New(key, c, nil, sharedHealthCheck)
+	p := plugin.New(key, c, nil, sharedHealthCheck, nil, nil)
 	p.Register(s.Server)
 	dir, err := os.MkdirTemp("", "run")
 	if err != nil {